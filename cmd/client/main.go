@@ -5,6 +5,11 @@ import (
 	"errors"
 	"fmt"
 	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/LukaGiorgadze/bloXroute/configs"
 	"github.com/LukaGiorgadze/bloXroute/internal/client"
@@ -14,6 +19,9 @@ import (
 	"github.com/nats-io/nats.go"
 )
 
+// ErrItemNotFound is returned when the server replies without the item and without the error.
+var ErrItemNotFound = errors.New("Item not found.")
+
 func main() {
 
 	cfg, err := configs.NewConfig()
@@ -44,87 +52,222 @@ func main() {
 	var key string
 	var val string
 	var random int
+	var ttl int64
+	var rev uint64
+	var tail bool
+	var batchSize int
+	var file string
+	var prefix string
 	var stress int = 1
+	var limit int
+	var after string
+	var cursor string
+	var reverse bool
+	var pattern string
+	var lexical bool
+	var from string
+	var to string
+	var asOf uint64
+	var bucket string
+	var doc bool
+	var path string
+	var out string
+	var by int64
+	var storeType string
+	var maxItems int
+	var maxBytes int64
+	var eviction string
+	var node string
+	var wait int = 2
+	// Sequences of the mutation given by the client, see models.Msg.
+	var seqs models.Msg
 
 	app.Add(&gcli.Command{
 		Name: "get",
-		Desc: "<info>get</> retrieves the list. <info>get -k {key}</> get specific item. <info>get -k {key} -stress {n}</> send <info>{n}</> amount of req.",
+		Desc: "<info>get</> retrieves the list page by page. <info>get -limit {n}</> retrieves one page, continue it with <info>-cursor {next}</> or <info>-after {key}</>, <info>-reverse</> lists from the end. <info>get -prefix {prefix}</> or <info>-pattern {glob}</> retrieves matching items, <info>-lexical</> sorts them by key. <info>get -from {key} -to {key}</> retrieves items with keys in the range, sorted by key. <info>-asof {seq}</> reads the store version with the sequence (mvcc store only). <info>get -k {key}</> get specific item, <info>-path {path}</> selects the part of it's JSON value, e.g. servers[0].host, <info>-out {file}</> writes the raw bytes of the value to the file. <info>get -k {key} -stress {n}</> send <info>{n}</> amount of req.",
 		Func: func(cmd *gcli.Command, args []string) (err error) {
 
-			var data []byte
-			var subj client.Subject = client.ItemGetListSubject
-
-			if key != "" {
-				data, err = json.Marshal(models.Item{
-					Key: key,
-				})
-				subj = client.ItemGetSubject
+			if key == "" {
+				list := models.Msg{Limit: limit, After: after, Cursor: cursor, Reverse: reverse, Prefix: prefix, Pattern: pattern, Lexical: lexical, From: from, To: to, AsOf: asOf}
+				subj := client.ItemGetListSubject
+				switch {
+				case from != "" || to != "":
+					subj = client.ItemGetRangeSubject
+				case prefix != "" || pattern != "":
+					subj = client.ItemGetPrefixSubject
+				}
+				for i := 0; i < stress; i++ {
+					// Without the limit all pages are retrieved.
+					if err = getList(msgClient, client.BucketSubject(bucket, subj), cfg.RequestTimeout, list, limit == 0); err != nil {
+						return
+					}
+				}
+				return
 			}
+
+			data, err := json.Marshal(models.Msg{
+				Item: models.Item{Key: key},
+				Path: path,
+				AsOf: asOf,
+			})
 			if err != nil {
 				return
 			}
 
+			// The raw bytes of the value are written to the file instead of printing the item.
+			if out != "" {
+				return saveValue(msgClient, client.BucketSubject(bucket, client.ItemGetOneSubject), cfg.RequestTimeout, data, out)
+			}
+
 			for i := 0; i < stress; i++ {
-				err = msgClient.Publish(subj, data)
+				var msg *nats.Msg
+				msg, err = msgClient.Request(client.BucketSubject(bucket, client.ItemGetOneSubject), data, cfg.RequestTimeout)
+				if err != nil {
+					return
+				}
+				if err = printResponse(msg.Data); err != nil {
+					return
+				}
 			}
 			return
 
 		},
 		Config: func(c *gcli.Command) {
 			c.StrOpt(&key, "k", "", "", "")
+			c.StrOpt(&bucket, "bucket", "", "", "")
 			c.IntOpt(&stress, "stress", "", stress, "")
+			c.IntOpt(&limit, "limit", "", 0, "")
+			c.StrOpt(&after, "after", "", "", "")
+			c.StrOpt(&cursor, "cursor", "", "", "")
+			c.BoolOpt(&reverse, "reverse", "", false, "")
+			c.StrOpt(&prefix, "prefix", "", "", "")
+			c.StrOpt(&pattern, "pattern", "", "", "")
+			c.BoolOpt(&lexical, "lexical", "", false, "")
+			c.StrOpt(&from, "from", "", "", "")
+			c.StrOpt(&to, "to", "", "", "")
+			c.Uint64Opt(&asOf, "asof", "", 0, "")
+			c.StrOpt(&path, "path", "", "", "")
+			c.StrOpt(&out, "out", "", "", "")
 		},
 	})
 
 	app.Add(&gcli.Command{
 		Name: "add",
-		Desc: "<info>add -k {key} -v {value}</> or use <info>add random {N}</> to add random N items",
+		Desc: "<info>add -k {key} -v {value}</>, <info>add -k {key} -v {value} -ttl {seconds}</> to add expiring item, <info>-json</> sends the value as the JSON document, <info>-file {path}</> sends raw bytes of the file (encoded as base64), or use <info>add random {N}</> to add random N items",
 		Func: func(cmd *gcli.Command, args []string) error {
-			if key == "" || val == "" {
+			if key == "" || (val == "" && file == "") {
 				return errors.New("key and value should not be empty.")
 			}
+			value, err := itemValue(val, doc, file)
+			if err != nil {
+				return err
+			}
 
-			data, err := json.Marshal(models.Item{
-				Key:   key,
-				Value: val,
-			})
+			data, err := json.Marshal(sequenced(models.Msg{
+				Item: models.Item{
+					Key:   key,
+					Value: value,
+					TTL:   ttl,
+				},
+			}, seqs))
 			if err != nil {
 				return err
 			}
 
-			if err := msgClient.Publish(client.ItemMutateAddSubject, data); err != nil {
+			// The mutation with the sequences waits for the outcome, since it may be rejected.
+			if hasSeqs(seqs) {
+				msg, err := msgClient.Request(client.BucketSubject(bucket, client.ItemMutateAddSubject), data, cfg.RequestTimeout)
+				if err != nil {
+					return err
+				}
+				return printResponse(msg.Data)
+			}
+
+			if err := msgClient.Publish(client.BucketSubject(bucket, client.ItemMutateAddSubject), data); err != nil {
 				return err
 			}
 			return nil
 		},
 		Config: func(c *gcli.Command) {
 			c.StrOpt(&key, "k", "", "", "")
+			c.StrOpt(&bucket, "bucket", "", "", "")
 			c.StrOpt(&val, "v", "", "", "")
+			c.BoolOpt(&doc, "json", "", false, "")
+			c.StrOpt(&file, "file", "", "", "")
+			c.Int64Opt(&ttl, "ttl", "", 0, "")
+			c.Uint64Opt(&seqs.ExpectSeq, "expect", "", 0, "")
+			c.StrOpt(&seqs.Producer, "producer", "", "", "")
+			c.Uint64Opt(&seqs.ProducerSeq, "pseq", "", 0, "")
 		},
 		Subs: []*gcli.Command{
 			{
 				Name: "random",
-				Desc: "<info>add random {N}</> items",
+				Desc: "<info>add random -n {N}</> items, sent one by one or in batches of <info>-b {size}</> items",
 				Func: func(cmd *gcli.Command, args []string) error {
 
-					for i := 1; i <= random; i++ {
-						data, err := json.Marshal(models.Item{
-							Key:   fmt.Sprintf("key_%d", i),
-							Value: fmt.Sprintf("Value %d", i),
-						})
+					if batchSize <= 0 {
+						for i := 1; i <= random; i++ {
+							data, err := json.Marshal(models.Item{
+								Key:   fmt.Sprintf("key_%d", i),
+								Value: models.StringValue(fmt.Sprintf("Value %d", i)),
+							})
+							if err != nil {
+								return err
+							}
+
+							if err := msgClient.Publish(client.BucketSubject(bucket, client.ItemMutateAddSubject), data); err != nil {
+								return err
+							}
+						}
+
+						return nil
+					}
+
+					// Every batch is a single message which is applied all or nothing, so the client waits for it's outcome.
+					// The failed batch (e.g. with the key which already exists) is reported and the next ones are sent.
+					failed := 0
+					for i := 1; i <= random; i += batchSize {
+						batch := models.Msg{}
+						last := i
+						for j := i; j < i+batchSize && j <= random; j++ {
+							last = j
+							batch.Ops = append(batch.Ops, models.Msg{
+								Op: "add",
+								Item: models.Item{
+									Key:   fmt.Sprintf("key_%d", j),
+									Value: models.StringValue(fmt.Sprintf("Value %d", j)),
+								},
+							})
+						}
+
+						data, err := json.Marshal(batch)
 						if err != nil {
 							return err
 						}
 
-						if err := msgClient.Publish(client.ItemMutateAddSubject, data); err != nil {
+						msg, err := msgClient.Request(client.BucketSubject(bucket, client.ItemMutateBatchSubject), data, cfg.RequestTimeout)
+						if err != nil {
+							return err
+						}
+						var resp models.Response
+						if err := json.Unmarshal(msg.Data, &resp); err != nil {
 							return err
 						}
+						if resp.Error != "" {
+							failed++
+							fmt.Printf("key_%d - key_%d: %s\n", i, last, resp.Error)
+						}
 					}
 
+					if failed > 0 {
+						return fmt.Errorf("%d of the batches failed.", failed)
+					}
 					return nil
 				},
 				Config: func(c *gcli.Command) {
 					c.IntOpt(&random, "n", "", random, "")
+					c.IntOpt(&batchSize, "b", "", batchSize, "")
+					c.StrOpt(&bucket, "bucket", "", "", "")
 				},
 			},
 		},
@@ -132,29 +275,701 @@ func main() {
 
 	app.Add(&gcli.Command{
 		Name: "delete",
-		Desc: "<info>delete -k {key}</>",
+		Desc: "<info>delete -k {key}</> or <info>delete -k {key} -rev {revision}</> to delete only if the item has the revision",
 		Func: func(cmd *gcli.Command, args []string) error {
 			if key == "" {
 				return errors.New("key should not be empty.")
 			}
 
-			data, err := json.Marshal(models.Item{
-				Key: key,
-			})
+			data, err := json.Marshal(sequenced(models.Msg{
+				Item: models.Item{
+					Key:      key,
+					Revision: rev,
+				},
+			}, seqs))
+			if err != nil {
+				return err
+			}
+
+			// Conditional delete waits for the outcome.
+			if rev != 0 || hasSeqs(seqs) {
+				msg, err := msgClient.Request(client.BucketSubject(bucket, client.ItemMutateDeleteSubject), data, cfg.RequestTimeout)
+				if err != nil {
+					return err
+				}
+				return printResponse(msg.Data)
+			}
+
+			if err := msgClient.Publish(client.BucketSubject(bucket, client.ItemMutateDeleteSubject), data); err != nil {
+				return err
+			}
+			return nil
+		},
+		Config: func(c *gcli.Command) {
+			c.StrOpt(&key, "k", "", "", "")
+			c.StrOpt(&bucket, "bucket", "", "", "")
+			c.Uint64Opt(&rev, "rev", "", 0, "")
+			c.Uint64Opt(&seqs.ExpectSeq, "expect", "", 0, "")
+			c.StrOpt(&seqs.Producer, "producer", "", "", "")
+			c.Uint64Opt(&seqs.ProducerSeq, "pseq", "", 0, "")
+		},
+	})
+
+	app.Add(&gcli.Command{
+		Name: "batch",
+		Desc: "<info>batch -f {file}</> applies operations from the JSON file all or nothing, e.g. <info>[{\"op\":\"add\",\"key\":\"a\",\"value\":\"1\"},{\"op\":\"delete\",\"key\":\"b\"}]</>",
+		Func: func(cmd *gcli.Command, args []string) error {
+			if file == "" {
+				return errors.New("file should not be empty.")
+			}
+
+			content, err := os.ReadFile(file)
+			if err != nil {
+				return err
+			}
+
+			batch := sequenced(models.Msg{}, seqs)
+			if err := json.Unmarshal(content, &batch.Ops); err != nil {
+				return err
+			}
+
+			data, err := json.Marshal(batch)
+			if err != nil {
+				return err
+			}
+
+			msg, err := msgClient.Request(client.BucketSubject(bucket, client.ItemMutateBatchSubject), data, cfg.RequestTimeout)
+			if err != nil {
+				return err
+			}
+			return printResponse(msg.Data)
+		},
+		Config: func(c *gcli.Command) {
+			c.StrOpt(&file, "f", "", "", "")
+			c.StrOpt(&bucket, "bucket", "", "", "")
+			c.Uint64Opt(&seqs.ExpectSeq, "expect", "", 0, "")
+			c.StrOpt(&seqs.Producer, "producer", "", "", "")
+			c.Uint64Opt(&seqs.ProducerSeq, "pseq", "", 0, "")
+		},
+	})
+
+	app.Add(&gcli.Command{
+		Name: "update",
+		Desc: "<info>update -k {key} -v {value}</> updates the item in place or adds it if it doesn't exist, <info>-tail</> moves it to the end, <info>-json</> and <info>-file {path}</> send the value as in <info>add</>",
+		Func: func(cmd *gcli.Command, args []string) error {
+			if key == "" || (val == "" && file == "") {
+				return errors.New("key and value should not be empty.")
+			}
+			value, err := itemValue(val, doc, file)
+			if err != nil {
+				return err
+			}
+
+			data, err := json.Marshal(sequenced(models.Msg{
+				Item: models.Item{
+					Key:   key,
+					Value: value,
+					TTL:   ttl,
+				},
+				Tail: tail,
+			}, seqs))
+			if err != nil {
+				return err
+			}
+
+			msg, err := msgClient.Request(client.BucketSubject(bucket, client.ItemMutateUpdateSubject), data, cfg.RequestTimeout)
+			if err != nil {
+				return err
+			}
+			return printResponse(msg.Data)
+		},
+		Config: func(c *gcli.Command) {
+			c.StrOpt(&key, "k", "", "", "")
+			c.StrOpt(&bucket, "bucket", "", "", "")
+			c.StrOpt(&val, "v", "", "", "")
+			c.BoolOpt(&doc, "json", "", false, "")
+			c.StrOpt(&file, "file", "", "", "")
+			c.BoolOpt(&tail, "tail", "", false, "")
+			c.Int64Opt(&ttl, "ttl", "", 0, "")
+			c.Uint64Opt(&seqs.ExpectSeq, "expect", "", 0, "")
+			c.StrOpt(&seqs.Producer, "producer", "", "", "")
+			c.Uint64Opt(&seqs.ProducerSeq, "pseq", "", 0, "")
+		},
+	})
+
+	app.Add(&gcli.Command{
+		Name: "cas",
+		Desc: "<info>cas -k {key} -v {value} -rev {revision}</> updates the item only if it has the revision, <info>-rev 0</> creates it only if it doesn't exist, <info>-json</> and <info>-file {path}</> send the value as in <info>add</>",
+		Func: func(cmd *gcli.Command, args []string) error {
+			if key == "" || (val == "" && file == "") {
+				return errors.New("key and value should not be empty.")
+			}
+			value, err := itemValue(val, doc, file)
+			if err != nil {
+				return err
+			}
+
+			data, err := json.Marshal(sequenced(models.Msg{
+				Item: models.Item{
+					Key:      key,
+					Value:    value,
+					TTL:      ttl,
+					Revision: rev,
+				},
+				Tail: tail,
+			}, seqs))
+			if err != nil {
+				return err
+			}
+
+			msg, err := msgClient.Request(client.BucketSubject(bucket, client.ItemMutateCasSubject), data, cfg.RequestTimeout)
+			if err != nil {
+				return err
+			}
+			return printResponse(msg.Data)
+		},
+		Config: func(c *gcli.Command) {
+			c.StrOpt(&key, "k", "", "", "")
+			c.StrOpt(&bucket, "bucket", "", "", "")
+			c.StrOpt(&val, "v", "", "", "")
+			c.BoolOpt(&doc, "json", "", false, "")
+			c.StrOpt(&file, "file", "", "", "")
+			c.Uint64Opt(&rev, "rev", "", 0, "")
+			c.BoolOpt(&tail, "tail", "", false, "")
+			c.Int64Opt(&ttl, "ttl", "", 0, "")
+			c.Uint64Opt(&seqs.ExpectSeq, "expect", "", 0, "")
+			c.StrOpt(&seqs.Producer, "producer", "", "", "")
+			c.Uint64Opt(&seqs.ProducerSeq, "pseq", "", 0, "")
+		},
+	})
+
+	app.Add(&gcli.Command{
+		Name: "incr",
+		Desc: "<info>incr -k {key}</> increments the integer value of the item and retrieves it, <info>-by {n}</> adds <info>{n}</> instead of 1. The missing item is created from 0",
+		Func: func(cmd *gcli.Command, args []string) error {
+			return increment(msgClient, client.BucketSubject(bucket, client.ItemMutateIncrSubject), cfg.RequestTimeout, key, by, ttl, seqs)
+		},
+		Config: func(c *gcli.Command) {
+			c.StrOpt(&key, "k", "", "", "")
+			c.StrOpt(&bucket, "bucket", "", "", "")
+			c.Int64Opt(&by, "by", "", 1, "")
+			c.Int64Opt(&ttl, "ttl", "", 0, "")
+			c.Uint64Opt(&seqs.ExpectSeq, "expect", "", 0, "")
+			c.StrOpt(&seqs.Producer, "producer", "", "", "")
+			c.Uint64Opt(&seqs.ProducerSeq, "pseq", "", 0, "")
+		},
+	})
+
+	app.Add(&gcli.Command{
+		Name: "decr",
+		Desc: "<info>decr -k {key}</> decrements the integer value of the item and retrieves it, <info>-by {n}</> subtracts <info>{n}</> instead of 1. The missing item is created from 0",
+		Func: func(cmd *gcli.Command, args []string) error {
+			return increment(msgClient, client.BucketSubject(bucket, client.ItemMutateDecrSubject), cfg.RequestTimeout, key, by, ttl, seqs)
+		},
+		Config: func(c *gcli.Command) {
+			c.StrOpt(&key, "k", "", "", "")
+			c.StrOpt(&bucket, "bucket", "", "", "")
+			c.Int64Opt(&by, "by", "", 1, "")
+			c.Int64Opt(&ttl, "ttl", "", 0, "")
+			c.Uint64Opt(&seqs.ExpectSeq, "expect", "", 0, "")
+			c.StrOpt(&seqs.Producer, "producer", "", "", "")
+			c.Uint64Opt(&seqs.ProducerSeq, "pseq", "", 0, "")
+		},
+	})
+
+	app.Add(&gcli.Command{
+		Name: "history",
+		Desc: "<info>history -k {key}</> retrieves recent changes of the item, including it's removal, from the oldest one",
+		Func: func(cmd *gcli.Command, args []string) error {
+			data, err := json.Marshal(models.Msg{Item: models.Item{Key: key}})
+			if err != nil {
+				return err
+			}
+
+			msg, err := msgClient.Request(client.BucketSubject(bucket, client.ItemGetHistorySubject), data, cfg.RequestTimeout)
+			if err != nil {
+				return err
+			}
+
+			var resp models.Response
+			if err := json.Unmarshal(msg.Data, &resp); err != nil {
+				return err
+			}
+			if resp.Error != "" {
+				return errors.New(resp.Error)
+			}
+
+			for _, event := range resp.History {
+				fmt.Print(time.Unix(0, event.Time).Format(time.RFC3339Nano), " ")
+				printEvent(event)
+			}
+			return nil
+		},
+		Config: func(c *gcli.Command) {
+			c.StrOpt(&key, "k", "", "", "")
+			c.StrOpt(&bucket, "bucket", "", "", "")
+		},
+	})
+
+	app.Add(&gcli.Command{
+		Name: "stats",
+		Desc: "<info>stats</> retrieves the number and the size of items of the bounded store and the number of evicted items",
+		Func: func(cmd *gcli.Command, args []string) error {
+			msg, err := msgClient.Request(client.BucketSubject(bucket, client.ItemGetStatsSubject), []byte("{}"), cfg.RequestTimeout)
 			if err != nil {
 				return err
 			}
 
-			if err := msgClient.Publish(client.ItemMutateDeleteSubject, data); err != nil {
+			var resp models.Response
+			if err := json.Unmarshal(msg.Data, &resp); err != nil {
+				return err
+			}
+			if resp.Error != "" {
+				return errors.New(resp.Error)
+			}
+
+			// Zero limits mean there is no limit.
+			stats := resp.Stats
+			fmt.Printf("items: %d (max %d)\n", stats.Items, stats.MaxItems)
+			fmt.Printf("bytes: %d (max %d)\n", stats.Bytes, stats.MaxBytes)
+			fmt.Printf("evictions: %d (%s)\n", stats.Evictions, stats.Policy)
+			return nil
+		},
+		Config: func(c *gcli.Command) {
+			c.StrOpt(&bucket, "bucket", "", "", "")
+		},
+	})
+
+	app.Add(&gcli.Command{
+		Name: "bucket",
+		Desc: "<info>bucket list</> retrieves the named buckets, <info>bucket create -name {name}</> creates the bucket with it's own store, <info>bucket delete -name {name}</> deletes it with all of it's items. Items of the bucket are accessed by other commands with <info>-bucket {name}</>",
+		Subs: []*gcli.Command{
+			{
+				Name: "list",
+				Desc: "<info>bucket list</> retrieves the named buckets",
+				Func: func(cmd *gcli.Command, args []string) error {
+					return requestBucket(msgClient, client.BucketListSubject, cfg.RequestTimeout, models.Bucket{})
+				},
+			},
+			{
+				Name: "create",
+				Desc: "<info>bucket create -name {name}</> creates the bucket, <info>-store {type}</> of the store, <info>-max-items {n}</> and <info>-max-bytes {n}</> bound it with the <info>-eviction {policy}</>",
+				Func: func(cmd *gcli.Command, args []string) error {
+					spec := models.Bucket{Name: bucket, StoreType: storeType, MaxItems: maxItems, MaxBytes: maxBytes, Eviction: eviction}
+					return requestBucket(msgClient, client.BucketCreateSubject, cfg.RequestTimeout, spec)
+				},
+				Config: func(c *gcli.Command) {
+					c.StrOpt(&bucket, "name", "", "", "")
+					c.StrOpt(&storeType, "store", "", "", "")
+					c.IntOpt(&maxItems, "max-items", "", 0, "")
+					c.Int64Opt(&maxBytes, "max-bytes", "", 0, "")
+					c.StrOpt(&eviction, "eviction", "", "", "")
+				},
+			},
+			{
+				Name: "delete",
+				Desc: "<info>bucket delete -name {name}</> deletes the bucket with all of it's items",
+				Func: func(cmd *gcli.Command, args []string) error {
+					return requestBucket(msgClient, client.BucketDeleteSubject, cfg.RequestTimeout, models.Bucket{Name: bucket})
+				},
+				Config: func(c *gcli.Command) {
+					c.StrOpt(&bucket, "name", "", "", "")
+				},
+			},
+		},
+	})
+
+	app.Add(&gcli.Command{
+		Name: "replication",
+		Desc: "<info>replication status</> retrieves the status of the replicas, <info>replication promote -node {name}</> promotes the follower to the leader",
+		Subs: []*gcli.Command{
+			{
+				Name: "status",
+				Desc: "<info>replication status</> prints the replicas which published their heartbeat in <info>-wait {seconds}</>",
+				Func: func(cmd *gcli.Command, args []string) error {
+					return replicationStatus(msgClient, time.Duration(wait)*time.Second)
+				},
+				Config: func(c *gcli.Command) {
+					c.IntOpt(&wait, "wait", "", 2, "")
+				},
+			},
+			{
+				Name: "promote",
+				Desc: "<info>replication promote -node {name}</> promotes the follower to the leader of the next term, the previous leader steps down",
+				Func: func(cmd *gcli.Command, args []string) error {
+					data, err := json.Marshal(models.ReplicationStatus{Node: node})
+					if err != nil {
+						return err
+					}
+					msg, err := msgClient.Request(client.ReplicationPromoteSubject, data, cfg.RequestTimeout)
+					if err != nil {
+						return err
+					}
+					var status models.ReplicationStatus
+					if err := json.Unmarshal(msg.Data, &status); err != nil {
+						return err
+					}
+					if status.Error != "" {
+						return errors.New(status.Error)
+					}
+					printReplica(status)
+					return nil
+				},
+				Config: func(c *gcli.Command) {
+					c.StrOpt(&node, "node", "", "", "")
+				},
+			},
+		},
+	})
+
+	app.Add(&gcli.Command{
+		Name: "raft",
+		Desc: "<info>raft status</> retrieves the status of the Raft cluster, <info>raft add -node {name}</> and <info>raft remove -node {name}</> change it's members",
+		Subs: []*gcli.Command{
+			{
+				Name: "status",
+				Desc: "<info>raft status</> prints the leader and the position of every member in it's log",
+				Func: func(cmd *gcli.Command, args []string) error {
+					return requestRaft(msgClient, client.RaftStatusSubject, cfg.RequestTimeout, models.RaftMember{})
+				},
+			},
+			{
+				Name: "add",
+				Desc: "<info>raft add -node {name}</> adds the node to the cluster, it should be started without peers",
+				Func: func(cmd *gcli.Command, args []string) error {
+					return requestRaft(msgClient, client.RaftAddSubject, cfg.RequestTimeout, models.RaftMember{Node: node})
+				},
+				Config: func(c *gcli.Command) {
+					c.StrOpt(&node, "node", "", "", "")
+				},
+			},
+			{
+				Name: "remove",
+				Desc: "<info>raft remove -node {name}</> removes the node from the cluster, the leader steps down if it's removed",
+				Func: func(cmd *gcli.Command, args []string) error {
+					return requestRaft(msgClient, client.RaftRemoveSubject, cfg.RequestTimeout, models.RaftMember{Node: node})
+				},
+				Config: func(c *gcli.Command) {
+					c.StrOpt(&node, "node", "", "", "")
+				},
+			},
+		},
+	})
+
+	app.Add(&gcli.Command{
+		Name: "watch",
+		Desc: "<info>watch</> streams changes of all items, <info>watch -k {key}</> of the item, <info>watch -prefix {prefix}</> of the items with the key prefix",
+		Func: func(cmd *gcli.Command, args []string) error {
+			subj := client.ItemEventsSubject
+			if key != "" {
+				subj = client.ItemEventsKeySubject(key)
+			}
+			subj = client.BucketSubject(bucket, subj)
+
+			err := msgClient.Subscribe(subj, func(msg *nats.Msg) {
+				var event models.Event
+				if err := json.Unmarshal(msg.Data, &event); err != nil {
+					color.Error.Println(err)
+					return
+				}
+				// Prefix can't be expressed with subject wildcards, so events are filtered here.
+				if !strings.HasPrefix(event.Key, prefix) {
+					return
+				}
+				printEvent(event)
+			})
+			if err != nil {
 				return err
 			}
+			defer msgClient.Unsubscribe(subj)
+
+			// Watch until interrupted.
+			c := make(chan os.Signal, 1)
+			signal.Notify(c, os.Interrupt)
+			<-c
 			return nil
 		},
 		Config: func(c *gcli.Command) {
 			c.StrOpt(&key, "k", "", "", "")
+			c.StrOpt(&bucket, "bucket", "", "", "")
+			c.StrOpt(&prefix, "prefix", "", "", "")
 		},
 	})
 
 	app.Run(nil)
 
 }
+
+// printResponse prints the item(s) returned by the server.
+// Every item is printed as it's JSON encoding on a separate line, lists are printed by getList.
+// The current item returned with the error (e.g. when the revision doesn't match) is printed as well.
+func printResponse(data []byte) error {
+	var resp models.Response
+	if err := json.Unmarshal(data, &resp); err != nil {
+		return err
+	}
+
+	for _, item := range resp.Items {
+		fmt.Println(item.String())
+	}
+	// Replies to mutations have the sequence of the applied mutation, or of the last one if it was rejected.
+	if resp.MutationSeq != 0 {
+		fmt.Println("mutation:", resp.MutationSeq)
+	}
+	if resp.Error != "" {
+		return errors.New(resp.Error)
+	}
+	return nil
+}
+
+// sequenced sets the sequences given by the options (-expect, -producer and -pseq) in the mutation.
+func sequenced(mutation models.Msg, seqs models.Msg) models.Msg {
+	mutation.ExpectSeq = seqs.ExpectSeq
+	mutation.Producer = seqs.Producer
+	mutation.ProducerSeq = seqs.ProducerSeq
+	return mutation
+}
+
+// hasSeqs reports whether any of the sequences is given.
+func hasSeqs(seqs models.Msg) bool {
+	return seqs.ExpectSeq != 0 || seqs.ProducerSeq != 0
+}
+
+// getList requests the list page by page and prints items as they arrive, as a single JSON array.
+// If all is false, only the first page is printed, followed by the cursor of the next one.
+func getList(msgClient client.IMessageClient, subj client.Subject, timeout time.Duration, list models.Msg, all bool) error {
+	printed := false
+	for {
+		data, err := json.Marshal(list)
+		if err != nil {
+			return err
+		}
+
+		msg, err := msgClient.Request(subj, data, timeout)
+		if err != nil {
+			return err
+		}
+
+		var resp models.Response
+		if err := json.Unmarshal(msg.Data, &resp); err != nil {
+			return err
+		}
+		if resp.Error != "" {
+			if printed {
+				fmt.Println("]")
+			}
+			return errors.New(resp.Error)
+		}
+
+		for _, item := range resp.Items {
+			if printed {
+				fmt.Print(",")
+			} else {
+				fmt.Print("[")
+			}
+			fmt.Print(item.String())
+			printed = true
+		}
+
+		if resp.Next == "" || !all {
+			if !printed {
+				fmt.Print("[")
+			}
+			fmt.Println("]")
+			if resp.Next != "" {
+				fmt.Println("next:", resp.Next)
+				if resp.Seq != 0 {
+					fmt.Println("seq:", resp.Seq)
+				}
+			}
+			if !all && resp.MutationSeq != 0 {
+				fmt.Println("mutation:", resp.MutationSeq)
+			}
+			return nil
+		}
+
+		// The next page continues after the cursor, even if the item was removed in the meantime.
+		// The versioned store returns the sequence of the version, so all pages are read from the same one.
+		list.After = ""
+		list.Cursor = resp.Next
+		if list.AsOf == 0 {
+			list.AsOf = resp.Seq
+		}
+	}
+}
+
+// increment changes the counter by the amount and prints the item with the result.
+func increment(msgClient client.IMessageClient, subj client.Subject, timeout time.Duration, key string, by int64, ttl int64, seqs models.Msg) error {
+	if key == "" {
+		return errors.New("key should not be empty.")
+	}
+
+	data, err := json.Marshal(sequenced(models.Msg{
+		Item: models.Item{
+			Key: key,
+			TTL: ttl,
+		},
+		By: by,
+	}, seqs))
+	if err != nil {
+		return err
+	}
+
+	msg, err := msgClient.Request(subj, data, timeout)
+	if err != nil {
+		return err
+	}
+	return printResponse(msg.Data)
+}
+
+// itemValue returns the value of the item given by the options: the JSON document with -json,
+// the raw bytes of the file with -file (encoded as the base64 string) or the plain string.
+func itemValue(val string, doc bool, file string) (models.Value, error) {
+	switch {
+	case file != "":
+		content, err := os.ReadFile(file)
+		if err != nil {
+			return "", err
+		}
+		return models.BytesValue(content), nil
+	case doc:
+		return models.ParseValue(val)
+	default:
+		return models.StringValue(val), nil
+	}
+}
+
+// saveValue requests the item and writes the raw bytes of it's value (the base64 string) to the file.
+func saveValue(msgClient client.IMessageClient, subj client.Subject, timeout time.Duration, data []byte, file string) error {
+	msg, err := msgClient.Request(subj, data, timeout)
+	if err != nil {
+		return err
+	}
+
+	var resp models.Response
+	if err := json.Unmarshal(msg.Data, &resp); err != nil {
+		return err
+	}
+	if resp.Error != "" {
+		return errors.New(resp.Error)
+	}
+	if len(resp.Items) == 0 {
+		return ErrItemNotFound
+	}
+
+	content, err := resp.Items[0].Value.Bytes()
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(file, content, 0644)
+}
+
+// requestBucket sends the admin request of the bucket and prints the buckets returned by the server,
+// every bucket is printed with it's store type and limits (zero limits mean there is no limit).
+func requestBucket(msgClient client.IMessageClient, subj client.Subject, timeout time.Duration, spec models.Bucket) error {
+	data, err := json.Marshal(models.Msg{Spec: &spec})
+	if err != nil {
+		return err
+	}
+
+	msg, err := msgClient.Request(subj, data, timeout)
+	if err != nil {
+		return err
+	}
+
+	var resp models.Response
+	if err := json.Unmarshal(msg.Data, &resp); err != nil {
+		return err
+	}
+	if resp.Error != "" {
+		return errors.New(resp.Error)
+	}
+
+	for _, b := range resp.Buckets {
+		fmt.Printf("%s: %s (max %d items, %d bytes, %s)\n", b.Name, b.StoreType, b.MaxItems, b.MaxBytes, b.Eviction)
+	}
+	return nil
+}
+
+// replicationStatus collects heartbeats of the replicas for the wait time and prints the latest status of every replica.
+func replicationStatus(msgClient client.IMessageClient, wait time.Duration) error {
+	var lock sync.Mutex
+	replicas := make(map[string]models.ReplicationStatus)
+	err := msgClient.Subscribe(client.ReplicationHeartbeatSubject, func(msg *nats.Msg) {
+		var status models.ReplicationStatus
+		if err := json.Unmarshal(msg.Data, &status); err != nil {
+			return
+		}
+		lock.Lock()
+		replicas[status.Node] = status
+		lock.Unlock()
+	})
+	if err != nil {
+		return err
+	}
+	time.Sleep(wait)
+	msgClient.Unsubscribe(client.ReplicationHeartbeatSubject)
+
+	lock.Lock()
+	defer lock.Unlock()
+	if len(replicas) == 0 {
+		return errors.New("No replicas found.")
+	}
+	nodes := make([]string, 0, len(replicas))
+	for node := range replicas {
+		nodes = append(nodes, node)
+	}
+	sort.Strings(nodes)
+	for _, node := range nodes {
+		printReplica(replicas[node])
+	}
+	return nil
+}
+
+// printReplica prints the status of the replica as: {node}: {role}, term {n}, seq {n}
+func printReplica(status models.ReplicationStatus) {
+	stale := ""
+	if status.Stale {
+		stale = " (stale)"
+	}
+	fmt.Printf("%s: %s, term %d, seq %d%s\n", status.Node, status.Role, status.Term, status.Seq, stale)
+}
+
+// requestRaft sends the admin request to the leader of the Raft cluster and prints the status it replies with.
+func requestRaft(msgClient client.IMessageClient, subject client.Subject, timeout time.Duration, member models.RaftMember) error {
+	data, err := json.Marshal(member)
+	if err != nil {
+		return err
+	}
+	msg, err := msgClient.Request(subject, data, timeout)
+	if err != nil {
+		return err
+	}
+	var status models.RaftStatus
+	if err := json.Unmarshal(msg.Data, &status); err != nil {
+		return err
+	}
+	if status.Error != "" {
+		return errors.New(status.Error)
+	}
+
+	fmt.Printf("%s: %s, term %d, commit %d, applied %d\n", status.Node, status.Role, status.Term, status.Commit, status.Applied)
+	for _, m := range status.Members {
+		fmt.Printf("  %s: match %d\n", m.Node, m.Match)
+	}
+	return nil
+}
+
+// printEvent prints the change event as: #{seq} {op} {key}: {old value} -> {new value} (revision {n})
+func printEvent(event models.Event) {
+	switch event.Op {
+	case "add":
+		fmt.Printf("#%d %s %s: %s (revision %d)\n", event.Seq, event.Op, event.Key, event.NewValue, event.Revision)
+	case "update":
+		fmt.Printf("#%d %s %s: %s -> %s (revision %d)\n", event.Seq, event.Op, event.Key, event.OldValue, event.NewValue, event.Revision)
+	case "delete":
+		fmt.Printf("#%d %s %s: %s\n", event.Seq, event.Op, event.Key, event.OldValue)
+	default:
+		fmt.Printf("#%d %s %s\n", event.Seq, event.Op, event.Key)
+	}
+}
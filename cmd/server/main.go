@@ -1,18 +1,26 @@
 package main
 
 import (
+	"expvar"
 	"log"
 	"os"
 	"os/signal"
+	"path/filepath"
+	"strconv"
 	"sync"
 
 	"net/http"
 	_ "net/http/pprof"
 
 	"github.com/LukaGiorgadze/bloXroute/configs"
+	"github.com/LukaGiorgadze/bloXroute/internal/broker"
 	"github.com/LukaGiorgadze/bloXroute/internal/client"
 	"github.com/LukaGiorgadze/bloXroute/internal/consumers"
+	"github.com/LukaGiorgadze/bloXroute/internal/models"
+	"github.com/LukaGiorgadze/bloXroute/internal/raft"
 	"github.com/LukaGiorgadze/bloXroute/internal/store"
+	"github.com/LukaGiorgadze/bloXroute/internal/wal"
+	"github.com/LukaGiorgadze/bloXroute/internal/workers"
 	"github.com/nats-io/nats.go"
 )
 
@@ -25,10 +33,26 @@ func main() {
 		log.Fatal(err)
 	}
 
+	// In embedded mode the NATS server runs inside of this process, so the whole system
+	// can be started as a single binary without an external broker.
+	// The message client below connects to it instead of cfg.NatsURL.
+	natsURL := cfg.NatsURL
+	if cfg.NatsEmbedded {
+		embeddedNats, err := broker.NewEmbeddedNats(cfg.NatsEmbeddedHost, cfg.NatsEmbeddedPort, cfg.NatsUser, cfg.NatsPass, cfg.NatsStoreDir)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if err = embeddedNats.Start(); err != nil {
+			log.Fatal(err)
+		}
+		defer embeddedNats.Shutdown()
+		natsURL = embeddedNats.ClientURL()
+	}
+
 	// Initializes the message client by establishing a connection with the messaging system.
 	// The msgClient is of the IMessageClient interface type and can be replaced with other implementations
 	// of messaging systems like RabbitMQ, Kafka, etc. It can also be mocked during testing.
-	var msgClient client.IMessageClient = client.NewNatsClient(cfg.NatsURL, []nats.Option{nats.UserInfo(cfg.NatsUser, cfg.NatsPass)})
+	var msgClient client.IMessageClient = client.NewNatsClient(natsURL, []nats.Option{nats.UserInfo(cfg.NatsUser, cfg.NatsPass)})
 	err = msgClient.Connect()
 	if err != nil {
 		log.Fatal(err)
@@ -47,12 +71,146 @@ func main() {
 	// A mutex is used by the file writer goroutine.
 	var fileLock = sync.Mutex{}
 
-	// The unsafeStore is the global store (database) kept in memory and is accessible and modifiable by goroutines.
+	// The store of the default bucket is the global store (database) kept in memory and is accessible and modifiable by goroutines.
 	// However, direct access or modification is not recommended without using locks,
 	// as it can lead to race conditions and other synchronization issues.
-	// Therefore, it is advised to use the `unsafeStore.Lock()` method to control access to the store to ensure safe concurrent operations.
-	var unsafeStore store.IStore = store.NewOrderedMap(&lock, &fileLock, cfg.OutputFilePath)
-	// var unsafeStore store.IStore = store.NewLinkedList(&lock, &fileLock, cfg.OutputFilePath)
+	// Therefore, it is advised to use the `Store.Lock()` method to control access to the store to ensure safe concurrent operations.
+	//
+	// The type of the store is selected in the configuration: the insertion-ordered map (default),
+	// the linked list, the map sorted by keys, the sharded map or the multi-version map.
+	// Operations on single items of the sharded map lock only their shard, so they don't wait for each other
+	// (and for the list reads) across shards. The multi-version map is read without locking at all.
+	//
+	// The store is bounded when the limits are set, it's kept within them by evicting items chosen by the eviction policy,
+	// so the server can be used as a cache. The history keeps recent changes of every item (including removed ones),
+	// so the earlier values can be looked up. Both of them are kept by the bucket with the store.
+	defaultBucket, err := newBucket(&cfg, models.Bucket{MaxItems: cfg.StoreMaxItems, MaxBytes: cfg.StoreMaxBytes}, &lock, &fileLock)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// The workersConfig is shared among all workers of the consumers below.
+	// It holds the default bucket with the store and message client which is used to reply to the clients.
+	// Named buckets are created (and deleted) by the admin messages, each of them has it's own store and limits,
+	// the store type and the eviction policy which are not given are taken from the configuration.
+	workersConfig := &workers.WorkersConfig{
+		Bucket:    *defaultBucket,
+		MsgClient: msgClient,
+		NewBucket: func(spec models.Bucket) (*workers.Bucket, error) {
+			return newBucket(&cfg, spec, &sync.RWMutex{}, &fileLock)
+		},
+	}
+
+	// The usage of the bounded store is published with expvar (/debug/vars of the pprof server)
+	// and replied on the stats subject.
+	if workersConfig.Evictor != nil {
+		evictor := workersConfig.Evictor
+		expvar.Publish("store", expvar.Func(func() any { return evictor.Stats() }))
+	}
+
+	// The write-ahead log keeps every mutation on the local disk before it's applied to the store,
+	// independently of the broker. On startup, mutations which are not in the loaded snapshot are replayed from it,
+	// so a crash never loses acknowledged mutations.
+	if cfg.WalDir != "" {
+		workersConfig.WAL, err = wal.Open(cfg.WalDir, cfg.WalSegmentSize, wal.SyncPolicy(cfg.WalSync), cfg.WalSyncInterval)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer func() {
+			if err := workersConfig.WAL.Close(); err != nil {
+				log.Println(err)
+			}
+		}()
+	}
+
+	// With the replication, only the leader receives mutations of the clients. It numbers them in the order they are applied
+	// and streams them to followers, which apply them in the same order. Followers catch up with the leader
+	// from the snapshot of it's state and the records after it, and serve reads while they are not behind
+	// the leader longer than the staleness bound. The follower is promoted to the leader on failover.
+	// Subscriptions to the mutate subjects are made when the replica becomes the leader, see below.
+	// The replica subscribes to the read subjects while it's not stale, so stale followers don't receive reads of the queue group.
+	var subscribeMutations, subscribeReads func() error
+	var unsubscribeMutations, unsubscribeReads func()
+	var replicator *workers.Replicator
+	if cfg.ReplicationRole != "" {
+		// The follower saves the snapshot received from the leader right away,
+		// otherwise it's own write-ahead log would be replayed on the replaced state after the restart.
+		if cfg.WalDir != "" && cfg.SnapshotPath == "" {
+			log.Fatal("the write-ahead log of the replica needs the snapshot path")
+		}
+		node := cfg.ReplicationNode
+		if node == "" {
+			hostname, _ := os.Hostname()
+			node = hostname + "-" + strconv.Itoa(os.Getpid())
+		}
+		replicator, err = workers.NewReplicator(workers.ReplicationOptions{
+			Role:         cfg.ReplicationRole,
+			Node:         node,
+			LogSize:      cfg.ReplicationLogSize,
+			Heartbeat:    cfg.ReplicationHeartbeat,
+			MaxStaleness: cfg.ReplicationMaxStaleness,
+			OnLead:       func() error { return subscribeMutations() },
+			OnFollow:     func() { unsubscribeMutations() },
+			OnStale:      func() { unsubscribeReads() },
+			OnCaughtUp:   func() error { return subscribeReads() },
+		}, workersConfig)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	// The snapshotter periodically saves the store to the file, so it can be loaded on the next startup
+	// instead of starting with an empty store. The snapshot also contains the mutation log sequence of the last
+	// applied message, so only newer messages are replayed from the mutation log.
+	// The leader sends the snapshot to followers even if it's not saved.
+	snapshotter := workers.NewSnapshotter(cfg.SnapshotPath, cfg.SnapshotInterval, workersConfig)
+	if cfg.SnapshotPath != "" {
+		if err = snapshotter.Load(); err != nil {
+			log.Fatal(err)
+		}
+		go snapshotter.SnapshotWorker()
+
+		// Save the latest state when the server stops.
+		defer func() {
+			if err := snapshotter.Save(); err != nil {
+				log.Println(err)
+			}
+		}()
+	}
+
+	// With the Raft cluster, the leader proposes every mutation to the Raft log, which is replicated to the other nodes
+	// over NATS, and every node applies it to it's store only once it's committed by the majority of the cluster.
+	// The leader is elected by the nodes, so another one takes over as soon as it fails, and members are added
+	// and removed by the admin requests. Reads are served by every node after it catches up with the leader's commit index,
+	// so they are linearizable. The node keeps it's log and the snapshots of the store in it's own directory,
+	// they replace the write-ahead log and the snapshot file.
+	// Subscriptions to the mutate subjects are made when the node becomes the leader, see below.
+	var consensus *workers.Consensus
+	if cfg.RaftNode != "" {
+		if cfg.ReplicationRole != "" || cfg.WalDir != "" || cfg.SnapshotPath != "" {
+			log.Fatal("the Raft cluster can't be used with the replication, the write-ahead log or the snapshot path")
+		}
+		consensus, err = workers.NewConsensus(workers.ConsensusOptions{
+			Raft: raft.Options{
+				Node:              cfg.RaftNode,
+				Peers:             cfg.RaftPeers,
+				Dir:               filepath.Join(cfg.RaftDir, cfg.RaftNode),
+				ElectionTimeout:   cfg.RaftElectionTimeout,
+				Heartbeat:         cfg.RaftHeartbeat,
+				SnapshotThreshold: cfg.RaftSnapshotThreshold,
+				MsgClient:         msgClient,
+			},
+			OnLead:   func() error { return subscribeMutations() },
+			OnFollow: func() { unsubscribeMutations() },
+		}, workersConfig, snapshotter)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	// The reaper removes expired items (added with TTL) from the store.
+	reaper := workers.NewReaper(cfg.ReaperInterval, workersConfig)
+	go reaper.ReaperWorker()
 
 	// The consumers in this application contain handlers, which are the first callbacks in the subscribe method.
 	// These handlers can be used to write additional logic, initialize routines,
@@ -64,21 +222,129 @@ func main() {
 	// to item.mutate.add or item.mutate.delete.
 	//
 	// For more information visit https://docs.nats.io/nats-concepts/subjects.
-	itemMutateConsumer := consumers.NewItemMutateHandler(&cfg, unsafeStore)
-	err = msgClient.Subscribe(client.ItemMutateSubject, itemMutateConsumer.Handler())
+	//
+	// With the mutation log enabled, the mutate subjects are persisted in a JetStream stream and consumed
+	// through a durable consumer, so the messages published while the server is down are not lost.
+	// On startup, the messages processed by the previous runs are replayed from the stream to rebuild the store.
+	//
+	// Mutations of the named buckets are sent to item.{bucket}.mutate.*, the buckets are created and deleted
+	// by the admin messages, which are applied by the mutator in the order of mutations as well.
+	mutateSubjects := []client.Subject{client.ItemMutateSubject, client.ItemBucketMutateSubject, client.BucketCreateSubject, client.BucketDeleteSubject}
+	itemMutateConsumer := consumers.NewItemMutateHandler(&cfg, workersConfig)
+	replayedWAL, err := itemMutateConsumer.ReplayWAL()
 	if err != nil {
-		log.Panic(err)
+		log.Fatal(err)
+	}
+	if replayedWAL > 0 {
+		log.Printf("replayed %d mutations from the write-ahead log", replayedWAL)
 	}
-	defer msgClient.Unsubscribe(client.ItemMutateSubject)
 
-	// itemAccessConsumer reads data requested by the client and communicates with the fileWriter,
-	// which is responsible for writing read outputs to a file.
-	itemAccessConsumer := consumers.NewItemAccessHandler(&cfg, unsafeStore)
-	err = msgClient.Subscribe(client.ItemGetSubject, itemAccessConsumer.Handler())
-	if err != nil {
-		log.Panic(err)
+	handler := itemMutateConsumer.Handler()
+	if cfg.MutationLog {
+		streamClient, ok := msgClient.(client.IStreamClient)
+		if !ok {
+			log.Fatal("message client doesn't support the mutation log")
+		}
+		subscribeMutations = func() error {
+			err := streamClient.AddStream(cfg.MutationLogStream, mutateSubjects...)
+			if err != nil {
+				return err
+			}
+
+			// Messages up to the sequence of the loaded snapshot (or of the last record of the leader) are already in the store.
+			// Mutations acknowledged by the leader of the Raft cluster are in the Raft log, so they are not replayed.
+			if consensus == nil {
+				replayFrom := workersConfig.AppliedSeq + 1
+				replayed, err := streamClient.Replay(cfg.MutationLogStream, cfg.MutationLogDurable, replayFrom, itemMutateConsumer.Replayer())
+				if err != nil {
+					return err
+				}
+				if replayed > 0 {
+					log.Printf("replayed mutation log from sequence %d to %d", replayFrom, replayed)
+				}
+			}
+
+			// The durable consumer receives all subjects of the stream, every lane can apply the mutation
+			// while the earlier ones aren't acknowledged yet.
+			return streamClient.SubscribeDurable(cfg.MutationLogStream, cfg.MutationLogDurable, client.ItemLogSubject, cfg.MutatorLanes, handler)
+		}
+		unsubscribeMutations = func() {
+			msgClient.Unsubscribe(client.ItemLogSubject)
+		}
+	} else {
+		subscribeMutations = func() error {
+			for _, subj := range mutateSubjects {
+				if err := msgClient.Subscribe(subj, handler); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+		unsubscribeMutations = func() {
+			for _, subj := range mutateSubjects {
+				msgClient.Unsubscribe(subj)
+			}
+		}
+	}
+
+	// Followers receive the records of the leader instead, nodes of the Raft cluster subscribe once they are elected.
+	if (replicator == nil || cfg.ReplicationRole == workers.LeaderRole) && consensus == nil {
+		if err = subscribeMutations(); err != nil {
+			log.Panic(err)
+		}
+	}
+	defer unsubscribeMutations()
+
+	// itemAccessConsumer reads data requested by the client, replies with it to the client
+	// and communicates with the fileWriter, which is responsible for writing read outputs to a file.
+	itemAccessConsumer := consumers.NewItemAccessHandler(&cfg, workersConfig)
+	// Items of the named buckets are read on item.{bucket}.get.*.
+	//
+	// Servers can join the read subjects in the queue group, so every read is served (and written to the output file)
+	// by only one of them and reads are balanced among replicas. Mutations are still received by every server.
+	// Without the group, every server replies to every read.
+	// Replicas subscribe to them once they are not stale, see above.
+	readSubjects := []client.Subject{client.ItemGetSubject, client.ItemBucketGetSubject, client.BucketListSubject}
+	accessHandler := itemAccessConsumer.Handler()
+	subscribeReads = func() error {
+		for _, subj := range readSubjects {
+			var err error
+			if cfg.ReadQueueGroup != "" {
+				err = msgClient.QueueSubscribe(subj, cfg.ReadQueueGroup, accessHandler)
+			} else {
+				err = msgClient.Subscribe(subj, accessHandler)
+			}
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	unsubscribeReads = func() {
+		for _, subj := range readSubjects {
+			msgClient.Unsubscribe(subj)
+		}
+	}
+	if replicator == nil {
+		if err = subscribeReads(); err != nil {
+			log.Panic(err)
+		}
+	}
+	defer unsubscribeReads()
+
+	if replicator != nil {
+		if err = replicator.Start(snapshotter); err != nil {
+			log.Panic(err)
+		}
+		defer replicator.Stop()
+	}
+
+	if consensus != nil {
+		if err = consensus.Start(); err != nil {
+			log.Panic(err)
+		}
+		defer consensus.Stop()
 	}
-	defer msgClient.Unsubscribe(client.ItemGetSubject)
 
 	// Run pprof to visualize and analyze profiling data.
 	if cfg.Pprof {
@@ -91,3 +357,30 @@ func main() {
 		<-c
 	}
 }
+
+// newBucket creates the bucket with the store of the type and the limits of the spec,
+// the store type and the eviction policy which are not given are taken from the configuration.
+// The history of items is kept with the same limits in all buckets.
+func newBucket(cfg *configs.Config, spec models.Bucket, lock *sync.RWMutex, fileLock *sync.Mutex) (b *workers.Bucket, err error) {
+	if spec.StoreType == "" {
+		spec.StoreType = cfg.StoreType
+	}
+	if spec.Eviction == "" {
+		spec.Eviction = cfg.StoreEviction
+	}
+
+	b = &workers.Bucket{Spec: spec}
+	storeOptions := store.Options{Shards: cfg.StoreShards, Versions: cfg.StoreVersions}
+	if b.Store, err = store.New(spec.StoreType, storeOptions, lock, fileLock, cfg.OutputFilePath); err != nil {
+		return nil, err
+	}
+	if spec.MaxItems > 0 || spec.MaxBytes > 0 {
+		if b.Evictor, err = store.NewEvictor(spec.Eviction, spec.MaxItems, spec.MaxBytes); err != nil {
+			return nil, err
+		}
+	}
+	if cfg.HistorySize > 0 || cfg.HistoryMaxAge > 0 {
+		b.History = store.NewHistory(cfg.HistorySize, cfg.HistoryMaxAge)
+	}
+	return b, nil
+}
@@ -1,6 +1,7 @@
 package configs
 
 import (
+	"errors"
 	"sync"
 
 	"github.com/caarlos0/env/v7"
@@ -8,6 +9,8 @@ import (
 
 var once sync.Once
 
+var ErrListPageSize = errors.New("LIST_PAGE_SIZE must be positive.")
+
 // NewConfig initializes a new Config object by parsing environment variables.
 // The function uses sync.Once to ensure that the initialization happens only once.
 // The returned Config object can be used to access the parsed configuration values.
@@ -15,7 +18,13 @@ func NewConfig() (cfg Config, err error) {
 
 	once.Do(func() {
 		cfg = Config{}
-		err = env.Parse(&cfg)
+		if err = env.Parse(&cfg); err != nil {
+			return
+		}
+		// The list page is allocated with it's size.
+		if cfg.ListPageSize <= 0 {
+			err = ErrListPageSize
+		}
 	})
 
 	return
@@ -1,11 +1,51 @@
 package configs
 
+import "time"
+
 type Config struct {
-	NatsURL                    string `env:"NATS_URL" envDefault:"0.0.0.0:4222"`
-	NatsUser                   string `env:"NATS_USER" envDefault:"dummy"`
-	NatsPass                   string `env:"NATS_PASS" envDefault:"password"`
-	SemaphoreReadMaxGoroutines uint8  `env:"SEM_READ_MAX_GR" envDefault:"10"`
-	OutputFilePath             string `env:"OUTPUT_FILE_PATH" envDefault:"./output/items.log"`
-	Pprof                      bool   `env:"PPROF" envDefault:"false"`
-	PprofURL                   string `env:"PPROF_URL" envDefault:"127.0.0.1:8080"`
+	NatsURL                    string        `env:"NATS_URL" envDefault:"0.0.0.0:4222"`
+	NatsUser                   string        `env:"NATS_USER" envDefault:"dummy"`
+	NatsPass                   string        `env:"NATS_PASS" envDefault:"password"`
+	NatsEmbedded               bool          `env:"NATS_EMBEDDED" envDefault:"false"`
+	NatsEmbeddedHost           string        `env:"NATS_EMBEDDED_HOST" envDefault:"0.0.0.0"`
+	NatsEmbeddedPort           int           `env:"NATS_EMBEDDED_PORT" envDefault:"4222"`
+	NatsStoreDir               string        `env:"NATS_STORE_DIR" envDefault:"./output/jetstream"`
+	MutationLog                bool          `env:"MUTATION_LOG" envDefault:"false"`
+	MutationLogStream          string        `env:"MUTATION_LOG_STREAM" envDefault:"ITEMS"`
+	MutationLogDurable         string        `env:"MUTATION_LOG_DURABLE" envDefault:"mutator"`
+	SnapshotPath               string        `env:"SNAPSHOT_PATH" envDefault:""`
+	SnapshotInterval           time.Duration `env:"SNAPSHOT_INTERVAL" envDefault:"1m"`
+	WalDir                     string        `env:"WAL_DIR" envDefault:""`
+	WalSync                    string        `env:"WAL_SYNC" envDefault:"interval"`
+	WalSyncInterval            time.Duration `env:"WAL_SYNC_INTERVAL" envDefault:"1s"`
+	WalSegmentSize             int64         `env:"WAL_SEGMENT_SIZE" envDefault:"67108864"`
+	ReaperInterval             time.Duration `env:"REAPER_INTERVAL" envDefault:"1s"`
+	StoreType                  string        `env:"STORE_TYPE" envDefault:"orderedmap"`
+	StoreShards                int           `env:"STORE_SHARDS" envDefault:"16"`
+	StoreVersions              int           `env:"STORE_VERSIONS" envDefault:"10000"`
+	StoreMaxItems              int           `env:"STORE_MAX_ITEMS" envDefault:"0"`
+	StoreMaxBytes              int64         `env:"STORE_MAX_BYTES" envDefault:"0"`
+	StoreEviction              string        `env:"STORE_EVICTION" envDefault:"lru"`
+	HistorySize                int           `env:"HISTORY_SIZE" envDefault:"10"`
+	HistoryMaxAge              time.Duration `env:"HISTORY_MAX_AGE" envDefault:"0"`
+	ReplicationRole            string        `env:"REPLICATION_ROLE" envDefault:""`
+	ReplicationNode            string        `env:"REPLICATION_NODE" envDefault:""`
+	ReplicationLogSize         int           `env:"REPLICATION_LOG_SIZE" envDefault:"100000"`
+	ReplicationHeartbeat       time.Duration `env:"REPLICATION_HEARTBEAT" envDefault:"1s"`
+	ReplicationMaxStaleness    time.Duration `env:"REPLICATION_MAX_STALENESS" envDefault:"5s"`
+	RaftNode                   string        `env:"RAFT_NODE" envDefault:""`
+	RaftPeers                  []string      `env:"RAFT_PEERS" envSeparator:","`
+	RaftDir                    string        `env:"RAFT_DIR" envDefault:"./output/raft"`
+	RaftElectionTimeout        time.Duration `env:"RAFT_ELECTION_TIMEOUT" envDefault:"1s"`
+	RaftHeartbeat              time.Duration `env:"RAFT_HEARTBEAT" envDefault:"100ms"`
+	RaftSnapshotThreshold      int           `env:"RAFT_SNAPSHOT_THRESHOLD" envDefault:"10000"`
+	MutatorLanes               int           `env:"MUTATOR_LANES" envDefault:"1"`
+	MutatorOrderedSubjects     []string      `env:"MUTATOR_ORDERED_SUBJECTS" envSeparator:","`
+	ReadQueueGroup             string        `env:"READ_QUEUE_GROUP" envDefault:""`
+	SemaphoreReadMaxGoroutines uint8         `env:"SEM_READ_MAX_GR" envDefault:"10"`
+	ListPageSize               int           `env:"LIST_PAGE_SIZE" envDefault:"1000"`
+	OutputFilePath             string        `env:"OUTPUT_FILE_PATH" envDefault:"./output/items.log"`
+	Pprof                      bool          `env:"PPROF" envDefault:"false"`
+	PprofURL                   string        `env:"PPROF_URL" envDefault:"127.0.0.1:8080"`
+	RequestTimeout             time.Duration `env:"REQUEST_TIMEOUT" envDefault:"5s"`
 }
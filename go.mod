@@ -6,13 +6,16 @@ require (
 	github.com/caarlos0/env/v7 v7.0.0
 	github.com/gookit/color v1.5.2
 	github.com/gookit/gcli/v3 v3.2.1
+	github.com/nats-io/nats-server/v2 v2.9.14
 	github.com/nats-io/nats.go v1.24.0
 )
 
 require (
 	github.com/golang/protobuf v1.5.2 // indirect
 	github.com/gookit/goutil v0.6.6 // indirect
-	github.com/nats-io/nats-server/v2 v2.9.14 // indirect
+	github.com/klauspost/compress v1.15.15 // indirect
+	github.com/minio/highwayhash v1.0.2 // indirect
+	github.com/nats-io/jwt/v2 v2.3.0 // indirect
 	github.com/nats-io/nkeys v0.3.0 // indirect
 	github.com/nats-io/nuid v1.0.1 // indirect
 	github.com/xo/terminfo v0.0.0-20220910002029-abceb7e1c41e // indirect
@@ -21,5 +24,6 @@ require (
 	golang.org/x/sys v0.5.0 // indirect
 	golang.org/x/term v0.5.0 // indirect
 	golang.org/x/text v0.7.0 // indirect
+	golang.org/x/time v0.3.0 // indirect
 	google.golang.org/protobuf v1.28.1 // indirect
 )
@@ -0,0 +1,63 @@
+package broker
+
+import (
+	"errors"
+	"time"
+
+	"github.com/nats-io/nats-server/v2/server"
+)
+
+var ErrServerNotReady = errors.New("Embedded NATS server is not ready for connections.")
+
+// readyTimeout is the maximum time to wait until the embedded server accepts connections.
+const readyTimeout = 10 * time.Second
+
+// EmbeddedNats runs a NATS server inside of the application process.
+// It allows to run the whole system as a single binary (e.g. on dev machines or in integration tests)
+// without starting an external broker. JetStream is always enabled, so the same features
+// are available as with the broker from docker-compose.yaml.
+type EmbeddedNats struct {
+	srv *server.Server
+}
+
+// NewEmbeddedNats configures (but doesn't start) the embedded NATS server.
+// Port -1 picks a random free port, which is useful when many servers run on the same machine.
+// Clients have to authenticate with the given user and password.
+func NewEmbeddedNats(host string, port int, user, pass, storeDir string) (*EmbeddedNats, error) {
+	srv, err := server.NewServer(&server.Options{
+		Host:      host,
+		Port:      port,
+		Username:  user,
+		Password:  pass,
+		JetStream: true,
+		StoreDir:  storeDir,
+		NoSigs:    true,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &EmbeddedNats{srv: srv}, nil
+}
+
+// Start runs the server in the background and waits until it's ready to accept connections.
+func (e *EmbeddedNats) Start() error {
+	go e.srv.Start()
+
+	if !e.srv.ReadyForConnections(readyTimeout) {
+		e.srv.Shutdown()
+		return ErrServerNotReady
+	}
+	return nil
+}
+
+// Shutdown closes all client connections and stops the server.
+func (e *EmbeddedNats) Shutdown() {
+	e.srv.Shutdown()
+	e.srv.WaitForShutdown()
+}
+
+// ClientURL returns the URL clients should connect to.
+func (e *EmbeddedNats) ClientURL() string {
+	return e.srv.ClientURL()
+}
@@ -1,6 +1,10 @@
 package client
 
-import "github.com/nats-io/nats.go"
+import (
+	"time"
+
+	"github.com/nats-io/nats.go"
+)
 
 // The IMessageClient interface defines a set of methods that any messaging
 // system client implementation should implement.
@@ -12,6 +16,18 @@ type IMessageClient interface {
 	Disconnect() error
 	OnDisconnect(func())
 	Publish(Subject, []byte) error
+	Request(Subject, []byte, time.Duration) (*nats.Msg, error)
 	Subscribe(Subject, func(msg *nats.Msg)) error
+	// QueueSubscribe subscribes as the member of the queue group, every message is delivered
+	// to only one member of the group, so the load is balanced among them.
+	QueueSubscribe(subject Subject, queue string, handler func(msg *nats.Msg)) error
 	Unsubscribe(Subject)
 }
+
+// The IStreamClient interface is implemented by message clients which can persist messages
+// in a log (e.g. NATS JetStream), so they can be processed reliably and replayed later.
+type IStreamClient interface {
+	AddStream(name string, subjects ...Subject) error
+	SubscribeDurable(stream, durable string, subject Subject, maxAckPending int, handler func(msg *nats.Msg)) error
+	Replay(stream, durable string, from uint64, handler func(msg *nats.Msg)) (uint64, error)
+}
@@ -1,12 +1,117 @@
 package client
 
+import (
+	"encoding/base64"
+	"strings"
+)
+
 type Subject string
 
 const (
 	ItemMutateSubject       Subject = "item.mutate.*"
 	ItemMutateAddSubject    Subject = "item.mutate.add"
 	ItemMutateDeleteSubject Subject = "item.mutate.delete"
+	ItemMutateCasSubject    Subject = "item.mutate.cas"
+	ItemMutateUpdateSubject Subject = "item.mutate.update"
+	ItemMutateBatchSubject  Subject = "item.mutate.batch"
+	ItemMutateIncrSubject   Subject = "item.mutate.incr"
+	ItemMutateDecrSubject   Subject = "item.mutate.decr"
 	ItemGetSubject          Subject = "item.get.*"
 	ItemGetOneSubject       Subject = "item.get.one"
 	ItemGetListSubject      Subject = "item.get.list"
+	ItemGetPrefixSubject    Subject = "item.get.prefix"
+	ItemGetRangeSubject     Subject = "item.get.range"
+	ItemGetHistorySubject   Subject = "item.get.history"
+	ItemGetStatsSubject     Subject = "item.get.stats"
+	ItemEventsSubject       Subject = "item.events.>"
+	ItemBucketMutateSubject Subject = "item.*.mutate.*"
+	ItemBucketGetSubject    Subject = "item.*.get.*"
+	BucketCreateSubject     Subject = "item.admin.bucket.create"
+	BucketDeleteSubject     Subject = "item.admin.bucket.delete"
+	BucketListSubject       Subject = "item.admin.bucket.list"
+	// ItemLogSubject matches all subjects persisted in the mutation log.
+	ItemLogSubject Subject = "item.>"
+
+	// Subjects of the replication between the leader and the followers, see workers/replication.go.
+	ReplicationLogSubject       Subject = "replication.log"
+	ReplicationSyncSubject      Subject = "replication.sync"
+	ReplicationHeartbeatSubject Subject = "replication.heartbeat"
+	ReplicationPromoteSubject   Subject = "replication.promote"
+
+	// Subjects of the Raft cluster administration, see internal/raft.
+	RaftStatusSubject Subject = "raft.admin.status"
+	RaftAddSubject    Subject = "raft.admin.add"
+	RaftRemoveSubject Subject = "raft.admin.remove"
 )
+
+// itemPrefix is followed by the bucket name in the subjects of the named buckets.
+const itemPrefix = "item."
+
+// Tokens which follow the itemPrefix in the subjects of the default bucket, so they can't be used as bucket names.
+var reservedBucketNames = map[string]bool{"mutate": true, "get": true, "events": true, "admin": true}
+
+// itemEventsPrefix is followed by the key of the changed item in the events subject.
+const itemEventsPrefix = "item.events."
+
+// raftNodePrefix is followed by the node name in the subject where the Raft node receives messages of other nodes.
+const raftNodePrefix = "raft.node."
+
+// ReplyToHeader is the message header with the subject where the response should be sent.
+const ReplyToHeader = "Reply-To"
+
+// RaftNodeSubject returns the subject where the Raft node receives messages of other nodes.
+func RaftNodeSubject(node string) Subject {
+	return Subject(raftNodePrefix + node)
+}
+
+// ItemEventsKeySubject returns the subject where change events of the item are published.
+// Keys which can't be used in the subject as they are (e.g. contain spaces or wildcards)
+// are encoded with base64 after the "_" token.
+func ItemEventsKeySubject(key string) Subject {
+	if validSubjectTokens(key) {
+		return Subject(itemEventsPrefix + key)
+	}
+	return Subject(itemEventsPrefix + "_." + base64.RawURLEncoding.EncodeToString([]byte(key)))
+}
+
+// BucketSubject returns the subject of the item operation (or events) in the bucket, e.g. item.{bucket}.mutate.add.
+// Subjects of the default bucket ("") are returned as they are.
+func BucketSubject(bucket string, subject Subject) Subject {
+	if bucket == "" {
+		return subject
+	}
+	return Subject(itemPrefix + bucket + "." + strings.TrimPrefix(string(subject), itemPrefix))
+}
+
+// ParseBucketSubject splits the subject of the item operation into the bucket name and the subject of the operation
+// in the default bucket, e.g. item.{bucket}.mutate.add into {bucket} and item.mutate.add.
+// Other subjects (including the bucket admin ones) are returned as they are, with the empty bucket name.
+func ParseBucketSubject(subject string) (bucket string, base string) {
+	tokens := strings.SplitN(subject, ".", 3)
+	if len(tokens) < 3 || tokens[0] != "item" || reservedBucketNames[tokens[1]] {
+		return "", subject
+	}
+	return tokens[1], itemPrefix + tokens[2]
+}
+
+// ValidBucketName reports whether the name can be used as a single subject token of the named bucket.
+func ValidBucketName(name string) bool {
+	return validSubjectTokens(name) && !strings.Contains(name, ".") && !reservedBucketNames[name]
+}
+
+// ValidNodeName reports whether the name can be used as a single subject token of the node.
+func ValidNodeName(name string) bool {
+	return validSubjectTokens(name) && !strings.Contains(name, ".")
+}
+
+func validSubjectTokens(s string) bool {
+	if s == "" || strings.ContainsAny(s, " \t\r\n") {
+		return false
+	}
+	for _, token := range strings.Split(s, ".") {
+		if token == "" || token == "*" || token == ">" || token == "_" {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,180 @@
+package client
+
+import (
+	"errors"
+	"time"
+
+	"github.com/nats-io/nats.go"
+)
+
+// replayTimeout is the maximum time to wait for the next message during replay.
+const replayTimeout = 5 * time.Second
+
+func (c *NatsClient) jetStream() (nats.JetStreamContext, error) {
+	if c.js != nil {
+		return c.js, nil
+	}
+	js, err := c.conn.JetStream()
+	if err != nil {
+		return nil, err
+	}
+	c.js = js
+	return js, nil
+}
+
+// AddStream creates a file-backed stream which persists every message published to the subjects.
+// If the stream already exists it's reused, so messages stored by previous runs are kept,
+// only it's subjects are updated if they are different.
+func (c *NatsClient) AddStream(name string, subjects ...Subject) (err error) {
+	js, err := c.jetStream()
+	if err != nil {
+		return
+	}
+
+	subjs := make([]string, len(subjects))
+	for i := range subjects {
+		subjs[i] = string(subjects[i])
+	}
+
+	info, err := js.StreamInfo(name)
+	if err == nil {
+		if equalSubjects(info.Config.Subjects, subjs) {
+			return
+		}
+		config := info.Config
+		config.Subjects = subjs
+		_, err = js.UpdateStream(&config)
+		return
+	}
+	if !errors.Is(err, nats.ErrStreamNotFound) {
+		return
+	}
+
+	_, err = js.AddStream(&nats.StreamConfig{
+		Name:     name,
+		Subjects: subjs,
+		Storage:  nats.FileStorage,
+	})
+	return
+}
+
+func equalSubjects(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// SubscribeDurable subscribes to the stream through the durable consumer, creating it on the first run.
+// If the consumer was created with another subject or limit, it's configuration is updated, so it keeps it's position.
+// Messages are delivered in the stream order, up to maxAckPending of them before the earlier ones are acknowledged,
+// and each of them has to be acknowledged explicitly (msg.Ack()) once processed, otherwise it's redelivered.
+// The subscription is bound to the consumer, so unsubscribing keeps the consumer and it's position
+// on the server for the next run.
+func (c *NatsClient) SubscribeDurable(stream, durable string, subject Subject, maxAckPending int, handler func(msg *nats.Msg)) (err error) {
+	js, err := c.jetStream()
+	if err != nil {
+		return
+	}
+	if maxAckPending < 1 {
+		maxAckPending = 1
+	}
+
+	info, err := js.ConsumerInfo(stream, durable)
+	if err == nil && (info.Config.FilterSubject != string(subject) || info.Config.MaxAckPending != maxAckPending) {
+		config := info.Config
+		config.FilterSubject = string(subject)
+		config.MaxAckPending = maxAckPending
+		_, err = js.UpdateConsumer(stream, &config)
+	}
+	if errors.Is(err, nats.ErrConsumerNotFound) {
+		_, err = js.AddConsumer(stream, &nats.ConsumerConfig{
+			Durable:        durable,
+			DeliverSubject: "deliver." + stream + "." + durable,
+			DeliverPolicy:  nats.DeliverAllPolicy,
+			AckPolicy:      nats.AckExplicitPolicy,
+			MaxAckPending:  maxAckPending,
+			FilterSubject:  string(subject),
+		})
+	}
+	if err != nil {
+		return
+	}
+
+	sub, err := js.Subscribe(string(subject), handler, nats.Bind(stream, durable), nats.ManualAck())
+	if err != nil {
+		return
+	}
+
+	c.keep(subject, sub)
+	return
+}
+
+// Replay delivers messages of the stream, starting from the `from` sequence, which were already delivered
+// to the durable consumer. Those are the messages processed by previous runs of the application,
+// so they can be used to rebuild the state. Messages after them are delivered by SubscribeDurable.
+// Messages are acknowledged out of order when more of them are pending, so the ones after the acknowledged floor
+// are replayed as well, the ones which weren't acknowledged are redelivered by SubscribeDurable then.
+// Replay returns the sequence of the last delivered message, or 0 if nothing was delivered.
+func (c *NatsClient) Replay(stream, durable string, from uint64, handler func(msg *nats.Msg)) (last uint64, err error) {
+	js, err := c.jetStream()
+	if err != nil {
+		return
+	}
+
+	info, err := js.ConsumerInfo(stream, durable)
+	if errors.Is(err, nats.ErrConsumerNotFound) {
+		// Nothing was processed yet.
+		return 0, nil
+	}
+	if err != nil {
+		return
+	}
+
+	streamInfo, err := js.StreamInfo(stream)
+	if err != nil {
+		return
+	}
+
+	delivered := info.Delivered.Stream
+	if from == 0 {
+		from = 1
+	}
+	if delivered < from || streamInfo.State.Msgs == 0 || streamInfo.State.LastSeq < from {
+		return
+	}
+
+	sub, err := js.SubscribeSync("", nats.BindStream(stream), nats.OrderedConsumer(), nats.StartSequence(from))
+	if err != nil {
+		return
+	}
+	defer sub.Unsubscribe()
+
+	for {
+		msg, err := sub.NextMsg(replayTimeout)
+		if err != nil {
+			return last, err
+		}
+		meta, err := msg.Metadata()
+		if err != nil {
+			return last, err
+		}
+		// Messages can be removed from the stream (e.g. by limits), so sequences may have gaps.
+		if meta.Sequence.Stream > delivered {
+			break
+		}
+		handler(msg)
+		last = meta.Sequence.Stream
+
+		if last == delivered || meta.NumPending == 0 {
+			break
+		}
+	}
+
+	return
+}
@@ -3,6 +3,7 @@ package client
 import (
 	"errors"
 	"sync"
+	"time"
 
 	"github.com/nats-io/nats.go"
 )
@@ -13,6 +14,8 @@ type NatsClient struct {
 	url  string
 	conn *nats.Conn
 	opts []nats.Option
+	// JetStream context, created on the first use of stream methods.
+	js nats.JetStreamContext
 	// We store subscriptions in a SyncMap to ensure thread-safety,
 	// as it may be accessed/changed concurrently by multiple goroutines.
 	subscriptions sync.Map
@@ -49,6 +52,41 @@ func (c *NatsClient) Publish(subject Subject, data []byte) (err error) {
 	return
 }
 
+// Request publishes data and waits for a single reply until the timeout expires.
+// Replies are delivered to a unique inbox subject, so many clients can request at the same time.
+// The inbox is sent in the ReplyToHeader instead of the reply subject of the message,
+// because JetStream answers to the reply subject of the messages it stores (e.g. mutations
+// with the mutation log enabled) with it's own acknowledgement.
+func (c *NatsClient) Request(subject Subject, data []byte, timeout time.Duration) (msg *nats.Msg, err error) {
+	inbox := nats.NewInbox()
+	sub, err := c.conn.SubscribeSync(inbox)
+	if err != nil {
+		return
+	}
+	defer sub.Unsubscribe()
+
+	err = c.conn.PublishMsg(&nats.Msg{
+		Subject: string(subject),
+		Data:    data,
+		Header:  nats.Header{ReplyToHeader: []string{inbox}},
+	})
+	if err != nil {
+		return
+	}
+
+	msg, err = sub.NextMsg(timeout)
+	return
+}
+
+// ReplySubject returns the subject where the reply to the request should be sent,
+// the one of the ReplyToHeader (see Request) or the reply subject of the message.
+func ReplySubject(msg *nats.Msg) string {
+	if reply := msg.Header.Get(ReplyToHeader); reply != "" {
+		return reply
+	}
+	return msg.Reply
+}
+
 func (c *NatsClient) Subscribe(subject Subject, handler func(msg *nats.Msg)) (err error) {
 	// Above Subscribe method of `NatsClient` runs the provided handler function, which returns a consumer function.
 	// Prior to processing messages, the handler may perform some business logic and initialization steps.
@@ -63,10 +101,32 @@ func (c *NatsClient) Subscribe(subject Subject, handler func(msg *nats.Msg)) (er
 	// and also keeps the interface looking more general.
 	// We store it in a SyncMap to ensure thread-safety,
 	// as it may be accessed/changed concurrently by multiple goroutines.
-	c.subscriptions.Store(subject, sub)
+	c.keep(subject, sub)
+	return
+}
+
+// QueueSubscribe subscribes to the subject in the queue group, NATS delivers every message to one of it's members.
+// The subscription is kept by the subject as well, so it's removed by Unsubscribe.
+func (c *NatsClient) QueueSubscribe(subject Subject, queue string, handler func(msg *nats.Msg)) (err error) {
+	sub, err := c.conn.QueueSubscribe(string(subject), queue, handler)
+	if err != nil {
+		return
+	}
+	c.keep(subject, sub)
 	return
 }
 
+// keep keeps the subscription by it's subject. The earlier subscription to the same subject is replaced
+// and unsubscribed, otherwise it would keep receiving messages and Unsubscribe couldn't reach it.
+func (c *NatsClient) keep(subject Subject, sub *nats.Subscription) {
+	previous, loaded := c.subscriptions.LoadOrStore(subject, sub)
+	if !loaded {
+		return
+	}
+	c.subscriptions.Store(subject, sub)
+	_ = previous.(*nats.Subscription).Unsubscribe()
+}
+
 func (c *NatsClient) Unsubscribe(subject Subject) {
 	item, loaded := c.subscriptions.LoadAndDelete(subject)
 	if !loaded {
@@ -0,0 +1,55 @@
+package client
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/LukaGiorgadze/bloXroute/internal/broker"
+	"github.com/nats-io/nats.go"
+)
+
+func TestSubscribeAgain(t *testing.T) {
+	srv, err := broker.NewEmbeddedNats("127.0.0.1", -1, "test", "test", t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = srv.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Shutdown()
+	c := NewNatsClient(srv.ClientURL(), []nats.Option{nats.UserInfo("test", "test")})
+	if err = c.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Disconnect()
+
+	var first, second atomic.Int32
+	if err = c.Subscribe("test", func(*nats.Msg) { first.Add(1) }); err != nil {
+		t.Fatal(err)
+	}
+	// The earlier subscription is replaced, so the message is handled once.
+	if err = c.QueueSubscribe("test", "group", func(*nats.Msg) { second.Add(1) }); err != nil {
+		t.Fatal(err)
+	}
+	publish := func() {
+		t.Helper()
+		if err := c.Publish("test", nil); err != nil {
+			t.Fatal(err)
+		}
+		if err := c.conn.Flush(); err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	publish()
+	if first.Load() != 0 || second.Load() != 1 {
+		t.Fatalf("handled by the first subscription %d times, by the second one %d times", first.Load(), second.Load())
+	}
+
+	c.Unsubscribe("test")
+	publish()
+	if first.Load() != 0 || second.Load() != 1 {
+		t.Fatalf("handled after unsubscribe: %d, %d", first.Load(), second.Load())
+	}
+}
@@ -20,16 +20,13 @@ type ItemAccessHandler struct {
 	fileWriter      *workers.FileWriter
 }
 
-func NewItemAccessHandler(cfg *configs.Config, store store.IStore) *ItemAccessHandler {
-
-	// Configure worker, set store where it should store data.
-	workersConfig := &workers.WorkersConfig{
-		Store: store,
-	}
+// NewItemAccessHandler creates handler with the workers configuration shared among all workers,
+// it contains the store where data should be read from and message client which is used to reply to the requester.
+func NewItemAccessHandler(cfg *configs.Config, workersConfig *workers.WorkersConfig) *ItemAccessHandler {
 
 	// Inizialize workers and assign it to the ItemMutateHandler struct,
 	// so it can be used later in handler or consumer.
-	semaphoreReader := workers.NewSemaphoreReader(cfg.SemaphoreReadMaxGoroutines, workersConfig)
+	semaphoreReader := workers.NewSemaphoreReader(cfg.SemaphoreReadMaxGoroutines, cfg.ListPageSize, workersConfig)
 
 	// It's recommended to have SemaphoreReaders number capacity in Data channel to not keep
 	// reader goroutines blocked until one FileWriter gouroutine reads the data.
@@ -37,7 +34,7 @@ func NewItemAccessHandler(cfg *configs.Config, store store.IStore) *ItemAccessHa
 
 	return &ItemAccessHandler{
 		cfg,
-		store,
+		workersConfig.Store,
 		semaphoreReader,
 		fileWriter,
 	}
@@ -62,21 +59,53 @@ func (ih *ItemAccessHandler) Handler() func(*nats.Msg) {
 func (ih *ItemAccessHandler) consumer() func(msg *nats.Msg) {
 
 	const (
-		GET_ITEM  = string(client.ItemGetSubject)
-		ITEM_LIST = string(client.ItemGetListSubject)
+		GET_ITEM     = string(client.ItemGetOneSubject)
+		ITEM_LIST    = string(client.ItemGetListSubject)
+		ITEM_SCAN    = string(client.ItemGetPrefixSubject)
+		ITEM_RANGE   = string(client.ItemGetRangeSubject)
+		ITEM_HISTORY = string(client.ItemGetHistorySubject)
+		ITEM_STATS   = string(client.ItemGetStatsSubject)
+		BUCKET_LIST  = string(client.BucketListSubject)
 	)
 
 	return func(msg *nats.Msg) {
 
-		switch msg.Subject {
+		// The follower which is behind the leader longer than the staleness bound doesn't serve reads.
+		m := msgToStruct(msg)
+		if ih.semaphoreReader.Stale(m) {
+			return
+		}
+
+		// Subjects of the named buckets are checked without the bucket name.
+		_, subject := client.ParseBucketSubject(msg.Subject)
+		switch subject {
 		case GET_ITEM:
-			m := msgToStruct(msg)
 			ih.semaphoreReader.Acquire()
 			go ih.semaphoreReader.ReadOne(m, ih.fileWriter.Data)
 
 		case ITEM_LIST:
 			ih.semaphoreReader.Acquire()
-			go ih.semaphoreReader.ReadAll(ih.fileWriter.Data)
+			go ih.semaphoreReader.ReadAll(m, ih.fileWriter.Data)
+
+		case ITEM_SCAN:
+			ih.semaphoreReader.Acquire()
+			go ih.semaphoreReader.ReadPrefix(m, ih.fileWriter.Data)
+
+		case ITEM_RANGE:
+			ih.semaphoreReader.Acquire()
+			go ih.semaphoreReader.ReadRange(m, ih.fileWriter.Data)
+
+		case ITEM_HISTORY:
+			ih.semaphoreReader.Acquire()
+			go ih.semaphoreReader.ReadHistory(m, ih.fileWriter.Data)
+
+		case ITEM_STATS:
+			ih.semaphoreReader.Acquire()
+			go ih.semaphoreReader.ReadStats(m)
+
+		case BUCKET_LIST:
+			ih.semaphoreReader.Acquire()
+			go ih.semaphoreReader.ReadBuckets(m)
 		}
 	}
 }
@@ -3,7 +3,9 @@ package consumers
 import (
 	"encoding/json"
 	"log"
+	"time"
 
+	"github.com/LukaGiorgadze/bloXroute/internal/client"
 	"github.com/LukaGiorgadze/bloXroute/internal/models"
 	"github.com/nats-io/nats.go"
 )
@@ -11,11 +13,66 @@ import (
 // Unmarshal the input message and convert it into our defined model/struct.
 // In addition, assign any necessary properties to the model/struct.
 func msgToStruct(msg *nats.Msg) (item *models.Msg) {
-	err := json.Unmarshal(msg.Data, &item)
-	if err != nil {
-		log.Println(err)
+	item = &models.Msg{}
+	if len(msg.Data) > 0 {
+		err := json.Unmarshal(msg.Data, item)
+		if err != nil {
+			log.Println(err)
+		}
+	}
+	// Subjects of the named buckets are handled as the ones of the default bucket, e.g. item.{bucket}.mutate.add
+	// as item.mutate.add, with the bucket name in the message.
+	item.Bucket, item.Subject = client.ParseBucketSubject(msg.Subject)
+	item.StreamSeq, item.Seq = 0, 0
+	item.ReplicationTerm, item.ReplicationSeq = 0, 0
+
+	// Reply subject of the messages delivered from the mutation log is used for acknowledgements,
+	// not for responses.
+	received := time.Now()
+	if meta, err := msg.Metadata(); err == nil {
+		item.StreamSeq = meta.Sequence.Stream
+		item.Ack = func() error {
+			return msg.Ack()
+		}
+		// The time when the message was stored, so the expiration is the same when it's replayed later.
+		received = meta.Timestamp
+	} else {
+		item.Reply = msg.Reply
+	}
+	if reply := msg.Header.Get(client.ReplyToHeader); reply != "" {
+		item.Reply = reply
+	}
+
+	// The expiration is calculated only from the TTL, it's never taken from the clients.
+	item.Time = received.UnixNano()
+	item.ExpiresAt = expiresAt(received, item.TTL)
+	// Operations of the batch are applied in the bucket of the batch, with it's time and sequences.
+	for i := range item.Ops {
+		op := &item.Ops[i]
+		op.Bucket, op.StreamSeq, op.Seq = "", 0, 0
+		op.ReplicationTerm, op.ReplicationSeq, op.RaftIndex = 0, 0, 0
+		op.Time = item.Time
+		op.ExpiresAt = expiresAt(received, op.TTL)
 	}
-	item.Subject = msg.Subject
 
 	return
 }
+
+// expiresAt returns the time when the item received at the given time expires, 0 if the TTL isn't positive.
+func expiresAt(received time.Time, ttl int64) int64 {
+	if ttl <= 0 {
+		return 0
+	}
+	return received.Add(time.Duration(ttl) * time.Second).UnixNano()
+}
+
+// ackStreamMsg acknowledges the message if it was delivered from the mutation log.
+// It's used for the messages which are skipped, otherwise they would be redelivered over and over again.
+func ackStreamMsg(msg *nats.Msg) {
+	if _, err := msg.Metadata(); err != nil {
+		return
+	}
+	if err := msg.Ack(); err != nil {
+		log.Println(err)
+	}
+}
@@ -0,0 +1,78 @@
+package consumers
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/LukaGiorgadze/bloXroute/internal/client"
+	"github.com/LukaGiorgadze/bloXroute/internal/models"
+	"github.com/nats-io/nats.go"
+)
+
+func TestMsgToStruct(t *testing.T) {
+	// Fields set by the server are never taken from the clients, neither for the batch nor for it's operations.
+	forged := models.Msg{
+		Item:            models.Item{Key: "a", Seq: 3, ExpiresAt: 1},
+		Bucket:          "other",
+		StreamSeq:       7,
+		ReplicationTerm: 1,
+		ReplicationSeq:  2,
+	}
+	batch := forged
+	batch.Ops = []models.Msg{forged}
+	data, err := json.Marshal(batch)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	item := msgToStruct(&nats.Msg{Subject: string(client.ItemMutateBatchSubject), Data: data})
+	if len(item.Ops) != 1 {
+		t.Fatalf("ops = %v", item.Ops)
+	}
+	for _, got := range []models.Msg{*item, item.Ops[0]} {
+		if got.Bucket != "" || got.StreamSeq != 0 || got.Seq != 0 || got.ExpiresAt != 0 || got.ReplicationTerm != 0 || got.ReplicationSeq != 0 {
+			t.Fatalf("message %+v has fields of the client", got)
+		}
+		if got.Time != item.Time {
+			t.Fatalf("time = %d, want %d", got.Time, item.Time)
+		}
+	}
+}
+
+func TestMsgToStructExpiresAt(t *testing.T) {
+	tests := []struct {
+		name      string
+		ttl       int64
+		expiresAt int64
+		want      time.Duration
+	}{
+		{name: "without the TTL", expiresAt: 1},
+		{name: "negative TTL", ttl: -1, expiresAt: 1},
+		{name: "TTL", ttl: 10, expiresAt: 1, want: 10 * time.Second},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// The expiration of the client is ignored for the batch operations too.
+			op := models.Msg{Item: models.Item{Key: "a", TTL: tt.ttl, ExpiresAt: tt.expiresAt}}
+			batch := op
+			batch.Ops = []models.Msg{op}
+			data, err := json.Marshal(batch)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			item := msgToStruct(&nats.Msg{Subject: string(client.ItemMutateBatchSubject), Data: data})
+			var want int64
+			if tt.want != 0 {
+				want = item.Time + int64(tt.want)
+			}
+			for _, got := range []models.Msg{*item, item.Ops[0]} {
+				if got.ExpiresAt != want {
+					t.Fatalf("expires at %d, want %d", got.ExpiresAt, want)
+				}
+			}
+		})
+	}
+}
@@ -17,62 +17,96 @@ type ItemMutateHandler struct {
 	// Store where all the data are stored during mutation.
 	store store.IStore
 
-	// Once is a pattern to run mutation worker only once, since we want to keep maintain,
-	// ordering of items added/delete.
-	onceMutator *workers.OnceMutator
+	// The pool of mutators processes mutations of every item one at a time, since we want to keep maintain
+	// ordering of items added/delete, while mutations of different items are processed in parallel lanes.
+	mutatorPool *workers.MutatorPool
 }
 
-func NewItemMutateHandler(cfg *configs.Config, store store.IStore) *ItemMutateHandler {
+// NewItemMutateHandler creates handler with the workers configuration shared among all workers,
+// it contains the store where the data should be stored.
+func NewItemMutateHandler(cfg *configs.Config, workersConfig *workers.WorkersConfig) *ItemMutateHandler {
 
-	// Configure worker, set store where it should store data.
-	workersConfig := &workers.WorkersConfig{
-		Store: store,
-	}
-
-	// Inizialize worker and assign it to the ItemMutateHandler struct,
+	// Inizialize the pool with the configured number of lanes and assign it to the ItemMutateHandler struct,
 	// so it can be used later in handler or consumer.
-	onceMutator := workers.NewOnceMutator(workersConfig)
+	mutatorPool := workers.NewMutatorPool(workersConfig, cfg.MutatorLanes, cfg.MutatorOrderedSubjects)
 
 	return &ItemMutateHandler{
 		cfg,
-		store,
-		onceMutator,
+		workersConfig.Store,
+		mutatorPool,
 	}
 }
 
-// Handler runs MutatorWorker routine and returns consumer for reading messages form subscription.
+// Handler runs the workers of the mutator lanes and returns consumer for reading messages form subscription.
 func (ih *ItemMutateHandler) Handler() func(*nats.Msg) {
 
-	// Run MutatorWorker and wait for the messages in another "thread".
-	go ih.onceMutator.MutatorWorker()
+	// Run the lane workers and wait for the messages in other "threads".
+	ih.mutatorPool.Start()
 
 	return ih.consumer()
 }
 
-// consumer reads messages from subscription and sends it to `onceMutator.Queue` if channel is not blocked.
-// `MutatorWorker()` receives this message and processes mutation, so after that `onceMutator.Queue`
-// becomes unblocked and available for the next cycle.
-// The idea is to have 1 processing at the time to keep ordering of insertion/deletion in the store.
+// ReplayWAL applies mutations from the write-ahead log which are not in the store yet.
+// It should be called on startup, before the Handler.
+func (ih *ItemMutateHandler) ReplayWAL() (int, error) {
+	return ih.mutatorPool.ReplayWAL()
+}
+
+// Replayer returns consumer for the messages replayed from the mutation log on startup.
+// Those messages were already processed and acknowledged by the previous runs,
+// so they are only applied to the store to rebuild it's state.
+func (ih *ItemMutateHandler) Replayer() func(*nats.Msg) {
+	return ih.consume(true)
+}
+
+// consumer reads messages from subscription and dispatches them to the lane of their item (see workers.MutatorPool),
+// it's Queue becomes unblocked and available for the next cycle once the lane has processed the previous mutation.
+// The idea is to have 1 processing at the time for every item to keep ordering of insertion/deletion in the store.
 func (ih *ItemMutateHandler) consumer() func(msg *nats.Msg) {
+	return ih.consume(false)
+}
+
+func (ih *ItemMutateHandler) consume(replay bool) func(msg *nats.Msg) {
 
 	const (
 		ADD_ITEM    = string(client.ItemMutateAddSubject)
 		DELETE_ITEM = string(client.ItemMutateDeleteSubject)
+		CAS_ITEM    = string(client.ItemMutateCasSubject)
+		UPDATE_ITEM = string(client.ItemMutateUpdateSubject)
+		BATCH_ITEM  = string(client.ItemMutateBatchSubject)
+		INCR_ITEM   = string(client.ItemMutateIncrSubject)
+		DECR_ITEM   = string(client.ItemMutateDecrSubject)
+
+		CREATE_BUCKET = string(client.BucketCreateSubject)
+		DELETE_BUCKET = string(client.BucketDeleteSubject)
 	)
 
 	return func(msg *nats.Msg) {
 		// There might be chance that msg.Subject does not contain any of them,
-		// if so - we skip it.
-		if msg.Subject != ADD_ITEM && msg.Subject != DELETE_ITEM {
+		// if so - we skip it. Subjects of the named buckets are checked without the bucket name.
+		_, subject := client.ParseBucketSubject(msg.Subject)
+		switch subject {
+		case ADD_ITEM, DELETE_ITEM, CAS_ITEM, UPDATE_ITEM, BATCH_ITEM, INCR_ITEM, DECR_ITEM, CREATE_BUCKET, DELETE_BUCKET:
+		default:
+			if !replay {
+				ackStreamMsg(msg)
+			}
 			return
 		}
 
-		// We are sending converted message to the onceMutator.Queue channel.
-		// The mutator worker will then retrieve the message from the queue and process it.
-		// The onceMutator.Queue is a buffered channel with a capacity of 1,
-		// meaning that any new incoming messages from the subscription will be blocked until the mutator
-		// worker has finished processing the current message. So we can maintain ordering of insertion/deletion.
+		// Replayed messages were already answered when they were processed for the first time.
 		m := msgToStruct(msg)
-		ih.onceMutator.Queue <- m
+		if replay {
+			m.Ack = nil
+			m.Reply = ""
+			m.Replayed = true
+		}
+
+		// We are dispatching converted message to the Queue channel of it's lane.
+		// The lane worker will then retrieve the message from the queue and process it.
+		// The Queue is a buffered channel with a capacity of 1,
+		// meaning that any new incoming messages from the subscription will be blocked until the lane
+		// worker has finished processing the current message. So we can maintain ordering of insertion/deletion.
+		ih.mutatorPool.Dispatch(m)
 	}
 }
@@ -1,9 +1,32 @@
 package models
 
+import "encoding/json"
+
 // Item model represents a key-value pair in a JSON format.
+// The value can be any JSON document, see Value.
 type Item struct {
 	Key   string `json:"key"`
-	Value string `json:"value,omitempty"`
+	Value Value  `json:"value,omitempty"`
+	// TTL is the number of seconds after which the added item expires, 0 means it never expires.
+	TTL int64 `json:"ttl,omitempty"`
+	// ExpiresAt is the time (Unix nanoseconds) when the item expires, it's calculated from the TTL by the server.
+	// It's serialized to be kept in the write-ahead log, but it's never taken from the clients.
+	ExpiresAt int64 `json:"expiresAt,omitempty"`
+	// Revision is given by the store every time the item is added or it's value changes, from the counter
+	// of all it's items, so it keeps growing even when the item is removed and added again.
+	// In conditional mutations, it's the revision the item is expected to have.
+	Revision uint64 `json:"revision,omitempty"`
+	// Seq is the sequence of the last mutation which changed the item. Mutations of all items and buckets
+	// are numbered in the order they are applied, so it tells which of two items was changed later.
+	// It's set by the server, it's never taken from the clients.
+	Seq uint64 `json:"seq,omitempty"`
+}
+
+// String formats the item as it is printed in the list output, the JSON encoding of the item,
+// e.g. {"key":"name","value":{"first":"Luka"},"revision":1,"seq":5}.
+func (i Item) String() string {
+	data, _ := json.Marshal(i)
+	return string(data)
 }
 
 // Msg model is used for communication on a messaging system.
@@ -13,4 +36,130 @@ type Item struct {
 type Msg struct {
 	Item
 	Subject string
+	// Tail moves the updated item to the end of the list, as if it was added last.
+	// By default the item keeps it's original position.
+	Tail bool `json:"tail,omitempty"`
+	// By is the amount added to the counter by incr (or subtracted from it by decr), 1 is used when it's 0.
+	By int64 `json:"by,omitempty"`
+	// Op is the name of the operation in the batch: add, delete, update or cas.
+	Op string `json:"op,omitempty"`
+	// Ops is the ordered list of operations of the batch, which are applied all or nothing.
+	Ops []Msg `json:"ops,omitempty"`
+	// Limit is the maximum number of items in the list page, the server's page size is used when it's 0 or greater than the page size.
+	Limit int `json:"limit,omitempty"`
+	// After is the key of the item after which the list page starts.
+	After string `json:"after,omitempty"`
+	// Cursor is the position after which the list page starts, it's returned with the previous page.
+	// Unlike After, it can be used even if the item was removed in the meantime.
+	Cursor string `json:"cursor,omitempty"`
+	// Reverse lists items from the last one to the first one.
+	Reverse bool `json:"reverse,omitempty"`
+	// Prefix selects items with keys starting with it.
+	Prefix string `json:"prefix,omitempty"`
+	// Pattern selects items with keys matching the glob pattern, e.g. user:*:profile.
+	Pattern string `json:"pattern,omitempty"`
+	// Lexical lists selected items in the lexical order of keys instead of the order of the store.
+	Lexical bool `json:"lexical,omitempty"`
+	// From and To select items with keys in the range [From, To), empty To means there is no upper bound.
+	From string `json:"from,omitempty"`
+	To   string `json:"to,omitempty"`
+	// Path selects the part of the item's value (JSON document) to read, e.g. servers[0].host.
+	Path string `json:"path,omitempty"`
+	// AsOf is the sequence of the store version to read, 0 reads the latest one.
+	// Only the versioned (mvcc) store keeps recent versions.
+	AsOf uint64 `json:"asOf,omitempty"`
+	// ExpectSeq is the sequence of the last applied mutation the client expects, the mutation is rejected
+	// if any other mutation was applied after it.
+	ExpectSeq uint64 `json:"expectSeq,omitempty"`
+	// Producer and ProducerSeq make the mutation idempotent: the mutation of the producer is applied only if the sequence
+	// is greater than the one of it's last applied mutation, so the producer can safely send it again.
+	Producer    string `json:"producer,omitempty"`
+	ProducerSeq uint64 `json:"producerSeq,omitempty"`
+	// Bucket is the name of the bucket of the item, it's taken from the subject (empty for the default bucket).
+	// It's serialized to be kept in the write-ahead log, but it's never taken from the clients.
+	Bucket string `json:"bucket,omitempty"`
+	// Spec is the bucket created or deleted by the bucket admin message.
+	Spec *Bucket `json:"spec,omitempty"`
+	// Reply is the subject where the response should be sent, empty if the sender doesn't wait for one.
+	Reply string `json:"-"`
+	// StreamSeq is the sequence of the message in the mutation log, 0 if it wasn't delivered from the log.
+	// It's serialized to be kept in the write-ahead log, but it's never taken from the clients.
+	StreamSeq uint64 `json:"streamSeq,omitempty"`
+	// Time is when the mutation was received (Unix nanoseconds), it's set by the server and kept in the write-ahead log,
+	// so replayed mutations are recorded in the history of items with their original time.
+	Time int64 `json:"time,omitempty"`
+	// ReplicationTerm and ReplicationSeq number the mutation in the replication log, they are set by the leader
+	// when it's applied. They are serialized to be sent to the followers and kept in their write-ahead log,
+	// but they are never taken from the clients.
+	ReplicationTerm uint64 `json:"replicationTerm,omitempty"`
+	ReplicationSeq  uint64 `json:"replicationSeq,omitempty"`
+	// RaftIndex is the index of the Raft log entry of the mutation, it's set when the committed entry is applied.
+	RaftIndex uint64 `json:"-"`
+	// Replayed is set for the messages replayed from the mutation log on startup.
+	Replayed bool `json:"-"`
+	// Ack acknowledges the message delivered from the mutation log after it's processed.
+	// It's nil for messages which don't need to be acknowledged.
+	Ack func() error `json:"-"`
+}
+
+// Response model is sent back to the client that requested data.
+// Items holds the requested item(s) in the order they are kept in the store,
+// Error is set when the request couldn't be served.
+// Next is the cursor of the next list page, empty if there are no more items.
+// Seq is the sequence of the store version the items were read from, it's set by the versioned store only,
+// so the next pages can be read from the same version.
+// History holds the changes of the requested item from the oldest one.
+// Stats holds the usage of the bounded store.
+// Buckets holds the created, deleted or listed buckets.
+// MutationSeq is the sequence of the applied mutation in replies to mutations, or of the last applied one
+// in replies to list reads and rejected mutations.
+type Response struct {
+	Items       []Item   `json:"items"`
+	Error       string   `json:"error,omitempty"`
+	Next        string   `json:"next,omitempty"`
+	Seq         uint64   `json:"seq,omitempty"`
+	History     []Event  `json:"history,omitempty"`
+	Stats       *Stats   `json:"stats,omitempty"`
+	Buckets     []Bucket `json:"buckets,omitempty"`
+	MutationSeq uint64   `json:"mutationSeq,omitempty"`
+}
+
+// Bucket model describes the named keyspace of items, which is kept in it's own store with it's own limits.
+// Empty StoreType and Eviction are taken from the server's configuration, zero limits mean there is no limit.
+type Bucket struct {
+	Name      string `json:"name"`
+	StoreType string `json:"storeType,omitempty"`
+	MaxItems  int    `json:"maxItems,omitempty"`
+	MaxBytes  int64  `json:"maxBytes,omitempty"`
+	Eviction  string `json:"eviction,omitempty"`
+}
+
+// Stats model describes the usage of the store bounded by the number of items and their total size.
+// Bytes is the size of keys and values of the items, zero limits mean there is no limit.
+// Evictions is the number of items evicted by the Policy since the server started.
+type Stats struct {
+	Items     int    `json:"items"`
+	Bytes     int64  `json:"bytes"`
+	MaxItems  int    `json:"maxItems"`
+	MaxBytes  int64  `json:"maxBytes"`
+	Policy    string `json:"policy"`
+	Evictions uint64 `json:"evictions"`
+}
+
+// Event model is published after the item has changed, so clients can watch changes in real time.
+// Op is one of: add, update, delete, expire or evict.
+// Seq is incremented for every change, events of the item are published in the order of their sequences.
+// Events of different items may be published out of that order when mutations are applied by parallel lanes,
+// so watchers should compare sequences of events of the same item only.
+// Time is when the change was made (Unix nanoseconds).
+// Bucket is the name of the bucket of the item, empty for the default bucket.
+type Event struct {
+	Bucket   string `json:"bucket,omitempty"`
+	Op       string `json:"op"`
+	Key      string `json:"key"`
+	OldValue Value  `json:"oldValue,omitempty"`
+	NewValue Value  `json:"newValue,omitempty"`
+	Revision uint64 `json:"revision,omitempty"`
+	Seq      uint64 `json:"seq"`
+	Time     int64  `json:"time,omitempty"`
 }
@@ -0,0 +1,21 @@
+package models
+
+// RaftStatus model is the status of the Raft cluster replied by the leader.
+// Commit is the index of the last entry committed to the log, Applied is the index of the last entry applied to the store.
+// Match of every member is the index of the last entry the member is known to have.
+type RaftStatus struct {
+	Node    string       `json:"node"`
+	Role    string       `json:"role"`
+	Term    uint64       `json:"term"`
+	Leader  string       `json:"leader,omitempty"`
+	Commit  uint64       `json:"commit"`
+	Applied uint64       `json:"applied"`
+	Members []RaftMember `json:"members,omitempty"`
+	Error   string       `json:"error,omitempty"`
+}
+
+// RaftMember model is the member of the Raft cluster, it's also used to request adding and removing members.
+type RaftMember struct {
+	Node  string `json:"node"`
+	Match uint64 `json:"match,omitempty"`
+}
@@ -0,0 +1,50 @@
+package models
+
+// ReplicationStatus model is published by every replica in it's heartbeat.
+// Term is the term of the leader the replica follows (or it's own term, if it's the leader),
+// Seq is the sequence of the last mutation applied by the replica.
+// Followers are Stale when they are behind the leader longer than the staleness bound, they don't serve reads then.
+// It's also used to request the promotion of the follower with the Node name to the leader.
+type ReplicationStatus struct {
+	Node  string `json:"node"`
+	Role  string `json:"role,omitempty"`
+	Term  uint64 `json:"term,omitempty"`
+	Seq   uint64 `json:"seq,omitempty"`
+	Stale bool   `json:"stale,omitempty"`
+	Error string `json:"error,omitempty"`
+}
+
+// ReplicationSync model is the request of the follower to catch up with the leader,
+// it contains the term and the sequence of the last mutation applied by the follower.
+// Snapshot and Offset continue the transfer of the snapshot, which was started by the previous reply.
+// Full requests the snapshot instead of records, e.g. when the follower has mutations which were never numbered by the leader.
+type ReplicationSync struct {
+	Node     string `json:"node"`
+	Term     uint64 `json:"term"`
+	Seq      uint64 `json:"seq"`
+	Snapshot string `json:"snapshot,omitempty"`
+	Offset   int64  `json:"offset,omitempty"`
+	Full     bool   `json:"full,omitempty"`
+}
+
+// ReplicationSyncReply model is the reply of the leader to the ReplicationSync request.
+// Records are the mutations after the last applied one, in order, More is set if there are more of them.
+// The Snapshot is sent instead, if the leader doesn't keep them anymore. Term is the term of the leader.
+type ReplicationSyncReply struct {
+	Term     uint64               `json:"term"`
+	Records  []Msg                `json:"records,omitempty"`
+	More     bool                 `json:"more,omitempty"`
+	Snapshot *ReplicationSnapshot `json:"snapshot,omitempty"`
+	Error    string               `json:"error,omitempty"`
+}
+
+// ReplicationSnapshot model is the chunk of the leader's snapshot, which starts at the Offset.
+// The snapshot contains the state after the mutation with the Term and the Seq, it's Size bytes long.
+type ReplicationSnapshot struct {
+	ID     string `json:"id"`
+	Term   uint64 `json:"term"`
+	Seq    uint64 `json:"seq"`
+	Size   int64  `json:"size"`
+	Offset int64  `json:"offset"`
+	Data   []byte `json:"data"`
+}
@@ -0,0 +1,161 @@
+package models
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"strings"
+)
+
+var (
+	ErrInvalidValue = errors.New("Value is not a valid JSON document.")
+	ErrNotBytes     = errors.New("Value is not a base64 string.")
+	ErrInvalidPath  = errors.New("Invalid path.")
+	ErrPathNotFound = errors.New("Path not found.")
+)
+
+// Value is the value of the item, kept as it's compact JSON encoding.
+// It can be any JSON document: a string, a number, an object, an array, etc.
+// Plain strings are encoded as JSON strings, so items sent as {"key":"a","value":"1"} keep working as before,
+// and raw bytes are encoded as base64 JSON strings.
+// Documents are kept as they were sent (without insignificant whitespace), so they are returned intact, with the same order of fields.
+type Value string
+
+// StringValue returns the value of the plain string.
+func StringValue(s string) Value {
+	data, _ := json.Marshal(s)
+	return Value(data)
+}
+
+// BytesValue returns the value of raw bytes, encoded as the base64 string.
+func BytesValue(b []byte) Value {
+	data, _ := json.Marshal(b)
+	return Value(data)
+}
+
+// ParseValue returns the value of the JSON document.
+func ParseValue(doc string) (Value, error) {
+	var v Value
+	if err := v.UnmarshalJSON([]byte(doc)); err != nil {
+		return "", err
+	}
+	return v, nil
+}
+
+// MarshalJSON writes the value as the JSON document itself, not as the string.
+func (v Value) MarshalJSON() ([]byte, error) {
+	if v == "" {
+		return []byte(`""`), nil
+	}
+	return []byte(v), nil
+}
+
+// UnmarshalJSON keeps the compact encoding of the JSON document, null is the empty value.
+func (v *Value) UnmarshalJSON(data []byte) error {
+	var buf bytes.Buffer
+	if err := json.Compact(&buf, data); err != nil {
+		return ErrInvalidValue
+	}
+	if buf.String() == "null" {
+		*v = ""
+		return nil
+	}
+	*v = Value(buf.String())
+	return nil
+}
+
+// String returns the plain string of the string value, and the JSON encoding of other documents.
+func (v Value) String() string {
+	var s string
+	if err := json.Unmarshal([]byte(v), &s); err == nil {
+		return s
+	}
+	return string(v)
+}
+
+// Bytes decodes raw bytes of the base64 string value.
+func (v Value) Bytes() ([]byte, error) {
+	var b []byte
+	if err := json.Unmarshal([]byte(v), &b); err != nil {
+		return nil, ErrNotBytes
+	}
+	return b, nil
+}
+
+// Path returns the part of the document selected by the path of field names and array indexes,
+// e.g. servers[0].host or $.servers[0].host. Selected parts are returned as they are kept in the document.
+func (v Value) Path(path string) (Value, error) {
+	steps, err := parsePath(path)
+	if err != nil {
+		return "", err
+	}
+
+	doc := json.RawMessage(v)
+	for _, step := range steps {
+		if step.field {
+			var fields map[string]json.RawMessage
+			if err := json.Unmarshal(doc, &fields); err != nil {
+				return "", ErrPathNotFound
+			}
+			next, ok := fields[step.name]
+			if !ok {
+				return "", ErrPathNotFound
+			}
+			doc = next
+			continue
+		}
+
+		var elements []json.RawMessage
+		if err := json.Unmarshal(doc, &elements); err != nil || step.index >= len(elements) {
+			return "", ErrPathNotFound
+		}
+		doc = elements[step.index]
+	}
+	return Value(doc), nil
+}
+
+// pathStep is either the field name of the object or the index of the array element.
+type pathStep struct {
+	field bool
+	name  string
+	index int
+}
+
+// parsePath splits the path into steps, the leading $ (the root of the document) is optional.
+// Field names which contain dots or brackets can't be selected.
+func parsePath(path string) ([]pathStep, error) {
+	path = strings.TrimPrefix(strings.TrimPrefix(path, "$"), ".")
+	if path == "" {
+		return nil, nil
+	}
+
+	var steps []pathStep
+	for _, part := range strings.Split(path, ".") {
+		name, rest, indexed := strings.Cut(part, "[")
+		if name != "" {
+			steps = append(steps, pathStep{field: true, name: name})
+		} else if !indexed {
+			return nil, ErrInvalidPath
+		}
+		for indexed {
+			index, after, ok := strings.Cut(rest, "]")
+			if !ok {
+				return nil, ErrInvalidPath
+			}
+			i, err := strconv.Atoi(index)
+			if err != nil || i < 0 {
+				return nil, ErrInvalidPath
+			}
+			steps = append(steps, pathStep{index: i})
+			if after == "" {
+				break
+			}
+			if !strings.HasPrefix(after, "[") {
+				return nil, ErrInvalidPath
+			}
+			rest = after[1:]
+		}
+	}
+	return steps, nil
+}
@@ -0,0 +1,85 @@
+package models
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParsePath(t *testing.T) {
+	tests := []struct {
+		path string
+		want []pathStep
+		err  error
+	}{
+		{path: ""},
+		{path: "$"},
+		{path: "name", want: []pathStep{{field: true, name: "name"}}},
+		{path: "$.name", want: []pathStep{{field: true, name: "name"}}},
+		{path: "a.b", want: []pathStep{{field: true, name: "a"}, {field: true, name: "b"}}},
+		{path: "servers[0].host", want: []pathStep{{field: true, name: "servers"}, {index: 0}, {field: true, name: "host"}}},
+		{path: "$[1][2]", want: []pathStep{{index: 1}, {index: 2}}},
+		{path: "[1]", want: []pathStep{{index: 1}}},
+		{path: "a..b", err: ErrInvalidPath},
+		{path: "a.", err: ErrInvalidPath},
+		{path: "a[", err: ErrInvalidPath},
+		{path: "a[1", err: ErrInvalidPath},
+		{path: "a[]", err: ErrInvalidPath},
+		{path: "a[x]", err: ErrInvalidPath},
+		{path: "a[-1]", err: ErrInvalidPath},
+		{path: "a[0]b", err: ErrInvalidPath},
+		{path: "a[0]]", err: ErrInvalidPath},
+	}
+
+	for _, tt := range tests {
+		got, err := parsePath(tt.path)
+		if err != tt.err {
+			t.Errorf("parsePath(%q) error = %v, want %v", tt.path, err, tt.err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("parsePath(%q) = %+v, want %+v", tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestValuePath(t *testing.T) {
+	doc := Value(`{"name":{"first":"Luka"},"servers":[{"host":"a","ports":[80,443]},{"host":"b"}],"tags":[],"n":1}`)
+	tests := []struct {
+		name  string
+		value Value
+		path  string
+		want  Value
+		err   error
+	}{
+		{name: "root", value: doc, path: "$", want: doc},
+		{name: "field", value: doc, path: "n", want: `1`},
+		{name: "nested fields", value: doc, path: "name.first", want: `"Luka"`},
+		{name: "object", value: doc, path: "$.name", want: `{"first":"Luka"}`},
+		{name: "array index", value: doc, path: "servers[1].host", want: `"b"`},
+		{name: "nested array indexes", value: doc, path: "servers[0].ports[1]", want: `443`},
+		{name: "root array", value: `[[1,2],[3]]`, path: "[0][1]", want: `2`},
+		{name: "missing field", value: doc, path: "name.last", err: ErrPathNotFound},
+		{name: "index out of range", value: doc, path: "servers[2]", err: ErrPathNotFound},
+		{name: "index of the empty array", value: doc, path: "tags[0]", err: ErrPathNotFound},
+		{name: "field of the number", value: doc, path: "n.value", err: ErrPathNotFound},
+		{name: "field of the string", value: doc, path: "name.first.x", err: ErrPathNotFound},
+		{name: "field of the array", value: doc, path: "servers.host", err: ErrPathNotFound},
+		{name: "index of the object", value: doc, path: "name[0]", err: ErrPathNotFound},
+		{name: "field of the plain string", value: StringValue("text"), path: "a", err: ErrPathNotFound},
+		{name: "malformed path", value: doc, path: "servers[0", err: ErrInvalidPath},
+		{name: "malformed index", value: doc, path: "servers[a]", err: ErrInvalidPath},
+		{name: "empty field", value: doc, path: "name..first", err: ErrInvalidPath},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.value.Path(tt.path)
+			if err != tt.err {
+				t.Fatalf("error = %v, want %v", err, tt.err)
+			}
+			if got != tt.want {
+				t.Fatalf("value = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
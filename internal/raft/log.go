@@ -0,0 +1,506 @@
+package raft
+
+import (
+	"bytes"
+	"log"
+	"sort"
+	"time"
+)
+
+// lastIndex returns the index of the last entry of the log, the one of the snapshot if the log is empty.
+func (n *Node) lastIndex() uint64 {
+	return n.snapIndex + uint64(len(n.entries))
+}
+
+// termAt returns the term of the entry with the index, 0 if the log doesn't have it.
+func (n *Node) termAt(index uint64) uint64 {
+	switch {
+	case index == n.snapIndex:
+		return n.snapTerm
+	case index < n.snapIndex || index > n.lastIndex():
+		return 0
+	}
+	return n.entries[index-n.snapIndex-1].Term
+}
+
+// entry returns the entry of the log with the index, it should be after the snapshot.
+func (n *Node) entry(index uint64) Entry {
+	return n.entries[index-n.snapIndex-1]
+}
+
+// append appends entries to the log and saves them to the disk. Config entries change the members right away.
+func (n *Node) append(entries ...Entry) error {
+	if err := n.storage.appendLog(entries); err != nil {
+		return err
+	}
+	n.entries = append(n.entries, entries...)
+	n.resetMembers()
+	return nil
+}
+
+// truncate removes entries from the index to the end of the log, e.g. the ones which conflict with the leader's log.
+func (n *Node) truncate(index uint64) error {
+	entries := n.entries[:index-n.snapIndex-1]
+	if err := n.storage.rewriteLog(entries); err != nil {
+		return err
+	}
+	n.entries = entries
+	n.resetMembers()
+	return nil
+}
+
+// resetMembers sets the members of the latest config entry of the log, or the ones of the snapshot.
+func (n *Node) resetMembers() {
+	n.members, n.configIndex = n.membersAt(n.lastIndex())
+	n.resetPeers()
+}
+
+// membersAt returns the members as of the entry with the index and the index of their config entry.
+func (n *Node) membersAt(index uint64) ([]string, uint64) {
+	for i := index; i > n.snapIndex; i-- {
+		if e := n.entry(i); e.Type == configEntry {
+			return e.Members, i
+		}
+	}
+	if n.snapMembers == nil && n.snapIndex == 0 {
+		// The cluster is bootstrapped with the peers until the first membership change.
+		return normalize(n.opts.Peers), 0
+	}
+	return n.snapMembers, n.snapIndex
+}
+
+// broadcast sends the entries the followers don't have yet, or the heartbeat if they have all of them.
+func (n *Node) broadcast() {
+	for node, p := range n.peers {
+		n.sendAppend(node, p)
+	}
+}
+
+// sendAppend sends the entries after the next one the follower needs, up to maxMessageSize of them.
+// The follower behind the snapshot receives the snapshot instead. The next index is advanced right away,
+// so entries are not sent twice, it's moved back when the follower rejects them.
+func (n *Node) sendAppend(node string, p *peer) {
+	if p.next <= n.snapIndex {
+		n.sendSnapshot(node, p)
+		return
+	}
+
+	prev := p.next - 1
+	m := message{Type: msgAppend, PrevIndex: prev, PrevTerm: n.termAt(prev), Commit: n.commit, Read: n.readSeq}
+	size := 0
+	for i := p.next; i <= n.lastIndex(); i++ {
+		e := n.entry(i)
+		if size > 0 && size+len(e.Data) > maxMessageSize {
+			break
+		}
+		m.Entries = append(m.Entries, e)
+		size += len(e.Data) + len(e.Members)*16 + 64
+	}
+	p.next += uint64(len(m.Entries))
+	n.send(node, m)
+}
+
+// handleAppend appends the entries of the leader if the log has the entry they follow, the conflicting entries
+// (of the previous leaders which were never committed) are removed first. Otherwise it replies with the index
+// after which the leader should try again.
+func (n *Node) handleAppend(m message) {
+	if n.role != Follower || n.leader != m.From {
+		n.becomeFollower(m.Term, m.From)
+	}
+	n.lastContact = time.Now()
+
+	// Entries covered by the snapshot are committed, so they match the leader's log.
+	if m.PrevIndex < n.snapIndex {
+		entries := m.Entries[:0:0]
+		for _, e := range m.Entries {
+			if e.Index > n.snapIndex {
+				entries = append(entries, e)
+			}
+		}
+		m.PrevIndex, m.PrevTerm, m.Entries = n.snapIndex, n.snapTerm, entries
+	}
+
+	reply := message{Type: msgAppendReply, Read: m.Read}
+	if m.PrevIndex > n.lastIndex() {
+		reply.Match = n.lastIndex()
+		n.send(m.From, reply)
+		return
+	}
+	if term := n.termAt(m.PrevIndex); term != m.PrevTerm {
+		// The leader skips the whole conflicting term.
+		i := m.PrevIndex
+		for i > n.snapIndex+1 && n.termAt(i-1) == term {
+			i--
+		}
+		reply.Match = i - 1
+		n.send(m.From, reply)
+		return
+	}
+
+	for i, e := range m.Entries {
+		if e.Index <= n.lastIndex() {
+			if n.termAt(e.Index) == e.Term {
+				continue
+			}
+			if err := n.truncate(e.Index); err != nil {
+				log.Println("raft:", err)
+				return
+			}
+		}
+		if err := n.append(m.Entries[i:]...); err != nil {
+			log.Println("raft:", err)
+			return
+		}
+		break
+	}
+
+	match := m.PrevIndex + uint64(len(m.Entries))
+	if commit := min(m.Commit, match); commit > n.commit {
+		n.commit = commit
+		n.signalApply()
+	}
+	reply.Success, reply.Match = true, match
+	n.send(m.From, reply)
+}
+
+// handleAppendReply advances the follower's position and the commit index, or sends the entries again
+// after the index the follower replied with.
+func (n *Node) handleAppendReply(m message) {
+	p, ok := n.peers[m.From]
+	if n.role != Leader || !ok {
+		return
+	}
+	p.lastReply = time.Now()
+	if m.Read > p.readAck {
+		p.readAck = m.Read
+	}
+
+	if m.Success {
+		if m.Match > p.match {
+			p.match = m.Match
+		}
+		if p.next <= p.match {
+			p.next = p.match + 1
+		}
+		n.advanceCommit()
+	} else {
+		p.next = max(m.Match, p.match) + 1
+	}
+	n.resolveReads()
+
+	if n.role == Leader && (!m.Success || p.next <= n.lastIndex()) {
+		n.sendAppend(m.From, p)
+	}
+}
+
+// advanceCommit commits the entries the majority of the members has. Only entries of the current term are
+// committed by counting, the ones of the previous terms are committed with them.
+// The leader which isn't a member anymore steps down once it's removal is committed.
+func (n *Node) advanceCommit() {
+	if len(n.members) == 0 {
+		return
+	}
+	matches := make([]uint64, 0, len(n.members))
+	for _, node := range n.members {
+		if node == n.opts.Node {
+			matches = append(matches, n.lastIndex())
+		} else if p, ok := n.peers[node]; ok {
+			matches = append(matches, p.match)
+		} else {
+			matches = append(matches, 0)
+		}
+	}
+	sort.Slice(matches, func(i, j int) bool { return matches[i] > matches[j] })
+
+	if index := matches[len(matches)/2]; index > n.commit && n.termAt(index) == n.term {
+		n.commit = index
+		n.signalApply()
+		n.resolveReads()
+	}
+
+	if !n.isMember(n.opts.Node) && n.commit >= n.configIndex {
+		log.Printf("raft: stepping down, removed from the cluster")
+		n.broadcast()
+		n.becomeFollower(n.term, "")
+	}
+}
+
+// sendSnapshot sends the chunk of the snapshot after the bytes the follower has received.
+func (n *Node) sendSnapshot(node string, p *peer) {
+	size := int64(len(n.snapshot))
+	if p.offset > size {
+		p.offset = 0
+	}
+	end := p.offset + maxMessageSize
+	if end > size {
+		end = size
+	}
+	n.send(node, message{Type: msgSnapshot, Commit: n.commit, Read: n.readSeq, Snapshot: &snapshotChunk{
+		Index:   n.snapIndex,
+		Term:    n.snapTerm,
+		Members: n.snapMembers,
+		Size:    size,
+		Offset:  p.offset,
+		Data:    n.snapshot[p.offset:end],
+	}})
+}
+
+// handleSnapshot collects chunks of the leader's snapshot. Once it's complete, it's saved and the log is replaced by it,
+// except for the entries after it, if the log has the last entry of the snapshot. The FSM is restored by the applier.
+// The reply has the number of received bytes, it's the size of the snapshot once it's installed.
+func (n *Node) handleSnapshot(m message) {
+	if n.role != Follower || n.leader != m.From {
+		n.becomeFollower(m.Term, m.From)
+	}
+	n.lastContact = time.Now()
+
+	c := m.Snapshot
+	reply := message{Type: msgSnapshotReply, Read: m.Read, Snapshot: &snapshotChunk{Index: c.Index, Term: c.Term, Size: c.Size, Offset: c.Size}}
+	if c.Index <= n.commit {
+		n.transfer = nil
+		n.send(m.From, reply)
+		return
+	}
+
+	if n.transfer == nil || n.transfer.Index != c.Index || n.transfer.Term != c.Term {
+		n.transfer = &snapshotChunk{Index: c.Index, Term: c.Term, Members: c.Members, Size: c.Size}
+	}
+	if c.Offset == int64(len(n.transfer.Data)) {
+		n.transfer.Data = append(n.transfer.Data, c.Data...)
+	}
+	if int64(len(n.transfer.Data)) < c.Size {
+		reply.Snapshot.Offset = int64(len(n.transfer.Data))
+		n.send(m.From, reply)
+		return
+	}
+
+	if err := n.install(n.transfer); err != nil {
+		log.Println("raft:", err)
+		return
+	}
+	log.Printf("raft: installed the snapshot of the leader at %d:%d", c.Index, c.Term)
+	n.transfer = nil
+	n.send(m.From, reply)
+}
+
+// install saves the snapshot and removes entries it covers from the log.
+func (n *Node) install(c *snapshotChunk) error {
+	meta := snapshotMeta{Index: c.Index, Term: c.Term, Members: c.Members}
+	if err := n.storage.saveSnapshot(meta, c.Data); err != nil {
+		return err
+	}
+
+	var entries []Entry
+	if n.termAt(c.Index) == c.Term {
+		entries = n.entries[c.Index-n.snapIndex:]
+	}
+	if err := n.storage.rewriteLog(entries); err != nil {
+		return err
+	}
+
+	n.entries = entries
+	n.snapIndex, n.snapTerm, n.snapMembers, n.snapshot = c.Index, c.Term, c.Members, c.Data
+	n.resetMembers()
+	if n.commit < c.Index {
+		n.commit = c.Index
+	}
+	n.signalApply()
+	return nil
+}
+
+// handleSnapshotReply advances the follower's position in the snapshot, the follower which has installed it
+// receives entries after it.
+func (n *Node) handleSnapshotReply(m message) {
+	p, ok := n.peers[m.From]
+	if n.role != Leader || !ok || m.Snapshot == nil {
+		return
+	}
+	p.lastReply = time.Now()
+	if m.Read > p.readAck {
+		p.readAck = m.Read
+	}
+
+	c := m.Snapshot
+	if c.Offset >= c.Size {
+		p.offset = 0
+		if c.Index > p.match {
+			p.match = c.Index
+		}
+		p.next = p.match + 1
+		n.advanceCommit()
+	} else if c.Index == n.snapIndex {
+		p.offset = c.Offset
+	}
+	n.resolveReads()
+
+	if n.role == Leader {
+		n.sendAppend(m.From, p)
+	}
+}
+
+// ApplyWorker applies committed entries to the FSM in the order of the log (or restores it from the installed snapshot)
+// and compacts the log when enough entries are applied after the snapshot.
+// It tells about the changes of the leadership once the leader applied the noop entry of it's term.
+// The function is designed to run until the node is stopped.
+func (n *Node) ApplyWorker() {
+	n.signalApply()
+	for {
+		select {
+		case <-n.applyCh:
+			n.applyCommitted()
+		case <-n.stop:
+			return
+		}
+	}
+}
+
+func (n *Node) applyCommitted() {
+	for {
+		n.lock.Lock()
+		if n.stopped {
+			n.lock.Unlock()
+			return
+		}
+		if n.applied < n.snapIndex {
+			data, index := n.snapshot, n.snapIndex
+			n.lock.Unlock()
+			if err := n.fsm.Restore(bytes.NewReader(data)); err != nil {
+				log.Println("raft:", err)
+				return
+			}
+			n.lock.Lock()
+			n.applied = index
+			n.notifyApplied()
+			n.lock.Unlock()
+			continue
+		}
+		if n.applied >= n.commit {
+			n.lock.Unlock()
+			break
+		}
+		entries := append([]Entry{}, n.entries[n.applied-n.snapIndex:n.commit-n.snapIndex]...)
+		n.lock.Unlock()
+
+		for _, e := range entries {
+			if e.Type == "" {
+				n.fsm.Apply(e)
+			}
+
+			n.lock.Lock()
+			// The snapshot of the leader may be installed in the meantime, it's restored first.
+			if n.applied < n.snapIndex || n.applied+1 != e.Index {
+				n.lock.Unlock()
+				break
+			}
+			n.applied = e.Index
+			if e.Type == noopEntry && e.Term == n.term && n.role == Leader {
+				n.readyTerm = e.Term
+			}
+			n.notifyApplied()
+			n.lock.Unlock()
+
+			n.transition()
+		}
+	}
+
+	n.transition()
+	n.compact()
+}
+
+// transition calls OnLead when the leader applied the noop entry of it's term, and OnFollow when it's not the leader anymore.
+func (n *Node) transition() {
+	n.lock.Lock()
+	leading := n.role == Leader && n.readyTerm == n.term
+	changed := leading != n.leading
+	n.leading = leading
+	n.lock.Unlock()
+
+	switch {
+	case !changed:
+	case leading && n.opts.OnLead != nil:
+		n.opts.OnLead()
+	case !leading && n.opts.OnFollow != nil:
+		n.opts.OnFollow()
+	}
+}
+
+// compact takes the snapshot of the FSM, when there are enough applied entries after the previous one,
+// and removes the entries it covers from the log. The FSM is changed only by the applier, so the snapshot
+// has the state after the last applied entry.
+func (n *Node) compact() {
+	n.lock.Lock()
+	index := n.applied
+	due := n.opts.SnapshotThreshold > 0 && index >= n.snapIndex+uint64(n.opts.SnapshotThreshold)
+	n.lock.Unlock()
+	if !due {
+		return
+	}
+
+	var buf bytes.Buffer
+	if err := n.fsm.Snapshot(&buf); err != nil {
+		log.Println("raft:", err)
+		return
+	}
+
+	n.lock.Lock()
+	defer n.lock.Unlock()
+	if n.stopped || index <= n.snapIndex {
+		return
+	}
+	members, _ := n.membersAt(index)
+	c := &snapshotChunk{Index: index, Term: n.termAt(index), Members: members, Data: buf.Bytes()}
+	if err := n.install(c); err != nil {
+		log.Println("raft:", err)
+	}
+}
+
+// signalApply wakes up the applier.
+func (n *Node) signalApply() {
+	select {
+	case n.applyCh <- struct{}{}:
+	default:
+	}
+}
+
+// notifyApplied wakes up the ones waiting for the applied entries, it's called under the lock.
+func (n *Node) notifyApplied() {
+	close(n.appliedCh)
+	n.appliedCh = make(chan struct{})
+}
+
+// waitApplied waits until the entry with the index is applied or the deadline passes.
+func (n *Node) waitApplied(index uint64, deadline time.Time) error {
+	timer := time.NewTimer(time.Until(deadline))
+	defer timer.Stop()
+
+	for {
+		n.lock.Lock()
+		applied, ch := n.applied, n.appliedCh
+		n.lock.Unlock()
+		if applied >= index {
+			return nil
+		}
+
+		select {
+		case <-ch:
+		case <-timer.C:
+			return ErrTimeout
+		case <-n.stop:
+			return ErrStopped
+		}
+	}
+}
+
+func min(a, b uint64) uint64 {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func max(a, b uint64) uint64 {
+	if a > b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,769 @@
+package raft
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"math/rand"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/LukaGiorgadze/bloXroute/internal/client"
+	"github.com/LukaGiorgadze/bloXroute/internal/models"
+	"github.com/nats-io/nats.go"
+)
+
+// Roles of the node.
+const (
+	Follower  = "follower"
+	Candidate = "candidate"
+	Leader    = "leader"
+)
+
+// Types of the log entries, commands have the empty type.
+const (
+	noopEntry   = "noop"
+	configEntry = "config"
+)
+
+// Types of the messages exchanged by the nodes.
+const (
+	msgVote          = "vote"
+	msgVoteReply     = "voteReply"
+	msgAppend        = "append"
+	msgAppendReply   = "appendReply"
+	msgSnapshot      = "snapshot"
+	msgSnapshotReply = "snapshotReply"
+	msgRead          = "read"
+	msgReadReply     = "readReply"
+)
+
+// maxMessageSize is the maximum size of entries (or of the snapshot chunk) sent in one message,
+// so it fits in the message with the default NATS max payload of 1MB.
+const maxMessageSize = 256 * 1024
+
+var (
+	ErrNotLeader      = errors.New("Not the leader.")
+	ErrNoLeader       = errors.New("No leader.")
+	ErrTimeout        = errors.New("Raft request timed out.")
+	ErrStopped        = errors.New("Raft node is stopped.")
+	ErrInvalidNode    = errors.New("Invalid node name.")
+	ErrMemberExists   = errors.New("Member already exists.")
+	ErrMemberNotFound = errors.New("Member not found.")
+	ErrLastMember     = errors.New("The last member can't be removed.")
+	ErrConfigChange   = errors.New("Membership change is in progress.")
+	ErrLogGap         = errors.New("Raft log doesn't follow the snapshot.")
+)
+
+// Entry is the entry of the Raft log. Commands (with the empty Type) carry the Data which is applied to the FSM
+// once the entry is committed. The leader appends the noop entry when it's elected,
+// config entries change the Members of the cluster as soon as they are appended.
+type Entry struct {
+	Index   uint64   `json:"index"`
+	Term    uint64   `json:"term"`
+	Type    string   `json:"type,omitempty"`
+	Data    []byte   `json:"data,omitempty"`
+	Members []string `json:"members,omitempty"`
+}
+
+// FSM is the state machine replicated by the cluster. Commands are applied in the order of the log,
+// one at a time. Snapshot writes the state after the last applied command, Restore replaces the state with it.
+type FSM interface {
+	Apply(Entry)
+	Snapshot(io.Writer) error
+	Restore(io.Reader) error
+}
+
+// Options configure the node.
+type Options struct {
+	// Node is the unique name of the node, it's used in the subject where the node receives messages.
+	Node string
+	// Peers are the members of the initial cluster (including this node). They are used until the first
+	// membership change, the node started without them waits until the leader adds it to the cluster.
+	Peers []string
+	// Dir is the directory where the node keeps it's state, log and snapshot.
+	Dir string
+	// ElectionTimeout is the minimum time without the leader after which the node starts the election,
+	// the actual timeout is chosen randomly up to twice of it.
+	ElectionTimeout time.Duration
+	// Heartbeat is the interval of the leader's messages to the followers.
+	Heartbeat time.Duration
+	// SnapshotThreshold is the number of applied entries after which the snapshot is taken and the log is compacted,
+	// 0 means the log is never compacted.
+	SnapshotThreshold int
+	// MsgClient is used to exchange messages with other nodes.
+	MsgClient client.IMessageClient
+	// OnLead is called when the node is elected and it applied all entries of the previous terms,
+	// OnFollow is called when it's not the leader anymore. They are called by the applier, in order.
+	OnLead   func()
+	OnFollow func()
+}
+
+// message is exchanged by the nodes, fields are used depending on the Type.
+//   - vote: the candidate requests the vote, LastIndex and LastTerm are the ones of the last entry of it's log.
+//   - voteReply: Granted is set when the vote is given.
+//   - append: the leader appends Entries after the one with the PrevIndex and the PrevTerm, Commit is it's commit index.
+//   - appendReply: Success is set when the entries were appended, Match is the index of the last appended one,
+//     or the index after which the leader should try again.
+//   - snapshot, snapshotReply: the chunk of the leader's snapshot and the number of bytes the follower has received.
+//   - read, readReply: the read index request forwarded by the follower and the leader's commit index in Match.
+//
+// Read of the leader's messages is the sequence of it's read round, which is echoed in the replies (see read.go),
+// or the ID of the forwarded read request.
+type message struct {
+	Type      string         `json:"type"`
+	From      string         `json:"from"`
+	Term      uint64         `json:"term"`
+	LastIndex uint64         `json:"lastIndex,omitempty"`
+	LastTerm  uint64         `json:"lastTerm,omitempty"`
+	Granted   bool           `json:"granted,omitempty"`
+	PrevIndex uint64         `json:"prevIndex,omitempty"`
+	PrevTerm  uint64         `json:"prevTerm,omitempty"`
+	Entries   []Entry        `json:"entries,omitempty"`
+	Commit    uint64         `json:"commit,omitempty"`
+	Success   bool           `json:"success,omitempty"`
+	Match     uint64         `json:"match,omitempty"`
+	Read      uint64         `json:"read,omitempty"`
+	Snapshot  *snapshotChunk `json:"snapshot,omitempty"`
+}
+
+// snapshotChunk is the part of the snapshot with the Index, Term and Members, which starts at the Offset.
+type snapshotChunk struct {
+	Index   uint64   `json:"index"`
+	Term    uint64   `json:"term"`
+	Members []string `json:"members,omitempty"`
+	Size    int64    `json:"size"`
+	Offset  int64    `json:"offset"`
+	Data    []byte   `json:"data,omitempty"`
+}
+
+// peer is the state of the follower kept by the leader.
+type peer struct {
+	// next is the index of the next entry sent to the follower, match is the index of the last entry it's known to have.
+	next, match uint64
+	// readAck is the latest read round acknowledged by the follower.
+	readAck uint64
+	// offset is the number of bytes of the snapshot the follower has received.
+	offset int64
+	// lastReply is when the follower replied last time, the leader steps down without the replies of the majority.
+	lastReply time.Time
+}
+
+// Node is the member of the Raft cluster. Commands are proposed to the leader, which appends them to it's log
+// and replicates them to the followers. Once the majority of the members has the entry, it's committed
+// and every node applies it to it's FSM. The leader is elected by the majority, when the followers don't hear from it.
+//
+// Nodes exchange messages on their subjects (see client.RaftNodeSubject), the log is kept on the disk with the state
+// of the node and it's compacted into the snapshot of the FSM. Reads are linearized by the read index (see read.go).
+type Node struct {
+	opts    Options
+	fsm     FSM
+	storage *storage
+	rand    *rand.Rand
+
+	// lock guards all fields below.
+	lock     sync.Mutex
+	role     string
+	term     uint64
+	votedFor string
+	leader   string
+	votes    map[string]bool
+
+	// entries of the log follow the snapshot with the snapIndex and the snapTerm.
+	// snapshot is the data of the saved snapshot, which is sent to the followers behind it.
+	entries     []Entry
+	snapIndex   uint64
+	snapTerm    uint64
+	snapMembers []string
+	snapshot    []byte
+
+	// members are the members of the latest config entry of the log, configIndex is it's index.
+	members     []string
+	configIndex uint64
+
+	commit  uint64
+	applied uint64
+	// readyTerm is the term of the last noop entry applied by the leader, leading is set when OnLead was called.
+	readyTerm uint64
+	leading   bool
+
+	// peers are the followers of the leader.
+	peers map[string]*peer
+	// transfer is the snapshot being received from the leader.
+	transfer *snapshotChunk
+
+	lastContact time.Time
+	timeout     time.Duration
+
+	// reads are the pending read requests of the leader, forwarded are the ones the follower sent to the leader.
+	readSeq   uint64
+	reads     []*readRequest
+	readID    uint64
+	forwarded map[uint64]chan readResult
+
+	applyCh   chan struct{}
+	appliedCh chan struct{}
+	stop      chan struct{}
+	stopped   bool
+}
+
+// New opens the storage of the node in the directory, restores the FSM from the snapshot and loads the log.
+// Entries of the log after the snapshot are applied once the node learns they are committed.
+func New(opts Options, fsm FSM) (*Node, error) {
+	if !client.ValidNodeName(opts.Node) {
+		return nil, ErrInvalidNode
+	}
+	s, err := openStorage(opts.Dir)
+	if err != nil {
+		return nil, err
+	}
+
+	n := &Node{
+		opts:      opts,
+		fsm:       fsm,
+		storage:   s,
+		rand:      rand.New(rand.NewSource(time.Now().UnixNano())),
+		role:      Follower,
+		forwarded: make(map[uint64]chan readResult),
+		applyCh:   make(chan struct{}, 1),
+		appliedCh: make(chan struct{}),
+		stop:      make(chan struct{}),
+	}
+	if err = n.load(); err != nil {
+		s.close()
+		return nil, err
+	}
+	return n, nil
+}
+
+// load loads the state, the snapshot and the log of the node.
+func (n *Node) load() error {
+	st, err := n.storage.loadState()
+	if err != nil {
+		return err
+	}
+	n.term, n.votedFor = st.Term, st.VotedFor
+
+	meta, data, ok, err := n.storage.loadSnapshot()
+	if err != nil {
+		return err
+	}
+	if ok {
+		if err = n.fsm.Restore(bytes.NewReader(data)); err != nil {
+			return err
+		}
+		n.snapIndex, n.snapTerm, n.snapMembers, n.snapshot = meta.Index, meta.Term, meta.Members, data
+		n.commit, n.applied = meta.Index, meta.Index
+	}
+
+	entries, clean, err := n.storage.loadLog(n.snapIndex)
+	if err != nil {
+		return err
+	}
+	if len(entries) > 0 && entries[0].Index != n.snapIndex+1 {
+		return ErrLogGap
+	}
+	if !clean {
+		if err = n.storage.rewriteLog(entries); err != nil {
+			return err
+		}
+	}
+	n.entries = entries
+	n.resetMembers()
+	return nil
+}
+
+// Start subscribes to the subject of the node and to the admin subjects, and runs the workers of the node.
+func (n *Node) Start() error {
+	msgClient := n.opts.MsgClient
+
+	n.lock.Lock()
+	n.resetTimeout()
+	n.lock.Unlock()
+
+	err := msgClient.Subscribe(client.RaftNodeSubject(n.opts.Node), func(msg *nats.Msg) {
+		var m message
+		if err := json.Unmarshal(msg.Data, &m); err != nil {
+			log.Println(err)
+			return
+		}
+		n.step(m)
+	})
+	if err != nil {
+		return err
+	}
+	if err = n.subscribeAdmin(); err != nil {
+		return err
+	}
+
+	go n.TickWorker()
+	go n.ApplyWorker()
+	return nil
+}
+
+// Stop stops the workers, unsubscribes from the subjects and closes the storage.
+func (n *Node) Stop() {
+	n.lock.Lock()
+	if n.stopped {
+		n.lock.Unlock()
+		return
+	}
+	n.stopped = true
+	close(n.stop)
+	n.lock.Unlock()
+
+	for _, subj := range []client.Subject{client.RaftNodeSubject(n.opts.Node), client.RaftStatusSubject, client.RaftAddSubject, client.RaftRemoveSubject} {
+		n.opts.MsgClient.Unsubscribe(subj)
+	}
+
+	n.lock.Lock()
+	defer n.lock.Unlock()
+	if err := n.storage.close(); err != nil {
+		log.Println(err)
+	}
+}
+
+// TickWorker sends heartbeats of the leader every interval, the follower starts the election
+// when it doesn't hear from the leader for the election timeout.
+// The function is designed to run until the node is stopped.
+func (n *Node) TickWorker() {
+	ticker := time.NewTicker(n.opts.Heartbeat)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			n.tick()
+		case <-n.stop:
+			return
+		}
+	}
+}
+
+func (n *Node) tick() {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+
+	if n.stopped {
+		return
+	}
+	if n.role == Leader {
+		// The leader which is cut off from the majority steps down, so it doesn't accept commands which can't be committed.
+		if !n.quorumActive() {
+			log.Printf("raft: stepping down, the majority of term %d is not reachable", n.term)
+			n.becomeFollower(n.term, "")
+			return
+		}
+		n.broadcast()
+		return
+	}
+	if time.Since(n.lastContact) >= n.timeout && n.isMember(n.opts.Node) {
+		n.campaign()
+	}
+}
+
+// Propose appends the command to the log of the leader and replicates it to the followers.
+// It returns the index and the term of the entry, the command is applied once it's committed.
+func (n *Node) Propose(data []byte) (index, term uint64, err error) {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+
+	if n.stopped {
+		return 0, 0, ErrStopped
+	}
+	if n.role != Leader {
+		return 0, 0, ErrNotLeader
+	}
+	e := Entry{Index: n.lastIndex() + 1, Term: n.term, Data: data}
+	if err = n.append(e); err != nil {
+		return
+	}
+	n.advanceCommit()
+	n.broadcast()
+	return e.Index, e.Term, nil
+}
+
+// Leading reports whether the node is the leader which applied all entries of the previous terms.
+func (n *Node) Leading() bool {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+	return n.leading
+}
+
+// Status returns the status of the node, the leader knows how far every member is.
+func (n *Node) Status() models.RaftStatus {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+
+	status := models.RaftStatus{
+		Node:    n.opts.Node,
+		Role:    n.role,
+		Term:    n.term,
+		Leader:  n.leader,
+		Commit:  n.commit,
+		Applied: n.applied,
+	}
+	for _, node := range n.members {
+		member := models.RaftMember{Node: node}
+		if node == n.opts.Node {
+			member.Match = n.lastIndex()
+		} else if p, ok := n.peers[node]; ok {
+			member.Match = p.match
+		}
+		status.Members = append(status.Members, member)
+	}
+	return status
+}
+
+// AddMember adds the node to the cluster and waits until the change is applied.
+// Only one member is added or removed at a time, so the majorities of the old and the new members always overlap.
+func (n *Node) AddMember(node string, timeout time.Duration) error {
+	return n.changeMembers(node, true, timeout)
+}
+
+// RemoveMember removes the node from the cluster and waits until the change is applied.
+// The leader which removes itself steps down once the change is committed.
+func (n *Node) RemoveMember(node string, timeout time.Duration) error {
+	return n.changeMembers(node, false, timeout)
+}
+
+func (n *Node) changeMembers(node string, add bool, timeout time.Duration) error {
+	n.lock.Lock()
+	switch {
+	case n.stopped:
+		n.lock.Unlock()
+		return ErrStopped
+	case n.role != Leader:
+		n.lock.Unlock()
+		return ErrNotLeader
+	case !client.ValidNodeName(node):
+		n.lock.Unlock()
+		return ErrInvalidNode
+	case n.configIndex > n.commit:
+		n.lock.Unlock()
+		return ErrConfigChange
+	}
+
+	var members []string
+	switch {
+	case add && n.isMember(node):
+		n.lock.Unlock()
+		return ErrMemberExists
+	case add:
+		members = normalize(append(append([]string{}, n.members...), node))
+	case !n.isMember(node):
+		n.lock.Unlock()
+		return ErrMemberNotFound
+	case len(n.members) == 1:
+		n.lock.Unlock()
+		return ErrLastMember
+	default:
+		for _, m := range n.members {
+			if m != node {
+				members = append(members, m)
+			}
+		}
+	}
+
+	e := Entry{Index: n.lastIndex() + 1, Term: n.term, Type: configEntry, Members: members}
+	if err := n.append(e); err != nil {
+		n.lock.Unlock()
+		return err
+	}
+	log.Printf("raft: changing members to %v", members)
+	n.advanceCommit()
+	n.broadcast()
+	n.lock.Unlock()
+
+	return n.waitApplied(e.Index, time.Now().Add(timeout))
+}
+
+// step handles the message of another node. Messages of the newer term make the node the follower of it,
+// the ones of the older terms are rejected, so their sender finds out about the newer term.
+// It's called for one message at a time.
+func (n *Node) step(m message) {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+
+	if n.stopped {
+		return
+	}
+
+	switch {
+	case m.Term > n.term:
+		// Nodes which don't hear from the leader (e.g. the removed ones) don't disrupt the cluster while it's alive.
+		if m.Type == msgVote && (n.role == Leader || n.leader != "" && time.Since(n.lastContact) < n.opts.ElectionTimeout) {
+			return
+		}
+		leader := ""
+		if m.Type == msgAppend || m.Type == msgSnapshot {
+			leader = m.From
+		}
+		n.becomeFollower(m.Term, leader)
+
+	case m.Term < n.term:
+		switch m.Type {
+		case msgVote:
+			n.send(m.From, message{Type: msgVoteReply})
+		case msgAppend:
+			n.send(m.From, message{Type: msgAppendReply, Match: m.PrevIndex})
+		case msgSnapshot:
+			n.send(m.From, message{Type: msgSnapshotReply})
+		case msgRead:
+			n.send(m.From, message{Type: msgReadReply, Read: m.Read})
+		}
+		return
+	}
+
+	switch m.Type {
+	case msgVote:
+		n.handleVote(m)
+	case msgVoteReply:
+		n.handleVoteReply(m)
+	case msgAppend:
+		n.handleAppend(m)
+	case msgAppendReply:
+		n.handleAppendReply(m)
+	case msgSnapshot:
+		n.handleSnapshot(m)
+	case msgSnapshotReply:
+		n.handleSnapshotReply(m)
+	case msgRead:
+		n.handleRead(m)
+	case msgReadReply:
+		n.handleReadReply(m)
+	}
+}
+
+// campaign starts the election of the next term, the node votes for itself.
+func (n *Node) campaign() {
+	n.term++
+	n.role, n.leader, n.votedFor = Candidate, "", n.opts.Node
+	n.votes = map[string]bool{n.opts.Node: true}
+	n.resetTimeout()
+	if err := n.saveState(); err != nil {
+		log.Println("raft:", err)
+		return
+	}
+
+	if n.quorum(n.votes) {
+		n.becomeLeader()
+		return
+	}
+	last := n.lastIndex()
+	for _, node := range n.members {
+		if node != n.opts.Node {
+			n.send(node, message{Type: msgVote, LastIndex: last, LastTerm: n.termAt(last)})
+		}
+	}
+}
+
+// handleVote gives the vote to the candidate if the node didn't vote in the term yet
+// and the log of the candidate is at least as up-to-date as it's own one.
+func (n *Node) handleVote(m message) {
+	last := n.lastIndex()
+	lastTerm := n.termAt(last)
+	upToDate := m.LastTerm > lastTerm || m.LastTerm == lastTerm && m.LastIndex >= last
+	granted := n.role == Follower && (n.votedFor == "" || n.votedFor == m.From) && upToDate
+
+	if granted {
+		n.votedFor = m.From
+		if err := n.saveState(); err != nil {
+			log.Println("raft:", err)
+			return
+		}
+		n.lastContact = time.Now()
+		n.resetTimeout()
+	}
+	n.send(m.From, message{Type: msgVoteReply, Granted: granted})
+}
+
+func (n *Node) handleVoteReply(m message) {
+	if n.role != Candidate || !m.Granted {
+		return
+	}
+	n.votes[m.From] = true
+	if n.quorum(n.votes) {
+		n.becomeLeader()
+	}
+}
+
+// becomeLeader makes the candidate the leader, it appends the noop entry of it's term.
+// Once the entry is committed, all entries of the previous terms are committed as well.
+func (n *Node) becomeLeader() {
+	log.Printf("raft: elected as the leader of term %d", n.term)
+	n.role, n.leader = Leader, n.opts.Node
+	n.peers = make(map[string]*peer)
+	n.resetPeers()
+
+	if err := n.append(Entry{Index: n.lastIndex() + 1, Term: n.term, Type: noopEntry}); err != nil {
+		log.Println("raft:", err)
+	}
+	n.advanceCommit()
+	n.broadcast()
+}
+
+// becomeFollower makes the node the follower of the term, the leader is empty if it's not known yet.
+func (n *Node) becomeFollower(term uint64, leader string) {
+	wasLeader := n.role == Leader
+	if term > n.term {
+		n.term, n.votedFor = term, ""
+		if err := n.saveState(); err != nil {
+			log.Println("raft:", err)
+		}
+	}
+	n.role, n.leader, n.peers, n.votes = Follower, leader, nil, nil
+	n.lastContact = time.Now()
+	n.resetTimeout()
+
+	if wasLeader {
+		n.failReads()
+		n.signalApply()
+	}
+}
+
+// resetPeers adds the members to the peers of the leader and removes the ones which are not members anymore.
+func (n *Node) resetPeers() {
+	if n.role != Leader {
+		return
+	}
+	for _, node := range n.members {
+		if _, ok := n.peers[node]; !ok && node != n.opts.Node {
+			n.peers[node] = &peer{next: n.lastIndex() + 1, lastReply: time.Now()}
+		}
+	}
+	for node := range n.peers {
+		if !n.isMember(node) {
+			delete(n.peers, node)
+		}
+	}
+}
+
+// quorum reports whether the majority of the members is in the set.
+func (n *Node) quorum(set map[string]bool) bool {
+	count := 0
+	for _, node := range n.members {
+		if set[node] {
+			count++
+		}
+	}
+	return count*2 > len(n.members)
+}
+
+// quorumActive reports whether the majority of the members replied to the leader within the election timeout.
+func (n *Node) quorumActive() bool {
+	active := map[string]bool{n.opts.Node: true}
+	for node, p := range n.peers {
+		if time.Since(p.lastReply) < n.opts.ElectionTimeout {
+			active[node] = true
+		}
+	}
+	return n.quorum(active)
+}
+
+func (n *Node) isMember(node string) bool {
+	for _, m := range n.members {
+		if m == node {
+			return true
+		}
+	}
+	return false
+}
+
+// resetTimeout chooses the random election timeout, so nodes don't start elections at the same time.
+func (n *Node) resetTimeout() {
+	n.timeout = n.opts.ElectionTimeout + time.Duration(n.rand.Int63n(int64(n.opts.ElectionTimeout)))
+}
+
+func (n *Node) saveState() error {
+	return n.storage.saveState(state{Term: n.term, VotedFor: n.votedFor})
+}
+
+// send sends the message of the current term to the node.
+func (n *Node) send(node string, m message) {
+	m.From, m.Term = n.opts.Node, n.term
+	data, err := json.Marshal(m)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	if err := n.opts.MsgClient.Publish(client.RaftNodeSubject(node), data); err != nil {
+		log.Println(err)
+	}
+}
+
+// subscribeAdmin subscribes to the admin subjects, only the leader replies to them.
+// Members are added and removed in the background, since the change waits until it's committed.
+func (n *Node) subscribeAdmin() error {
+	msgClient := n.opts.MsgClient
+
+	err := msgClient.Subscribe(client.RaftStatusSubject, func(msg *nats.Msg) {
+		if n.isLeader() {
+			n.reply(client.ReplySubject(msg), n.Status())
+		}
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, subj := range []client.Subject{client.RaftAddSubject, client.RaftRemoveSubject} {
+		add := subj == client.RaftAddSubject
+		err = msgClient.Subscribe(subj, func(msg *nats.Msg) {
+			var member models.RaftMember
+			if err := json.Unmarshal(msg.Data, &member); err != nil || !n.isLeader() {
+				return
+			}
+			go func(reply string) {
+				err := n.changeMembers(member.Node, add, adminTimeout)
+				status := n.Status()
+				if err != nil {
+					status.Error = err.Error()
+				}
+				n.reply(reply, status)
+			}(client.ReplySubject(msg))
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// adminTimeout is the maximum time to wait for the membership change to be applied.
+const adminTimeout = 5 * time.Second
+
+func (n *Node) isLeader() bool {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+	return n.role == Leader
+}
+
+func (n *Node) reply(subject string, status models.RaftStatus) {
+	if subject == "" {
+		return
+	}
+	data, err := json.Marshal(status)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	if err := n.opts.MsgClient.Publish(client.Subject(subject), data); err != nil {
+		log.Println(err)
+	}
+}
+
+// normalize returns the sorted names without duplicates and empty ones.
+func normalize(nodes []string) []string {
+	sorted := append([]string{}, nodes...)
+	sort.Strings(sorted)
+	var out []string
+	for _, node := range sorted {
+		if node != "" && (len(out) == 0 || node != out[len(out)-1]) {
+			out = append(out, node)
+		}
+	}
+	return out
+}
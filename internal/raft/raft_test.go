@@ -0,0 +1,388 @@
+package raft
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/LukaGiorgadze/bloXroute/internal/client"
+	"github.com/nats-io/nats.go"
+)
+
+// testFSM keeps the data of the applied commands.
+type testFSM struct {
+	lock    sync.Mutex
+	applied []string
+}
+
+func (f *testFSM) Apply(e Entry) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.applied = append(f.applied, string(e.Data))
+}
+
+func (f *testFSM) Snapshot(w io.Writer) error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	return json.NewEncoder(w).Encode(f.applied)
+}
+
+func (f *testFSM) Restore(r io.Reader) error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.applied = nil
+	return json.NewDecoder(r).Decode(&f.applied)
+}
+
+func (f *testFSM) state() []string {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	return append([]string{}, f.applied...)
+}
+
+// testNetwork is the in-memory transport of the nodes. Messages are queued when they are sent (under the lock
+// of the sender) and delivered by flush, messages from or to the disconnected nodes are dropped.
+type testNetwork struct {
+	lock         sync.Mutex
+	queue        []*nats.Msg
+	nodes        map[string]*Node
+	disconnected map[string]bool
+}
+
+func (net *testNetwork) Connect() error      { return nil }
+func (net *testNetwork) Disconnect() error   { return nil }
+func (net *testNetwork) OnDisconnect(func()) {}
+
+func (net *testNetwork) Publish(subject client.Subject, data []byte) error {
+	net.lock.Lock()
+	defer net.lock.Unlock()
+	net.queue = append(net.queue, &nats.Msg{Subject: string(subject), Data: data})
+	return nil
+}
+
+func (net *testNetwork) Request(client.Subject, []byte, time.Duration) (*nats.Msg, error) {
+	return nil, nats.ErrTimeout
+}
+
+func (net *testNetwork) Subscribe(client.Subject, func(msg *nats.Msg)) error { return nil }
+func (net *testNetwork) QueueSubscribe(client.Subject, string, func(msg *nats.Msg)) error {
+	return nil
+}
+func (net *testNetwork) Unsubscribe(client.Subject) {}
+
+// flush delivers queued messages (and the ones sent in reply to them) until there are none.
+func (net *testNetwork) flush() {
+	for {
+		net.lock.Lock()
+		if len(net.queue) == 0 {
+			net.lock.Unlock()
+			return
+		}
+		msg := net.queue[0]
+		net.queue = net.queue[1:]
+		net.lock.Unlock()
+
+		var m message
+		if err := json.Unmarshal(msg.Data, &m); err != nil {
+			continue
+		}
+		to := strings.TrimPrefix(msg.Subject, string(client.RaftNodeSubject("")))
+		if n, ok := net.nodes[to]; ok && !net.disconnected[to] && !net.disconnected[m.From] {
+			n.step(m)
+		}
+	}
+}
+
+// newTestCluster returns nodes of the cluster with the peers on the same network, their workers aren't running.
+func newTestCluster(t *testing.T, peers []string, snapshotThreshold int) (*testNetwork, map[string]*Node) {
+	t.Helper()
+	net := &testNetwork{nodes: map[string]*Node{}, disconnected: map[string]bool{}}
+	for _, name := range peers {
+		n, err := New(Options{
+			Node:              name,
+			Peers:             peers,
+			Dir:               t.TempDir(),
+			ElectionTimeout:   time.Second,
+			Heartbeat:         time.Hour,
+			SnapshotThreshold: snapshotThreshold,
+			MsgClient:         net,
+		}, &testFSM{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(n.Stop)
+		net.nodes[name] = n
+	}
+	return net, net.nodes
+}
+
+// elect makes the node campaign and delivers the messages of the election.
+func elect(net *testNetwork, n *Node) {
+	n.lock.Lock()
+	n.campaign()
+	n.lock.Unlock()
+	net.flush()
+}
+
+func propose(t *testing.T, net *testNetwork, n *Node, data string) {
+	t.Helper()
+	if _, _, err := n.Propose([]byte(data)); err != nil {
+		t.Fatal(err)
+	}
+	net.flush()
+}
+
+// heartbeat sends the heartbeat of the leader, so followers learn about it's commit index.
+func heartbeat(net *testNetwork, n *Node) {
+	n.lock.Lock()
+	n.broadcast()
+	n.lock.Unlock()
+	net.flush()
+}
+
+func TestElection(t *testing.T) {
+	tests := []struct {
+		name         string
+		peers        []string
+		disconnected []string
+		// behind are the nodes whose logs miss the last entry of the others.
+		behind     []string
+		candidate  string
+		wantLeader bool
+	}{
+		{name: "single node", peers: []string{"a"}, candidate: "a", wantLeader: true},
+		{name: "majority", peers: []string{"a", "b", "c"}, candidate: "a", wantLeader: true},
+		{name: "majority with one node down", peers: []string{"a", "b", "c"}, disconnected: []string{"c"}, candidate: "a", wantLeader: true},
+		{name: "minority", peers: []string{"a", "b", "c"}, disconnected: []string{"b", "c"}, candidate: "a"},
+		{name: "candidate behind the others", peers: []string{"a", "b", "c"}, behind: []string{"a"}, candidate: "a"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			net, nodes := newTestCluster(t, tt.peers, 0)
+			for _, name := range tt.behind {
+				for _, other := range tt.peers {
+					if other != name {
+						nodes[other].append(Entry{Index: 1, Term: 1, Data: []byte("x")})
+					}
+				}
+			}
+			for _, name := range tt.disconnected {
+				net.disconnected[name] = true
+			}
+
+			elect(net, nodes[tt.candidate])
+
+			status := nodes[tt.candidate].Status()
+			if leading := status.Role == Leader; leading != tt.wantLeader {
+				t.Fatalf("role = %s, want the leader %v", status.Role, tt.wantLeader)
+			}
+			if status.Term != 1 {
+				t.Fatalf("term = %d, want 1", status.Term)
+			}
+			if !tt.wantLeader {
+				return
+			}
+			for name, n := range nodes {
+				if name == tt.candidate || net.disconnected[name] {
+					continue
+				}
+				if s := n.Status(); s.Role != Follower || s.Leader != tt.candidate || s.Term != 1 {
+					t.Fatalf("node %s: %+v, want the follower of %s", name, s, tt.candidate)
+				}
+			}
+		})
+	}
+}
+
+func TestHandleAppend(t *testing.T) {
+	entries := func(terms ...uint64) []Entry {
+		var out []Entry
+		for i, term := range terms {
+			out = append(out, Entry{Index: uint64(i + 1), Term: term})
+		}
+		return out
+	}
+
+	tests := []struct {
+		name        string
+		log         []Entry
+		prevIndex   uint64
+		prevTerm    uint64
+		entries     []Entry
+		wantSuccess bool
+		wantMatch   uint64
+		wantTerms   []uint64
+	}{
+		{
+			name: "conflicting entries are truncated",
+			log:  entries(1, 1, 2, 2), prevIndex: 2, prevTerm: 1,
+			entries:     []Entry{{Index: 3, Term: 3}},
+			wantSuccess: true, wantMatch: 3, wantTerms: []uint64{1, 1, 3},
+		},
+		{
+			name: "entries it has are kept",
+			log:  entries(1, 1, 3, 3), prevIndex: 1, prevTerm: 1,
+			entries:     []Entry{{Index: 2, Term: 1}, {Index: 3, Term: 3}},
+			wantSuccess: true, wantMatch: 3, wantTerms: []uint64{1, 1, 3, 3},
+		},
+		{
+			name: "entries after the end of the log",
+			log:  entries(1, 1), prevIndex: 4, prevTerm: 3,
+			entries:   []Entry{{Index: 5, Term: 3}},
+			wantMatch: 2, wantTerms: []uint64{1, 1},
+		},
+		{
+			name: "the conflicting term is skipped",
+			log:  entries(1, 2, 2, 2), prevIndex: 4, prevTerm: 3,
+			entries:   []Entry{{Index: 5, Term: 3}},
+			wantMatch: 1, wantTerms: []uint64{1, 2, 2, 2},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			net, nodes := newTestCluster(t, []string{"a", "b"}, 0)
+			n := nodes["b"]
+			if err := n.append(tt.log...); err != nil {
+				t.Fatal(err)
+			}
+
+			n.lock.Lock()
+			n.term = 3
+			n.handleAppend(message{Type: msgAppend, From: "a", Term: 3, PrevIndex: tt.prevIndex, PrevTerm: tt.prevTerm, Entries: tt.entries})
+			n.lock.Unlock()
+
+			var reply message
+			json.Unmarshal(net.queue[len(net.queue)-1].Data, &reply)
+			if reply.Success != tt.wantSuccess || reply.Match != tt.wantMatch {
+				t.Fatalf("reply success = %v match = %d, want %v %d", reply.Success, reply.Match, tt.wantSuccess, tt.wantMatch)
+			}
+
+			// The log on the disk is the same.
+			saved, _, err := n.storage.loadLog(0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, log := range [][]Entry{n.entries, saved} {
+				var terms []uint64
+				for _, e := range log {
+					terms = append(terms, e.Term)
+				}
+				if !equalTerms(terms, tt.wantTerms) {
+					t.Fatalf("terms = %v, want %v", terms, tt.wantTerms)
+				}
+			}
+		})
+	}
+}
+
+func equalTerms(a, b []uint64) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestSnapshotInstall(t *testing.T) {
+	tests := []struct {
+		name string
+		size int
+	}{
+		{name: "one chunk", size: 16},
+		// The snapshot is larger than the message, so it's sent in chunks.
+		{name: "chunks", size: maxMessageSize / 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			net, nodes := newTestCluster(t, []string{"a", "b", "c"}, 4)
+			leader := nodes["a"]
+			elect(net, leader)
+
+			net.disconnected["c"] = true
+			for i := 0; i < 6; i++ {
+				propose(t, net, leader, strings.Repeat(string(rune('a'+i)), tt.size))
+			}
+			leader.applyCommitted()
+			if leader.snapIndex == 0 {
+				t.Fatal("leader didn't compact the log")
+			}
+
+			// The follower is behind the snapshot of the leader, it receives the snapshot and the entries after it.
+			net.disconnected["c"] = false
+			heartbeat(net, leader)
+			propose(t, net, leader, "last")
+			heartbeat(net, leader)
+
+			follower := nodes["c"]
+			follower.applyCommitted()
+			leader.applyCommitted()
+			if follower.snapIndex == 0 {
+				t.Fatal("follower didn't install the snapshot")
+			}
+			want, got := leader.fsm.(*testFSM).state(), follower.fsm.(*testFSM).state()
+			if strings.Join(got, ",") != strings.Join(want, ",") {
+				t.Fatalf("follower state has %d commands, want %d", len(got), len(want))
+			}
+			if s := follower.Status(); s.Applied != leader.Status().Applied {
+				t.Fatalf("follower applied %d, want %d", s.Applied, leader.Status().Applied)
+			}
+
+			// The installed snapshot is loaded after the restart.
+			saved, data, ok, err := follower.storage.loadSnapshot()
+			if err != nil || !ok || saved.Index != follower.snapIndex || !bytes.Equal(data, follower.snapshot) {
+				t.Fatalf("saved snapshot %+v %v %v", saved, ok, err)
+			}
+		})
+	}
+}
+
+func TestRemoveLeader(t *testing.T) {
+	net, nodes := newTestCluster(t, []string{"a", "b", "c"}, 0)
+	leader := nodes["a"]
+	elect(net, leader)
+
+	// The leader removes itself, it's still the leader until the change is committed by the rest.
+	leader.lock.Lock()
+	if err := leader.append(Entry{Index: leader.lastIndex() + 1, Term: leader.term, Type: configEntry, Members: []string{"b", "c"}}); err != nil {
+		t.Fatal(err)
+	}
+	leader.advanceCommit()
+	if leader.role != Leader {
+		t.Fatal("leader stepped down before the change is committed")
+	}
+	leader.broadcast()
+	leader.lock.Unlock()
+	net.flush()
+
+	if s := leader.Status(); s.Role != Follower || s.Commit < leader.configIndex {
+		t.Fatalf("removed leader: %+v", s)
+	}
+	// It doesn't start elections as it's not a member anymore.
+	leader.lock.Lock()
+	leader.lastContact = time.Now().Add(-time.Hour)
+	leader.lock.Unlock()
+	leader.tick()
+	if s := leader.Status(); s.Role != Follower {
+		t.Fatalf("removed node is %s", s.Role)
+	}
+
+	// The rest elect the leader among themselves, once they don't hear from the removed one.
+	nodes["c"].lock.Lock()
+	nodes["c"].lastContact = time.Now().Add(-time.Hour)
+	nodes["c"].lock.Unlock()
+	elect(net, nodes["b"])
+	if s := nodes["b"].Status(); s.Role != Leader || len(s.Members) != 2 {
+		t.Fatalf("new leader: %+v", s)
+	}
+}
@@ -0,0 +1,154 @@
+package raft
+
+import "time"
+
+// readRequest is the read index request pending on the leader, it's served in the read round with the seq.
+// From is the follower which forwarded the request with the ID, it's empty for the requests of the leader itself.
+type readRequest struct {
+	seq  uint64
+	from string
+	id   uint64
+	done chan readResult
+}
+
+// readResult is the commit index as of which the read is linearizable, or the error.
+type readResult struct {
+	index uint64
+	err   error
+}
+
+// Barrier waits until the node applied all entries committed before it was called, so the state read after it
+// is at least as new as the one acknowledged to any client before. The leader confirms it's still the leader
+// with the majority of the members first (the read index), the follower asks the leader for it's commit index.
+func (n *Node) Barrier(timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	done := make(chan readResult, 1)
+
+	n.lock.Lock()
+	var id uint64
+	switch {
+	case n.stopped:
+		n.lock.Unlock()
+		return ErrStopped
+	case n.role == Leader:
+		n.addRead(&readRequest{done: done})
+	case n.leader == "":
+		n.lock.Unlock()
+		return ErrNoLeader
+	default:
+		n.readID++
+		id = n.readID
+		n.forwarded[id] = done
+		n.send(n.leader, message{Type: msgRead, Read: id})
+	}
+	n.lock.Unlock()
+
+	timer := time.NewTimer(time.Until(deadline))
+	defer timer.Stop()
+
+	var result readResult
+	select {
+	case result = <-done:
+	case <-timer.C:
+		result.err = ErrTimeout
+	case <-n.stop:
+		result.err = ErrStopped
+	}
+	if id != 0 {
+		n.lock.Lock()
+		delete(n.forwarded, id)
+		n.lock.Unlock()
+	}
+	if result.err != nil {
+		return result.err
+	}
+	return n.waitApplied(result.index, deadline)
+}
+
+// addRead starts the new read round for the request, it's served once the majority of the members
+// replied to the messages of the round (or of the later ones).
+func (n *Node) addRead(r *readRequest) {
+	n.readSeq++
+	r.seq = n.readSeq
+	n.reads = append(n.reads, r)
+	n.broadcast()
+	n.resolveReads()
+}
+
+// resolveReads serves the requests of the read rounds acknowledged by the majority with the commit index.
+// The leader serves reads only after the noop entry of it's term is committed, before that it doesn't know
+// whether all entries of the previous leaders are committed.
+func (n *Node) resolveReads() {
+	if len(n.reads) == 0 || n.termAt(n.commit) != n.term {
+		return
+	}
+
+	acked := make([]uint64, 0, len(n.members))
+	for _, node := range n.members {
+		if node == n.opts.Node {
+			acked = append(acked, n.readSeq)
+		} else if p, ok := n.peers[node]; ok {
+			acked = append(acked, p.readAck)
+		} else {
+			acked = append(acked, 0)
+		}
+	}
+	// The round acknowledged by the majority, rounds are numbered in order.
+	seq := uint64(0)
+	for _, s := range acked {
+		count := 0
+		for _, other := range acked {
+			if other >= s {
+				count++
+			}
+		}
+		if count*2 > len(acked) && s > seq {
+			seq = s
+		}
+	}
+
+	i := 0
+	for ; i < len(n.reads) && n.reads[i].seq <= seq; i++ {
+		n.serveRead(n.reads[i], readResult{index: n.commit})
+	}
+	n.reads = n.reads[i:]
+}
+
+// failReads fails pending requests when the leader steps down.
+func (n *Node) failReads() {
+	for _, r := range n.reads {
+		n.serveRead(r, readResult{err: ErrNotLeader})
+	}
+	n.reads = nil
+}
+
+func (n *Node) serveRead(r *readRequest, result readResult) {
+	if r.from == "" {
+		r.done <- result
+		return
+	}
+	n.send(r.from, message{Type: msgReadReply, Read: r.id, Success: result.err == nil, Match: result.index})
+}
+
+// handleRead starts the read round for the request forwarded by the follower.
+func (n *Node) handleRead(m message) {
+	if n.role != Leader {
+		n.send(m.From, message{Type: msgReadReply, Read: m.Read})
+		return
+	}
+	n.addRead(&readRequest{from: m.From, id: m.Read})
+}
+
+// handleReadReply passes the leader's commit index to the forwarded request.
+func (n *Node) handleReadReply(m message) {
+	done, ok := n.forwarded[m.Read]
+	if !ok {
+		return
+	}
+	delete(n.forwarded, m.Read)
+	if !m.Success {
+		done <- readResult{err: ErrNotLeader}
+		return
+	}
+	done <- readResult{index: m.Match}
+}
@@ -0,0 +1,208 @@
+package raft
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// Files of the node in it's directory.
+const (
+	stateFile    = "state.json"
+	snapshotFile = "snapshot"
+	logFile      = "log"
+)
+
+// state is the term and the vote of the node, it's saved before the node replies to other nodes.
+type state struct {
+	Term     uint64 `json:"term"`
+	VotedFor string `json:"votedFor,omitempty"`
+}
+
+// snapshotMeta is written as the first line of the snapshot file, before the data of the FSM.
+// Index and Term are the ones of the last entry applied to the FSM, Members are the members as of it.
+type snapshotMeta struct {
+	Index   uint64   `json:"index"`
+	Term    uint64   `json:"term"`
+	Members []string `json:"members"`
+}
+
+// storage keeps the state, the snapshot and the log of the node in the directory, so it survives the restart.
+// The log is the file of entries, one JSON encoding per line. Entries are appended to it and synced to the disk,
+// it's written again when entries are removed from it's end (conflicting ones) or it's beginning (covered by the snapshot).
+type storage struct {
+	dir string
+	log *os.File
+}
+
+func openStorage(dir string) (*storage, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(filepath.Join(dir, logFile), os.O_CREATE|os.O_RDWR|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &storage{dir: dir, log: f}, nil
+}
+
+func (s *storage) close() error {
+	return s.log.Close()
+}
+
+func (s *storage) loadState() (st state, err error) {
+	data, err := os.ReadFile(filepath.Join(s.dir, stateFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return st, nil
+	}
+	if err != nil {
+		return
+	}
+	err = json.Unmarshal(data, &st)
+	return
+}
+
+func (s *storage) saveState(st state) error {
+	data, err := json.Marshal(st)
+	if err != nil {
+		return err
+	}
+	return s.writeFile(stateFile, func(w io.Writer) error {
+		_, err := w.Write(data)
+		return err
+	})
+}
+
+// loadSnapshot returns the saved snapshot, ok is false if there is no snapshot yet.
+func (s *storage) loadSnapshot() (meta snapshotMeta, data []byte, ok bool, err error) {
+	content, err := os.ReadFile(filepath.Join(s.dir, snapshotFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return meta, nil, false, nil
+	}
+	if err != nil {
+		return
+	}
+	line, data, found := bytes.Cut(content, []byte{'\n'})
+	if !found {
+		return meta, nil, false, errors.New("Invalid snapshot.")
+	}
+	if err = json.Unmarshal(line, &meta); err != nil {
+		return
+	}
+	return meta, data, true, nil
+}
+
+func (s *storage) saveSnapshot(meta snapshotMeta, data []byte) error {
+	line, err := json.Marshal(meta)
+	if err != nil {
+		return err
+	}
+	return s.writeFile(snapshotFile, func(w io.Writer) error {
+		if _, err := w.Write(append(line, '\n')); err != nil {
+			return err
+		}
+		_, err := w.Write(data)
+		return err
+	})
+}
+
+// loadLog returns the entries after the index. The last entry which was written partially (e.g. the server crashed
+// in the middle of it) is ignored, it wasn't acknowledged to the leader. clean is false then, so the log should be written again.
+func (s *storage) loadLog(after uint64) (entries []Entry, clean bool, err error) {
+	if _, err = s.log.Seek(0, io.SeekStart); err != nil {
+		return
+	}
+	r := bufio.NewReader(s.log)
+	for {
+		line, err := r.ReadBytes('\n')
+		if err == io.EOF {
+			return entries, len(line) == 0, nil
+		}
+		if err != nil {
+			return nil, false, err
+		}
+		var e Entry
+		if err := json.Unmarshal(line, &e); err != nil {
+			return entries, false, nil
+		}
+		if e.Index > after {
+			entries = append(entries, e)
+		}
+	}
+}
+
+// appendLog appends the entries to the log and syncs it to the disk.
+func (s *storage) appendLog(entries []Entry) error {
+	var buf []byte
+	for _, e := range entries {
+		line, err := json.Marshal(e)
+		if err != nil {
+			return err
+		}
+		buf = append(append(buf, line...), '\n')
+	}
+	if _, err := s.log.Write(buf); err != nil {
+		return err
+	}
+	return s.log.Sync()
+}
+
+// rewriteLog replaces the log with the entries.
+func (s *storage) rewriteLog(entries []Entry) error {
+	err := s.writeFile(logFile, func(w io.Writer) error {
+		for _, e := range entries {
+			line, err := json.Marshal(e)
+			if err != nil {
+				return err
+			}
+			if _, err := w.Write(append(line, '\n')); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(filepath.Join(s.dir, logFile), os.O_CREATE|os.O_RDWR|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	s.log.Close()
+	s.log = f
+	return nil
+}
+
+// writeFile writes the file to the temporary one first and then renames it, so the file is never written partially.
+func (s *storage) writeFile(name string, write func(io.Writer) error) (err error) {
+	tmp, err := os.CreateTemp(s.dir, name+".*.tmp")
+	if err != nil {
+		return
+	}
+	defer func() {
+		if err != nil {
+			tmp.Close()
+			os.Remove(tmp.Name())
+		}
+	}()
+
+	w := bufio.NewWriter(tmp)
+	if err = write(w); err != nil {
+		return
+	}
+	if err = w.Flush(); err != nil {
+		return
+	}
+	if err = tmp.Sync(); err != nil {
+		return
+	}
+	if err = tmp.Close(); err != nil {
+		return
+	}
+	return os.Rename(tmp.Name(), filepath.Join(s.dir, name))
+}
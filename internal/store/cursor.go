@@ -0,0 +1,45 @@
+package store
+
+import (
+	"encoding/binary"
+	"errors"
+	"strconv"
+	"strings"
+
+	"github.com/LukaGiorgadze/bloXroute/internal/models"
+)
+
+var ErrInvalidCursor = errors.New("Invalid cursor.")
+
+// snapshotItem is the item written by Snapshot of the insertion-ordered store with it's position,
+// so cursors stay valid after the store is restored (e.g. after the restart).
+type snapshotItem struct {
+	models.Item
+	Position uint64 `json:"position,omitempty"`
+}
+
+// formatCursor returns the cursor of the item at the position of the insertion-ordered list.
+// The key is kept in the cursor to find the item without scanning the list, while the position
+// allows to continue after it even if the item was removed in the meantime.
+func formatCursor(position uint64, key string) string {
+	return strconv.FormatUint(position, 10) + ":" + key
+}
+
+func parseCursor(cursor string) (position uint64, key string, err error) {
+	pos, key, ok := strings.Cut(cursor, ":")
+	if !ok {
+		return 0, "", ErrInvalidCursor
+	}
+	position, err = strconv.ParseUint(pos, 10, 64)
+	if err != nil {
+		return 0, "", ErrInvalidCursor
+	}
+	return
+}
+
+// positionKey encodes the position as a string, which is sorted lexically in the same order as the positions.
+func positionKey(position uint64) string {
+	var b [8]byte
+	binary.BigEndian.PutUint64(b[:], position)
+	return string(b[:])
+}
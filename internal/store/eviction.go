@@ -0,0 +1,312 @@
+package store
+
+import (
+	"container/heap"
+	"container/list"
+	"errors"
+	"sync"
+	"sync/atomic"
+
+	"github.com/LukaGiorgadze/bloXroute/internal/models"
+)
+
+var ErrUnknownEvictionPolicy = errors.New("Unknown eviction policy.")
+
+// Eviction policies which can be selected in the configuration.
+const (
+	// LRUEviction evicts the least recently used item.
+	LRUEviction = "lru"
+	// LFUEviction evicts the least frequently used item, the least recently used one of them.
+	LFUEviction = "lfu"
+	// FIFOEviction evicts the first item in the order of the store, e.g. the oldest one of the insertion-ordered store.
+	FIFOEviction = "fifo"
+)
+
+// evictionPolicy chooses the item to evict from the store.
+type evictionPolicy interface {
+	// touch is called when the item is added, changed or read.
+	touch(key string)
+	remove(key string)
+	// victim returns the key of the item which should be evicted next.
+	victim(IReader) (string, bool)
+}
+
+// Evictor keeps the store within the limits of the number of items and their total size (bytes of keys and values,
+// see itemSize).
+// It's told about every change of the store and every read of an item, and chooses the items to evict
+// by the policy when the limits are exceeded. Zero disables the limit.
+// It has it's own lock, since items are read (and items of the sharded store are changed) in parallel.
+type Evictor struct {
+	policy     evictionPolicy
+	policyName string
+	// Sizes of the tracked items by their keys.
+	sizes     map[string]int64
+	bytes     int64
+	maxItems  int
+	maxBytes  int64
+	evictions atomic.Uint64
+	lock      sync.Mutex
+}
+
+func NewEvictor(policy string, maxItems int, maxBytes int64) (*Evictor, error) {
+	e := &Evictor{
+		policyName: policy,
+		sizes:      make(map[string]int64),
+		maxItems:   maxItems,
+		maxBytes:   maxBytes,
+	}
+	if err := e.resetPolicy(); err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+func (e *Evictor) resetPolicy() error {
+	switch e.policyName {
+	case LRUEviction:
+		e.policy = newLRU()
+	case LFUEviction:
+		e.policy = newLFU()
+	case FIFOEviction:
+		e.policy = fifo{}
+	default:
+		return ErrUnknownEvictionPolicy
+	}
+	return nil
+}
+
+// Set tracks the added or changed item with the size of it's key and value, it counts as the use of the item.
+func (e *Evictor) Set(key, value string) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	size := itemSize(key, value)
+	e.bytes += size - e.sizes[key]
+	e.sizes[key] = size
+	e.policy.touch(key)
+}
+
+// Touch counts the read of the item as it's use, untracked items are ignored.
+func (e *Evictor) Touch(key string) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	if _, ok := e.sizes[key]; ok {
+		e.policy.touch(key)
+	}
+}
+
+// Remove stops tracking the removed item.
+func (e *Evictor) Remove(key string) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	size, ok := e.sizes[key]
+	if !ok {
+		return
+	}
+	delete(e.sizes, key)
+	e.bytes -= size
+	e.policy.remove(key)
+}
+
+// Fits reports whether the item fits within the size limit at all, the larger one would be evicted
+// right after it's added.
+func (e *Evictor) Fits(key, value string) bool {
+	return e.maxBytes <= 0 || itemSize(key, value) <= e.maxBytes
+}
+
+// itemSize is the size of the key and the value of the item. Values are kept as their JSON encoding,
+// the plain string is counted without the quotes and escapes of it's encoding.
+func itemSize(key, value string) int64 {
+	return int64(len(key) + len(models.Value(value).String()))
+}
+
+// Over reports whether the store exceeds any of the limits.
+func (e *Evictor) Over() bool {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	return (e.maxItems > 0 && len(e.sizes) > e.maxItems) || (e.maxBytes > 0 && e.bytes > e.maxBytes)
+}
+
+// Victim returns the key of the item which should be evicted from the store next.
+// The evicted item should be removed from the store and from the evictor, and counted with Evicted.
+func (e *Evictor) Victim(s IReader) (string, bool) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	return e.policy.victim(s)
+}
+
+// Evicted counts the eviction of the item.
+func (e *Evictor) Evicted() {
+	e.evictions.Add(1)
+}
+
+// Reset tracks the items of the store from scratch, e.g. after it was restored from the snapshot.
+// Items are tracked in the order of the store, as if they were added in it.
+// It should be called while the store is locked.
+func (e *Evictor) Reset(s IReader) error {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	e.sizes = make(map[string]int64)
+	e.bytes = 0
+	if err := e.resetPolicy(); err != nil {
+		return err
+	}
+
+	return s.Iterate("", false, func(item models.Item, _ string) bool {
+		size := itemSize(item.Key, string(item.Value))
+		e.sizes[item.Key] = size
+		e.bytes += size
+		e.policy.touch(item.Key)
+		return true
+	})
+}
+
+// Stats returns the number and the size of the tracked items, the limits and the number of evictions.
+func (e *Evictor) Stats() models.Stats {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	return models.Stats{
+		Items:     len(e.sizes),
+		Bytes:     e.bytes,
+		MaxItems:  e.maxItems,
+		MaxBytes:  e.maxBytes,
+		Policy:    e.policyName,
+		Evictions: e.evictions.Load(),
+	}
+}
+
+// lru keeps keys in the order of their use, from the least recently used one.
+type lru struct {
+	order    *list.List
+	elements map[string]*list.Element
+}
+
+func newLRU() *lru {
+	return &lru{order: list.New(), elements: make(map[string]*list.Element)}
+}
+
+func (p *lru) touch(key string) {
+	if el, ok := p.elements[key]; ok {
+		p.order.MoveToBack(el)
+		return
+	}
+	p.elements[key] = p.order.PushBack(key)
+}
+
+func (p *lru) remove(key string) {
+	if el, ok := p.elements[key]; ok {
+		p.order.Remove(el)
+		delete(p.elements, key)
+	}
+}
+
+func (p *lru) victim(IReader) (string, bool) {
+	front := p.order.Front()
+	if front == nil {
+		return "", false
+	}
+	return front.Value.(string), true
+}
+
+// lfuEntry is an element of the lfuHeap.
+type lfuEntry struct {
+	key   string
+	count uint64
+	// The tick of the last use, to choose the least recently used one of the equally used items.
+	tick  uint64
+	index int
+}
+
+// lfuHeap is a min-heap of keys ordered by the number of their uses. It implements container/heap.Interface.
+type lfuHeap []*lfuEntry
+
+func (h lfuHeap) Len() int { return len(h) }
+func (h lfuHeap) Less(i, j int) bool {
+	if h[i].count != h[j].count {
+		return h[i].count < h[j].count
+	}
+	return h[i].tick < h[j].tick
+}
+func (h lfuHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+
+func (h *lfuHeap) Push(x any) {
+	entry := x.(*lfuEntry)
+	entry.index = len(*h)
+	*h = append(*h, entry)
+}
+
+func (h *lfuHeap) Pop() any {
+	old := *h
+	n := len(old)
+	x := old[n-1]
+	old[n-1] = nil
+	*h = old[:n-1]
+	return x
+}
+
+// lfu counts uses of keys. Counts don't decay, but the new key starts from the count of the last victim
+// (dynamic aging), so keys which were used often long ago are evicted once the new ones are used as often,
+// instead of staying in the store forever.
+type lfu struct {
+	heap    lfuHeap
+	entries map[string]*lfuEntry
+	tick    uint64
+	// The count of the last victim.
+	age uint64
+}
+
+func newLFU() *lfu {
+	return &lfu{entries: make(map[string]*lfuEntry)}
+}
+
+func (p *lfu) touch(key string) {
+	p.tick++
+	if entry, ok := p.entries[key]; ok {
+		entry.count++
+		entry.tick = p.tick
+		heap.Fix(&p.heap, entry.index)
+		return
+	}
+	entry := &lfuEntry{key: key, count: p.age + 1, tick: p.tick}
+	p.entries[key] = entry
+	heap.Push(&p.heap, entry)
+}
+
+func (p *lfu) remove(key string) {
+	if entry, ok := p.entries[key]; ok {
+		heap.Remove(&p.heap, entry.index)
+		delete(p.entries, key)
+	}
+}
+
+func (p *lfu) victim(IReader) (string, bool) {
+	if len(p.heap) == 0 {
+		return "", false
+	}
+	p.age = p.heap[0].count
+	return p.heap[0].key, true
+}
+
+// fifo uses the order of the store, so it doesn't track keys.
+type fifo struct{}
+
+func (fifo) touch(string)  {}
+func (fifo) remove(string) {}
+
+func (fifo) victim(s IReader) (key string, ok bool) {
+	_ = s.Iterate("", false, func(item models.Item, _ string) bool {
+		key, ok = item.Key, true
+		return false
+	})
+	return
+}
@@ -0,0 +1,126 @@
+package store
+
+import (
+	"testing"
+)
+
+// evictorStore adds items to the store and tells the evictor about them, like the mutator does.
+type evictorStore struct {
+	IStore
+	evictor *Evictor
+}
+
+func (s evictorStore) add(key string) {
+	s.Add(key, `"v"`, 0)
+	s.evictor.Set(key, `"v"`)
+}
+
+func (s evictorStore) get(key string) {
+	s.evictor.Touch(key)
+}
+
+// evict removes victims until the store is within the limits and returns their keys.
+func (s evictorStore) evict() (keys []string) {
+	for s.evictor.Over() {
+		key, ok := s.evictor.Victim(s)
+		if !ok {
+			break
+		}
+		s.Remove(key)
+		s.evictor.Remove(key)
+		keys = append(keys, key)
+	}
+	return
+}
+
+func TestEvictorPolicies(t *testing.T) {
+	tests := []struct {
+		policy string
+		// Operations before the limit is exceeded: "+key" adds the item, "key" reads it.
+		ops  []string
+		want []string
+	}{
+		{policy: LRUEviction, ops: []string{"+a", "+b", "+c", "a", "+d"}, want: []string{"b"}},
+		{policy: LRUEviction, ops: []string{"+a", "+b", "+c", "a", "b", "+d"}, want: []string{"c"}},
+		{policy: LFUEviction, ops: []string{"+a", "+b", "+c", "c", "a", "a", "+d"}, want: []string{"b"}},
+		// The least recently used one of the equally used items.
+		{policy: LFUEviction, ops: []string{"+a", "+b", "+c", "a", "b", "+d"}, want: []string{"c"}},
+		{policy: FIFOEviction, ops: []string{"+a", "+b", "+c", "a", "+d"}, want: []string{"a"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.policy, func(t *testing.T) {
+			evictor, err := NewEvictor(tt.policy, 3, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			s := evictorStore{IStore: newTestStore(t, OrderedMapType), evictor: evictor}
+			for _, op := range tt.ops {
+				if op[0] == '+' {
+					s.add(op[1:])
+				} else {
+					s.get(op)
+				}
+			}
+
+			got := s.evict()
+			if len(got) != len(tt.want) || got[0] != tt.want[0] {
+				t.Fatalf("evicted %v, want %v", got, tt.want)
+			}
+			if stats := evictor.Stats(); stats.Items != 3 {
+				t.Fatalf("evictor tracks %d items, want 3", stats.Items)
+			}
+		})
+	}
+}
+
+// New items start from the count of the last victim, so the item used often long ago is evicted eventually.
+func TestEvictorLFUAging(t *testing.T) {
+	evictor, _ := NewEvictor(LFUEviction, 2, 0)
+	s := evictorStore{IStore: newTestStore(t, OrderedMapType), evictor: evictor}
+	s.add("old")
+	for i := 0; i < 3; i++ {
+		s.get("old")
+	}
+
+	var evicted []string
+	for _, key := range []string{"a", "b", "c", "d", "e", "f"} {
+		s.add(key)
+		s.get(key)
+		evicted = append(evicted, s.evict()...)
+	}
+
+	for _, key := range evicted {
+		if key == "old" {
+			return
+		}
+	}
+	t.Fatalf("evicted %v, the old item was never evicted", evicted)
+}
+
+func TestEvictorSize(t *testing.T) {
+	tests := []struct {
+		name  string
+		key   string
+		value string
+		size  int64
+		fits  bool
+	}{
+		{name: "string is counted without quotes", key: "k", value: `"abcd"`, size: 5, fits: true},
+		{name: "document is counted as it's encoding", key: "k", value: `{"a":1}`, size: 8, fits: false},
+		{name: "item larger than the limit", key: "key", value: `"abcdef"`, size: 9, fits: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			evictor, _ := NewEvictor(LRUEviction, 0, 5)
+			if fits := evictor.Fits(tt.key, tt.value); fits != tt.fits {
+				t.Fatalf("Fits() = %v, want %v", fits, tt.fits)
+			}
+			evictor.Set(tt.key, tt.value)
+			if bytes := evictor.Stats().Bytes; bytes != tt.size {
+				t.Fatalf("size = %d, want %d", bytes, tt.size)
+			}
+		})
+	}
+}
@@ -0,0 +1,35 @@
+package store
+
+// expiry is an element of the expiryHeap.
+type expiry struct {
+	key string
+	at  int64
+}
+
+// expiryHeap is a min-heap of items ordered by their expiration time, so the reaper
+// finds expired items without scanning the whole store. It implements container/heap.Interface.
+// Elements are not removed when the item is removed or it's expiration changes,
+// so they should be checked against the store when they are popped.
+type expiryHeap []expiry
+
+func (h expiryHeap) Len() int           { return len(h) }
+func (h expiryHeap) Less(i, j int) bool { return h[i].at < h[j].at }
+func (h expiryHeap) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+
+func (h *expiryHeap) Push(x any) {
+	*h = append(*h, x.(expiry))
+}
+
+func (h *expiryHeap) Pop() any {
+	old := *h
+	n := len(old)
+	x := old[n-1]
+	*h = old[:n-1]
+	return x
+}
+
+// expired reports whether the item with the expiration time `at` is expired at `now`.
+// Zero means the item never expires.
+func expired(at, now int64) bool {
+	return at != 0 && at <= now
+}
@@ -0,0 +1,154 @@
+package store
+
+import (
+	"encoding/json"
+	"io"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/LukaGiorgadze/bloXroute/internal/models"
+)
+
+// historyRecord is an element of the History's queue of changes in the order they were recorded.
+type historyRecord struct {
+	key string
+	at  int64
+}
+
+// History keeps recent changes of items by their keys, including removed items, so the values they had
+// in the past can be looked up. Up to `size` latest changes of every key are kept, changes older than `maxAge`
+// are removed by RemoveOld. Zero disables the limit.
+// It has it's own lock, since items of the sharded store are changed in parallel.
+type History struct {
+	changes map[string][]models.Event
+	size    int
+	maxAge  time.Duration
+	// Recorded changes sorted by their time, so the old changes are removed without scanning all keys.
+	// It's kept only when the age is limited.
+	queue []historyRecord
+	lock  sync.Mutex
+}
+
+func NewHistory(size int, maxAge time.Duration) *History {
+	return &History{
+		changes: make(map[string][]models.Event),
+		size:    size,
+		maxAge:  maxAge,
+	}
+}
+
+// Record appends the change event to the history of it's key, the oldest change is dropped when the size is reached.
+// Time of the event should be set.
+func (h *History) Record(event models.Event) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	changes := append(h.changes[event.Key], event)
+	if h.size > 0 && len(changes) > h.size {
+		changes = changes[len(changes)-h.size:]
+	}
+	h.changes[event.Key] = changes
+
+	if h.maxAge > 0 {
+		// Changes are not always recorded in the order of their time (e.g. expire events have the time of the reaper
+		// and changes of different items are recorded by the lanes of the mutator in parallel), so the record is inserted
+		// in it's place, which is usually at the end.
+		i := sort.Search(len(h.queue), func(i int) bool { return h.queue[i].at > event.Time })
+		h.queue = append(h.queue, historyRecord{})
+		copy(h.queue[i+1:], h.queue[i:])
+		h.queue[i] = historyRecord{key: event.Key, at: event.Time}
+	}
+}
+
+// Get returns the changes of the key from the oldest one.
+func (h *History) Get(key string) []models.Event {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	changes := h.changes[key]
+	// Old changes could be still there if RemoveOld wasn't called since they became old.
+	if h.maxAge > 0 {
+		return recent(changes, time.Now().Add(-h.maxAge).UnixNano())
+	}
+
+	result := make([]models.Event, len(changes))
+	copy(result, changes)
+	return result
+}
+
+// recent returns the copy of the changes after the cutoff time, keeping their order.
+// Changes of the key are kept in the order they were recorded, which is not always the order of their time,
+// so all of them are checked.
+func recent(changes []models.Event, cutoff int64) []models.Event {
+	result := make([]models.Event, 0, len(changes))
+	for _, change := range changes {
+		if change.Time > cutoff {
+			result = append(result, change)
+		}
+	}
+	return result
+}
+
+// RemoveOld removes changes older than the max age at the given time (Unix nanoseconds).
+func (h *History) RemoveOld(now int64) {
+	if h.maxAge <= 0 {
+		return
+	}
+
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	cutoff := now - int64(h.maxAge)
+	keys := make(map[string]struct{})
+	for len(h.queue) > 0 && h.queue[0].at <= cutoff {
+		keys[h.queue[0].key] = struct{}{}
+		h.queue[0] = historyRecord{}
+		h.queue = h.queue[1:]
+	}
+
+	// The change could be already dropped by the size limit.
+	for key := range keys {
+		if changes := recent(h.changes[key], cutoff); len(changes) > 0 {
+			h.changes[key] = changes
+		} else {
+			delete(h.changes, key)
+		}
+	}
+}
+
+// Snapshot writes changes of all keys to the writer as a single JSON line.
+func (h *History) Snapshot(w io.Writer) error {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	return json.NewEncoder(w).Encode(h.changes)
+}
+
+// Restore replaces the history with the one written by Snapshot, limited by the current size.
+func (h *History) Restore(r io.Reader) error {
+	var changes map[string][]models.Event
+	if err := json.NewDecoder(r).Decode(&changes); err != nil {
+		return err
+	}
+
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	h.changes = make(map[string][]models.Event, len(changes))
+	h.queue = nil
+	for key, keyChanges := range changes {
+		if h.size > 0 && len(keyChanges) > h.size {
+			keyChanges = keyChanges[len(keyChanges)-h.size:]
+		}
+		h.changes[key] = keyChanges
+
+		if h.maxAge > 0 {
+			for _, change := range keyChanges {
+				h.queue = append(h.queue, historyRecord{key: key, at: change.Time})
+			}
+		}
+	}
+	sort.Slice(h.queue, func(i, j int) bool { return h.queue[i].at < h.queue[j].at })
+
+	return nil
+}
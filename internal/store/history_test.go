@@ -0,0 +1,78 @@
+package store
+
+import (
+	"testing"
+	"time"
+
+	"github.com/LukaGiorgadze/bloXroute/internal/models"
+)
+
+// Changes are recorded out of the order of their time, e.g. the expire event with the reaper's time.
+func TestHistoryRemoveOldOutOfOrder(t *testing.T) {
+	const maxAge = time.Minute
+
+	tests := []struct {
+		name    string
+		changes []models.Event
+		now     int64
+		want    []uint64
+	}{
+		{
+			name:    "old change recorded after new one",
+			changes: []models.Event{{Key: "k", Seq: 1, Time: 100}, {Key: "k", Seq: 2, Time: 10}, {Key: "k", Seq: 3, Time: 200}},
+			now:     int64(maxAge) + 50,
+			want:    []uint64{1, 3},
+		},
+		{
+			name:    "new change recorded before old ones",
+			changes: []models.Event{{Key: "k", Seq: 1, Time: 200}, {Key: "k", Seq: 2, Time: 10}, {Key: "k", Seq: 3, Time: 20}},
+			now:     int64(maxAge) + 100,
+			want:    []uint64{1},
+		},
+		{
+			name:    "all changes are old",
+			changes: []models.Event{{Key: "k", Seq: 1, Time: 20}, {Key: "k", Seq: 2, Time: 10}},
+			now:     int64(maxAge) + 100,
+			want:    nil,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := NewHistory(0, maxAge)
+			for _, change := range tt.changes {
+				h.Record(change)
+			}
+			h.RemoveOld(tt.now)
+
+			var seqs []uint64
+			for _, change := range h.changes["k"] {
+				seqs = append(seqs, change.Seq)
+			}
+			if len(seqs) != len(tt.want) {
+				t.Fatalf("changes = %v, want %v", seqs, tt.want)
+			}
+			for i := range seqs {
+				if seqs[i] != tt.want[i] {
+					t.Fatalf("changes = %v, want %v", seqs, tt.want)
+				}
+			}
+			if len(tt.want) == 0 && len(h.queue) != 0 {
+				t.Fatalf("queue has %d records, want 0", len(h.queue))
+			}
+		})
+	}
+}
+
+func TestHistoryGetOutOfOrder(t *testing.T) {
+	now := time.Now()
+	h := NewHistory(0, time.Minute)
+	h.Record(models.Event{Key: "k", Seq: 1, Time: now.UnixNano()})
+	h.Record(models.Event{Key: "k", Seq: 2, Time: now.Add(-time.Hour).UnixNano()})
+	h.Record(models.Event{Key: "k", Seq: 3, Time: now.UnixNano()})
+
+	changes := h.Get("k")
+	if len(changes) != 2 || changes[0].Seq != 1 || changes[1].Seq != 3 {
+		t.Fatalf("Get() = %v, want changes 1 and 3", changes)
+	}
+}
@@ -0,0 +1,451 @@
+package store
+
+import (
+	"container/heap"
+	"encoding/json"
+	"errors"
+	"io"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/LukaGiorgadze/bloXroute/internal/models"
+)
+
+var ErrVersionNotFound = errors.New("Store version is not available.")
+
+// IVersion is the committed, read-only version of the versioned store.
+type IVersion interface {
+	IReader
+	// Seq returns the sequence of the version, it's incremented by every commit which changed the store.
+	Seq() uint64
+}
+
+// IVersionedStore is implemented by stores which keep immutable versions of their state.
+// Writers change the store while it's locked and commit the changes, readers read the committed
+// versions without locking the store, so they never block writers and always see a consistent state.
+type IVersionedStore interface {
+	IStore
+	// Commit publishes the changes made since the last commit as the new version and returns it's sequence.
+	// It should be called while the store is locked, once all changes of the mutation are made.
+	Commit() uint64
+	// View returns the version with the sequence, or the latest committed version if the sequence is 0.
+	View(uint64) (IVersion, error)
+}
+
+// mvccItem is the immutable version of the item, changes of the item create new ones.
+type mvccItem struct {
+	key   string
+	value string
+	// Expiration time in Unix nanoseconds, 0 if the item never expires.
+	expiresAt int64
+	revision  uint64
+	seq       uint64
+	// Position of the item in the insertion order.
+	position uint64
+}
+
+func (i *mvccItem) toModel() models.Item {
+	return models.Item{Key: i.key, Value: models.Value(i.value), ExpiresAt: i.expiresAt, Revision: i.revision, Seq: i.seq}
+}
+
+// mvccState is the state of the MVCCMap. Items are kept in persistent treaps by their keys
+// and by their positions, which share all nodes except the changed paths with the previous states.
+// Committed states are never changed, they implement IVersion.
+type mvccState struct {
+	seq       uint64
+	keys      *tnode[string, *mvccItem]
+	positions *tnode[uint64, *mvccItem]
+	// The last assigned position, items added (or moved) to the tail get the next one.
+	position uint64
+	size     int
+}
+
+// MVCCMap keeps items in the insertion order like the OrderedMap, but every commit creates a new immutable version
+// of the store. Readers get the latest (or a recent) version and read it without holding the lock, the versions
+// older than the retained ones are garbage collected once no reader references them.
+type MVCCMap struct {
+	// state is changed by writers while the store is locked, it becomes visible to the readers on Commit.
+	state mvccState
+	// Whether the state was changed since the last commit.
+	dirty bool
+	// The latest committed version.
+	committed atomic.Pointer[mvccState]
+	// Recently committed versions, from the oldest one, for the reads as of the sequence.
+	versions     []*mvccState
+	retain       int
+	versionsLock sync.Mutex
+	// Items with expiration time ordered by it.
+	expiries expiryHeap
+	// The last revision given to the items.
+	revision uint64
+	// Lock method returns the sync.RWMutex used to lock access to the map by writers.
+	lock *sync.RWMutex
+	// Lock method returns the sync.Mutex used to lock access to the output file data.
+	fileLock *sync.Mutex
+	// Output file path where data will be saved.
+	outputFilPath string
+}
+
+// NewMVCCMap creates the map which retains the given number of the latest versions for the reads as of the sequence.
+func NewMVCCMap(retain int, mu *sync.RWMutex, mu2 *sync.Mutex, outputFilPath string) *MVCCMap {
+	m := &MVCCMap{
+		retain:        retain,
+		lock:          mu,
+		fileLock:      mu2,
+		outputFilPath: outputFilPath,
+	}
+	m.committed.Store(&mvccState{})
+	return m
+}
+
+func (m *MVCCMap) Commit() uint64 {
+	if !m.dirty {
+		return m.state.seq
+	}
+	m.dirty = false
+
+	m.state.seq++
+	version := m.state
+	m.committed.Store(&version)
+
+	m.versionsLock.Lock()
+	m.versions = append(m.versions, &version)
+	if len(m.versions) > m.retain {
+		// Release the oldest version, so it can be garbage collected after it's readers are done.
+		m.versions[0] = nil
+		m.versions = m.versions[1:]
+	}
+	m.versionsLock.Unlock()
+
+	return version.seq
+}
+
+func (m *MVCCMap) View(seq uint64) (IVersion, error) {
+	latest := m.committed.Load()
+	if seq == 0 || seq == latest.seq {
+		return latest, nil
+	}
+
+	m.versionsLock.Lock()
+	defer m.versionsLock.Unlock()
+
+	i := sort.Search(len(m.versions), func(i int) bool { return m.versions[i].seq >= seq })
+	if i == len(m.versions) || m.versions[i].seq != seq {
+		return nil, ErrVersionNotFound
+	}
+	return m.versions[i], nil
+}
+
+func (m *MVCCMap) Add(key string, value string, now int64) bool {
+	if existing, exists := tget(m.state.keys, key); exists {
+		// Expired item which wasn't removed by the reaper yet is replaced by the new one.
+		if !expired(existing.expiresAt, now) {
+			return false
+		}
+		m.Remove(key)
+	}
+
+	m.state.position++
+	m.revision++
+	m.put(&mvccItem{key: key, value: value, revision: m.revision, position: m.state.position})
+	m.state.size++
+	return true
+}
+
+func (m *MVCCMap) Remove(key string) bool {
+	item, exists := tget(m.state.keys, key)
+	if !exists {
+		return false
+	}
+
+	m.state.keys = tdelete(m.state.keys, key)
+	m.state.positions = tdelete(m.state.positions, item.position)
+	m.state.size--
+	m.dirty = true
+	return true
+}
+
+// Update keeps the position of the item in the list.
+func (m *MVCCMap) Update(key string, value string, now int64) bool {
+	item, ok := tget(m.state.keys, key)
+	if !ok || expired(item.expiresAt, now) {
+		return false
+	}
+
+	m.revision++
+	updated := *item
+	updated.value = value
+	updated.revision = m.revision
+	m.put(&updated)
+	return true
+}
+
+func (m *MVCCMap) MoveToBack(key string) bool {
+	item, ok := tget(m.state.keys, key)
+	if !ok {
+		return false
+	}
+	if item.position == m.state.position {
+		return true
+	}
+
+	moved := *item
+	m.state.position++
+	moved.position = m.state.position
+	m.state.positions = tdelete(m.state.positions, item.position)
+	m.put(&moved)
+	return true
+}
+
+// put sets the new version of the item in both treaps.
+func (m *MVCCMap) put(item *mvccItem) {
+	m.state.keys = tput(m.state.keys, item.key, item, stringPriority(item.key))
+	m.state.positions = tput(m.state.positions, item.position, item, mix64(item.position))
+	m.dirty = true
+}
+
+// Read methods of the map read the current state, which may contain uncommitted changes,
+// so they should be called while the store is locked. Readers without the lock should use View.
+
+func (m *MVCCMap) Get(key string) (string, bool) {
+	return m.state.Get(key)
+}
+
+func (m *MVCCMap) GetItem(key string) (models.Item, bool) {
+	return m.state.GetItem(key)
+}
+
+func (m *MVCCMap) GetItemAt(key string, now int64) (models.Item, bool) {
+	return m.state.GetItemAt(key, now)
+}
+
+func (m *MVCCMap) GetAll() []models.Item {
+	return m.state.GetAll()
+}
+
+func (m *MVCCMap) Iterate(cursor string, reverse bool, fn func(models.Item, string) bool) error {
+	return m.state.Iterate(cursor, reverse, fn)
+}
+
+func (m *MVCCMap) Cursor(key string) (string, bool) {
+	return m.state.Cursor(key)
+}
+
+func (m *MVCCMap) Scan(prefix, after string, reverse bool, fn func(models.Item) bool) {
+	m.state.Scan(prefix, after, reverse, fn)
+}
+
+func (m *MVCCMap) Range(from, to string, reverse bool, fn func(models.Item) bool) {
+	m.state.Range(from, to, reverse, fn)
+}
+
+func (m *MVCCMap) Expire(key string, at int64) bool {
+	item, ok := tget(m.state.keys, key)
+	if !ok {
+		return false
+	}
+
+	expiring := *item
+	expiring.expiresAt = at
+	m.put(&expiring)
+	if at != 0 {
+		heap.Push(&m.expiries, expiry{key: key, at: at})
+	}
+	return true
+}
+
+func (m *MVCCMap) Revision() uint64 {
+	return m.revision
+}
+
+func (m *MVCCMap) SetRevision(revision uint64) {
+	if revision > m.revision {
+		m.revision = revision
+	}
+}
+
+func (m *MVCCMap) SetSeq(key string, seq uint64) bool {
+	item, ok := tget(m.state.keys, key)
+	if !ok {
+		return false
+	}
+
+	sequenced := *item
+	sequenced.seq = seq
+	m.put(&sequenced)
+	return true
+}
+
+// RemoveExpired pops items from the expiry heap until it reaches the one which is not expired yet.
+func (m *MVCCMap) RemoveExpired(now int64) (keys []string) {
+	for len(m.expiries) > 0 && m.expiries[0].at <= now {
+		e := heap.Pop(&m.expiries).(expiry)
+
+		// The item could be removed or it's expiration changed after it was pushed to the heap.
+		item, ok := tget(m.state.keys, e.key)
+		if !ok || item.expiresAt != e.at {
+			continue
+		}
+
+		m.Remove(e.key)
+		keys = append(keys, e.key)
+	}
+	return
+}
+
+func (m *MVCCMap) Lock() *sync.RWMutex {
+	return m.lock
+}
+
+func (m *MVCCMap) FileLock() *sync.Mutex {
+	return m.fileLock
+}
+
+func (m *MVCCMap) GetOutputFilePath() string {
+	return m.outputFilPath
+}
+
+// Snapshot writes items as JSON lines in the insertion order, so the snapshot is the same as the one of the OrderedMap.
+func (m *MVCCMap) Snapshot(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	var err error
+	tascend(m.state.positions, 0, func(_ uint64, item *mvccItem) bool {
+		err = enc.Encode(snapshotItem{Item: item.toModel(), Position: item.position})
+		return err == nil
+	})
+	return err
+}
+
+// Restore clears the map and adds items written by Snapshot in the same order, they keep their positions.
+// Retained versions are dropped, the restored state becomes visible on the next Commit.
+func (m *MVCCMap) Restore(r io.Reader) error {
+	m.state = mvccState{seq: m.state.seq}
+	m.dirty = true
+	m.expiries = nil
+	m.revision = 0
+
+	m.versionsLock.Lock()
+	m.versions = nil
+	m.versionsLock.Unlock()
+
+	dec := json.NewDecoder(r)
+	for {
+		var i snapshotItem
+		err := dec.Decode(&i)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if i.Position != 0 {
+			m.state.position = i.Position - 1
+		}
+		if !m.Add(i.Key, string(i.Value), 0) {
+			continue
+		}
+		m.Expire(i.Key, i.ExpiresAt)
+		if i.Revision != 0 || i.Seq != 0 {
+			item, _ := tget(m.state.keys, i.Key)
+			restored := *item
+			if i.Revision != 0 {
+				restored.revision = i.Revision
+			}
+			restored.seq = i.Seq
+			m.put(&restored)
+			m.SetRevision(restored.revision)
+		}
+	}
+}
+
+func (s *mvccState) Seq() uint64 {
+	return s.seq
+}
+
+func (s *mvccState) Get(key string) (string, bool) {
+	item, ok := s.GetItem(key)
+	return string(item.Value), ok
+}
+
+func (s *mvccState) GetItem(key string) (models.Item, bool) {
+	return s.GetItemAt(key, time.Now().UnixNano())
+}
+
+func (s *mvccState) GetItemAt(key string, now int64) (models.Item, bool) {
+	item, ok := tget(s.keys, key)
+	if !ok || expired(item.expiresAt, now) {
+		return models.Item{}, false
+	}
+	return item.toModel(), true
+}
+
+func (s *mvccState) GetAll() []models.Item {
+	result := make([]models.Item, 0, s.size)
+	_ = s.Iterate("", false, func(item models.Item, _ string) bool {
+		result = append(result, item)
+		return true
+	})
+	return result
+}
+
+// Iterate finds the position of the cursor in the treap, so it takes O(log n) even if the item was removed.
+func (s *mvccState) Iterate(cursor string, reverse bool, fn func(models.Item, string) bool) error {
+	var position uint64
+	if cursor != "" {
+		var err error
+		if position, _, err = parseCursor(cursor); err != nil {
+			return err
+		}
+	}
+
+	now := time.Now().UnixNano()
+	visit := func(_ uint64, item *mvccItem) bool {
+		return expired(item.expiresAt, now) || fn(item.toModel(), formatCursor(item.position, item.key))
+	}
+
+	if reverse {
+		tdescend(s.positions, position, cursor != "", visit)
+	} else {
+		tascend(s.positions, position+1, visit)
+	}
+	return nil
+}
+
+func (s *mvccState) Cursor(key string) (string, bool) {
+	item, ok := tget(s.keys, key)
+	if !ok {
+		return "", false
+	}
+	return formatCursor(item.position, item.key), true
+}
+
+func (s *mvccState) Scan(prefix, after string, reverse bool, fn func(models.Item) bool) {
+	from, to, ok := prefixRange(prefix, after, reverse)
+	if !ok {
+		return
+	}
+	s.Range(from, to, reverse, fn)
+}
+
+func (s *mvccState) Range(from, to string, reverse bool, fn func(models.Item) bool) {
+	now := time.Now().UnixNano()
+
+	if reverse {
+		tdescend(s.keys, to, to != "", func(key string, item *mvccItem) bool {
+			if key < from {
+				return false
+			}
+			return expired(item.expiresAt, now) || fn(item.toModel())
+		})
+		return
+	}
+
+	tascend(s.keys, from, func(key string, item *mvccItem) bool {
+		if to != "" && key >= to {
+			return false
+		}
+		return expired(item.expiresAt, now) || fn(item.toModel())
+	})
+}
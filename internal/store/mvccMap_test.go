@@ -0,0 +1,222 @@
+package store
+
+import (
+	"reflect"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/LukaGiorgadze/bloXroute/internal/models"
+)
+
+func newTestMVCCMap(retain int) *MVCCMap {
+	return NewMVCCMap(retain, &sync.RWMutex{}, &sync.Mutex{}, "")
+}
+
+// itemValues returns the keys and the values of the items in the order of the version.
+func itemValues(items []models.Item) (values []string) {
+	for _, item := range items {
+		values = append(values, item.Key+"="+item.Value.String())
+	}
+	return
+}
+
+// Readers of the version don't see the changes committed after they got it, nor the uncommitted ones.
+func TestMVCCSnapshotIsolation(t *testing.T) {
+	m := newTestMVCCMap(10)
+	m.Add("a", `"1"`, 0)
+	m.Add("b", `"1"`, 0)
+	m.Commit()
+
+	version, err := m.View(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"a=1", "b=1"}
+
+	m.Update("a", `"2"`, 0)
+	m.Remove("b")
+	m.Add("c", `"2"`, 0)
+	m.MoveToBack("a")
+	latest, _ := m.View(0)
+	if got := itemValues(latest.GetAll()); !reflect.DeepEqual(got, want) {
+		t.Fatalf("latest version before the commit = %v, want %v", got, want)
+	}
+
+	m.Commit()
+	if got := itemValues(version.GetAll()); !reflect.DeepEqual(got, want) {
+		t.Fatalf("version = %v, want %v", got, want)
+	}
+	if _, ok := version.Get("c"); ok {
+		t.Fatal("version has the item added later")
+	}
+	if value, ok := version.Get("b"); !ok || value != `"1"` {
+		t.Fatalf("removed item = %s %v", value, ok)
+	}
+	var keys []string
+	if err := version.Iterate("", false, func(item models.Item, _ string) bool {
+		keys = append(keys, item.Key)
+		return true
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(keys, []string{"a", "b"}) {
+		t.Fatalf("iterated %v", keys)
+	}
+
+	latest, _ = m.View(0)
+	if got := itemValues(latest.GetAll()); !reflect.DeepEqual(got, []string{"c=2", "a=2"}) {
+		t.Fatalf("latest version = %v", got)
+	}
+}
+
+// Every version read while the writer commits is consistent, all items have the value of the same commit.
+func TestMVCCConcurrentReads(t *testing.T) {
+	m := newTestMVCCMap(10)
+	keys := []string{"a", "b", "c", "d"}
+	write := func(i int) {
+		m.Lock().Lock()
+		defer m.Lock().Unlock()
+		for _, key := range keys {
+			if !m.Update(key, strconv.Itoa(i), 0) {
+				m.Add(key, strconv.Itoa(i), 0)
+			}
+		}
+		m.Commit()
+	}
+	write(0)
+
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	for r := 0; r < 4; r++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+				version, err := m.View(0)
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				items := version.GetAll()
+				for _, item := range items {
+					if item.Value != items[0].Value || len(items) != len(keys) {
+						t.Errorf("version %d isn't consistent: %v", version.Seq(), items)
+						return
+					}
+				}
+			}
+		}()
+	}
+	for i := 1; i <= 200; i++ {
+		write(i)
+	}
+	close(done)
+	wg.Wait()
+}
+
+// Only the retained versions can be read as of their sequence.
+func TestMVCCVersionPruning(t *testing.T) {
+	m := newTestMVCCMap(3)
+	for i := 1; i <= 5; i++ {
+		m.Add(strconv.Itoa(i), `"v"`, 0)
+		if seq := m.Commit(); seq != uint64(i) {
+			t.Fatalf("commit %d has seq %d", i, seq)
+		}
+	}
+	oldest, err := m.View(3)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The commit without changes doesn't create the version.
+	if seq := m.Commit(); seq != 5 {
+		t.Fatalf("empty commit seq = %d, want 5", seq)
+	}
+
+	tests := []struct {
+		seq  uint64
+		want int
+		err  error
+	}{
+		{seq: 1, err: ErrVersionNotFound},
+		{seq: 2, err: ErrVersionNotFound},
+		{seq: 3, want: 3},
+		{seq: 4, want: 4},
+		{seq: 5, want: 5},
+		{seq: 6, err: ErrVersionNotFound},
+		{seq: 0, want: 5},
+	}
+	for _, tt := range tests {
+		version, err := m.View(tt.seq)
+		if err != tt.err {
+			t.Fatalf("View(%d) error = %v, want %v", tt.seq, err, tt.err)
+		}
+		if err == nil && len(version.GetAll()) != tt.want {
+			t.Fatalf("View(%d) has %d items, want %d", tt.seq, len(version.GetAll()), tt.want)
+		}
+	}
+
+	// The reader keeps the version it got, even after it's pruned.
+	m.Add("6", `"v"`, 0)
+	m.Commit()
+	if _, err := m.View(3); err != ErrVersionNotFound {
+		t.Fatalf("pruned version error = %v", err)
+	}
+	if len(oldest.GetAll()) != 3 || oldest.Seq() != 3 {
+		t.Fatalf("pruned version %d has %d items", oldest.Seq(), len(oldest.GetAll()))
+	}
+}
+
+// Reads as of the sequence return items as they were in the version.
+func TestMVCCReadAsOf(t *testing.T) {
+	expiresAt := time.Now().Add(time.Hour).UnixNano()
+	m := newTestMVCCMap(10)
+	m.Add("a", `"1"`, 0)
+	m.Add("b", `"1"`, 0)
+	m.Commit() // 1
+	m.Update("a", `"2"`, 0)
+	m.Commit() // 2
+	m.Remove("b")
+	m.Commit() // 3
+	m.Add("b", `"3"`, 0)
+	m.Expire("a", expiresAt)
+	m.Commit() // 4
+
+	tests := []struct {
+		seq       uint64
+		want      []string
+		revisionA uint64
+		expiresA  int64
+	}{
+		{seq: 1, want: []string{"a=1", "b=1"}, revisionA: 1},
+		{seq: 2, want: []string{"a=2", "b=1"}, revisionA: 3},
+		{seq: 3, want: []string{"a=2"}, revisionA: 3},
+		{seq: 4, want: []string{"a=2", "b=3"}, revisionA: 3, expiresA: expiresAt},
+	}
+
+	for _, tt := range tests {
+		t.Run(strconv.FormatUint(tt.seq, 10), func(t *testing.T) {
+			version, err := m.View(tt.seq)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if version.Seq() != tt.seq {
+				t.Fatalf("seq = %d", version.Seq())
+			}
+			if got := itemValues(version.GetAll()); !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("items = %v, want %v", got, tt.want)
+			}
+			a, ok := version.GetItem("a")
+			if !ok || a.Revision != tt.revisionA || a.ExpiresAt != tt.expiresA {
+				t.Fatalf("item a = %+v", a)
+			}
+		})
+	}
+}
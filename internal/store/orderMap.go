@@ -1,15 +1,30 @@
 package store
 
 import (
-	"fmt"
+	"container/heap"
+	"encoding/json"
+	"io"
 	"sync"
+	"time"
+
+	"github.com/LukaGiorgadze/bloXroute/internal/models"
 )
 
 type item struct {
 	key   string
 	value string
-	next  *item
-	prev  *item
+	// Expiration time in Unix nanoseconds, 0 if the item never expires.
+	expiresAt int64
+	revision  uint64
+	seq       uint64
+	// Position of the item in the list, it increases from the head to the tail.
+	position uint64
+	next     *item
+	prev     *item
+}
+
+func (i *item) toModel() models.Item {
+	return models.Item{Key: i.key, Value: models.Value(i.value), ExpiresAt: i.expiresAt, Revision: i.revision, Seq: i.seq}
 }
 
 // LinkedList
@@ -23,6 +38,20 @@ type OrderedMap struct {
 	tail  *item
 	size  int
 	items map[string]*item
+	// Keys of the items sorted lexically, for prefix scans.
+	keys *skiplist[*item]
+	// The last assigned position, items added (or moved) to the tail get the next one.
+	position uint64
+	// nextPosition assigns positions instead of the own counter, if it's set.
+	// Shards of the ShardedMap share the global one, so their lists can be merged in the insertion order.
+	nextPosition func() uint64
+	// Items sorted by their positions, so the cursor of the item from another shard is found in O(log n).
+	// It's kept only by shards of the ShardedMap.
+	positions *skiplist[*item]
+	// Items with expiration time ordered by it.
+	expiries expiryHeap
+	// The last revision given to the items.
+	revision uint64
 	// Lock method returns the sync.RWMutex used to lock access to the ordered map data structure.
 	lock *sync.RWMutex
 	// Lock method returns the sync.Mutex used to lock access to the output file data.
@@ -37,16 +66,23 @@ func NewOrderedMap(mu *sync.RWMutex, mu2 *sync.Mutex, outputFilPath string) *Ord
 		lock:          mu,
 		fileLock:      mu2,
 		items:         make(map[string]*item),
+		keys:          newSkiplist[*item](),
 		outputFilPath: outputFilPath,
 	}
 }
 
-func (om *OrderedMap) Add(key string, value string) (ok bool) {
-	if _, exists := om.items[key]; exists {
-		return
+func (om *OrderedMap) Add(key string, value string, now int64) (ok bool) {
+	if existing, exists := om.items[key]; exists {
+		// Expired item which wasn't removed by the reaper yet is replaced by the new one.
+		if !expired(existing.expiresAt, now) {
+			return
+		}
+		om.Remove(key)
 	}
 
-	newItem := &item{key: key, value: value}
+	om.revision++
+	newItem := &item{key: key, value: value, revision: om.revision}
+	om.setPosition(newItem)
 
 	if om.head == nil {
 		om.head = newItem
@@ -58,6 +94,7 @@ func (om *OrderedMap) Add(key string, value string) (ok bool) {
 	}
 
 	om.items[key] = newItem
+	om.keys.Set(key, newItem)
 	om.size++
 
 	return !ok
@@ -85,30 +122,262 @@ func (om *OrderedMap) Remove(key string) (ok bool) {
 	}
 
 	delete(om.items, key)
+	om.keys.Delete(key)
+	if om.positions != nil {
+		om.positions.Delete(positionKey(item.position))
+	}
 	om.size--
 
 	return !ok
 }
 
+// Get returns the value of the item. Expired items are treated as removed, even if the reaper didn't remove them yet.
 func (om *OrderedMap) Get(key string) (value string, ok bool) {
 	item, ok := om.items[key]
-	if !ok {
-		return
+	if !ok || expired(item.expiresAt, time.Now().UnixNano()) {
+		return "", false
 	}
 
 	return item.value, ok
 }
 
-func (om *OrderedMap) GetAll() []string {
-	result := make([]string, om.size)
-	index := 0
+func (om *OrderedMap) GetItem(key string) (models.Item, bool) {
+	return om.GetItemAt(key, time.Now().UnixNano())
+}
+
+func (om *OrderedMap) GetItemAt(key string, now int64) (models.Item, bool) {
+	item, ok := om.items[key]
+	if !ok || expired(item.expiresAt, now) {
+		return models.Item{}, false
+	}
+
+	return item.toModel(), true
+}
+
+// Update keeps the position of the item in the list.
+func (om *OrderedMap) Update(key string, value string, now int64) bool {
+	item, ok := om.items[key]
+	if !ok || expired(item.expiresAt, now) {
+		return false
+	}
+
+	om.revision++
+	item.value = value
+	item.revision = om.revision
+	return true
+}
+
+func (om *OrderedMap) MoveToBack(key string) bool {
+	item, ok := om.items[key]
+	if !ok {
+		return false
+	}
+	if item == om.tail {
+		// The tail of the shard may be not the last item of the whole store, so it still gets the next position.
+		if om.nextPosition != nil {
+			om.setPosition(item)
+		}
+		return true
+	}
+
+	// Unlink the item, it's not the tail, so it has the next one.
+	if item == om.head {
+		om.head = item.next
+	} else {
+		item.prev.next = item.next
+	}
+	item.next.prev = item.prev
+
+	// Link it after the tail.
+	om.setPosition(item)
+	item.prev = om.tail
+	item.next = nil
+	om.tail.next = item
+	om.tail = item
+
+	return true
+}
+
+func (om *OrderedMap) GetAll() []models.Item {
+	now := time.Now().UnixNano()
+	result := make([]models.Item, 0, om.size)
 	for item := om.head; item != nil; item = item.next {
-		result[index] = fmt.Sprintf("(%s=%s)", item.key, item.value)
-		index++
+		if expired(item.expiresAt, now) {
+			continue
+		}
+		result = append(result, item.toModel())
 	}
 	return result
 }
 
+func (om *OrderedMap) Iterate(cursor string, reverse bool, fn func(models.Item, string) bool) error {
+	start, err := om.seek(cursor, reverse)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now().UnixNano()
+	for item := start; item != nil; {
+		if !expired(item.expiresAt, now) && !fn(item.toModel(), formatCursor(item.position, item.key)) {
+			return nil
+		}
+		if reverse {
+			item = item.prev
+		} else {
+			item = item.next
+		}
+	}
+	return nil
+}
+
+func (om *OrderedMap) Cursor(key string) (string, bool) {
+	item, ok := om.items[key]
+	if !ok {
+		return "", false
+	}
+	return formatCursor(item.position, item.key), true
+}
+
+// setPosition assigns the next position to the item, which is added (or moved) to the tail.
+func (om *OrderedMap) setPosition(item *item) {
+	if om.positions != nil && item.position != 0 {
+		om.positions.Delete(positionKey(item.position))
+	}
+
+	if om.nextPosition != nil {
+		item.position = om.nextPosition()
+	} else {
+		om.position++
+		item.position = om.position
+	}
+
+	if om.positions != nil {
+		om.positions.Set(positionKey(item.position), item)
+	}
+}
+
+// seek returns the first item after the cursor.
+func (om *OrderedMap) seek(cursor string, reverse bool) (*item, error) {
+	if cursor == "" {
+		if reverse {
+			return om.tail, nil
+		}
+		return om.head, nil
+	}
+
+	position, key, err := parseCursor(cursor)
+	if err != nil {
+		return nil, err
+	}
+
+	if item, ok := om.items[key]; ok && item.position == position {
+		if reverse {
+			return item.prev, nil
+		}
+		return item.next, nil
+	}
+
+	// The item was removed or moved, so positions are compared to find the next one.
+	if om.positions != nil {
+		if reverse {
+			if n := om.positions.lower(positionKey(position)); n != nil {
+				return n.value, nil
+			}
+			return nil, nil
+		}
+		if n := om.positions.find(positionKey(position+1), nil); n != nil {
+			return n.value, nil
+		}
+		return nil, nil
+	}
+	if reverse {
+		for item := om.tail; item != nil; item = item.prev {
+			if item.position < position {
+				return item, nil
+			}
+		}
+		return nil, nil
+	}
+	for item := om.head; item != nil; item = item.next {
+		if item.position > position {
+			return item, nil
+		}
+	}
+	return nil, nil
+}
+
+// Scan walks the key index, so it takes O(log n) to find the first item.
+func (om *OrderedMap) Scan(prefix, after string, reverse bool, fn func(models.Item) bool) {
+	now := time.Now().UnixNano()
+	om.keys.Scan(prefix, after, reverse, func(_ string, item *item) bool {
+		if expired(item.expiresAt, now) {
+			return true
+		}
+		return fn(item.toModel())
+	})
+}
+
+func (om *OrderedMap) Range(from, to string, reverse bool, fn func(models.Item) bool) {
+	now := time.Now().UnixNano()
+	om.keys.Range(from, to, reverse, func(_ string, item *item) bool {
+		if expired(item.expiresAt, now) {
+			return true
+		}
+		return fn(item.toModel())
+	})
+}
+
+func (om *OrderedMap) Expire(key string, at int64) bool {
+	item, ok := om.items[key]
+	if !ok {
+		return false
+	}
+
+	item.expiresAt = at
+	if at != 0 {
+		heap.Push(&om.expiries, expiry{key: key, at: at})
+	}
+	return true
+}
+
+func (om *OrderedMap) Revision() uint64 {
+	return om.revision
+}
+
+func (om *OrderedMap) SetRevision(revision uint64) {
+	if revision > om.revision {
+		om.revision = revision
+	}
+}
+
+func (om *OrderedMap) SetSeq(key string, seq uint64) bool {
+	item, ok := om.items[key]
+	if !ok {
+		return false
+	}
+
+	item.seq = seq
+	return true
+}
+
+// RemoveExpired pops items from the expiry heap until it reaches the one which is not expired yet.
+// Removing the item from the list keeps the order of the rest items.
+func (om *OrderedMap) RemoveExpired(now int64) (keys []string) {
+	for len(om.expiries) > 0 && om.expiries[0].at <= now {
+		e := heap.Pop(&om.expiries).(expiry)
+
+		// The item could be removed or it's expiration changed after it was pushed to the heap.
+		item, ok := om.items[e.key]
+		if !ok || item.expiresAt != e.at {
+			continue
+		}
+
+		om.Remove(e.key)
+		keys = append(keys, e.key)
+	}
+	return
+}
+
 func (om *OrderedMap) Lock() *sync.RWMutex {
 	return om.lock
 }
@@ -120,3 +389,60 @@ func (om *OrderedMap) FileLock() *sync.Mutex {
 func (om *OrderedMap) GetOutputFilePath() string {
 	return om.outputFilPath
 }
+
+// Snapshot writes items as JSON lines, from the head to the tail of the list,
+// so the insertion order is preserved after Restore.
+func (om *OrderedMap) Snapshot(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	for item := om.head; item != nil; item = item.next {
+		if err := enc.Encode(snapshotItem{Item: item.toModel(), Position: item.position}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Restore clears the map and adds items written by Snapshot in the same order.
+// Items keep their positions, so their cursors stay valid.
+func (om *OrderedMap) Restore(r io.Reader) error {
+	om.reset()
+
+	dec := json.NewDecoder(r)
+	for {
+		var i snapshotItem
+		err := dec.Decode(&i)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if i.Position != 0 {
+			om.position = i.Position - 1
+		}
+		if !om.Add(i.Key, string(i.Value), 0) {
+			continue
+		}
+		om.Expire(i.Key, i.ExpiresAt)
+		if i.Revision != 0 {
+			om.tail.revision = i.Revision
+		}
+		om.SetRevision(om.tail.revision)
+		om.tail.seq = i.Seq
+	}
+}
+
+// reset removes all items.
+func (om *OrderedMap) reset() {
+	om.head = nil
+	om.tail = nil
+	om.size = 0
+	om.items = make(map[string]*item)
+	om.keys = newSkiplist[*item]()
+	om.position = 0
+	om.revision = 0
+	if om.positions != nil {
+		om.positions = newSkiplist[*item]()
+	}
+	om.expiries = nil
+}
@@ -0,0 +1,308 @@
+package store
+
+import (
+	"encoding/json"
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/LukaGiorgadze/bloXroute/internal/models"
+)
+
+// IShardedStore is implemented by stores which keep their items in independently locked shards.
+// An operation on a single item holds the store lock for reading and the lock of the item's shard (KeyLock),
+// so it runs in parallel with operations on items of other shards. Operations on many items read-lock
+// the shards by themselves, while the store lock held exclusively excludes all other operations.
+type IShardedStore interface {
+	IStore
+	// KeyLock returns the lock of the shard where the item with the key is kept.
+	KeyLock(string) *sync.RWMutex
+}
+
+// ShardedMap hashes keys to a fixed number of shards, every shard is an OrderedMap with it's own lock.
+// Positions of the items are assigned from the global counter, so the lists of the shards
+// are merged by them to iterate the whole store in the insertion order.
+// Revisions are given by every shard from it's own counter, in the order the shard is changed under it's lock.
+type ShardedMap struct {
+	shards []*OrderedMap
+	// The last assigned position of all shards.
+	position atomic.Uint64
+	// Lock method returns the sync.RWMutex used to lock access to the whole sharded map.
+	lock *sync.RWMutex
+	// Lock method returns the sync.Mutex used to lock access to the output file data.
+	fileLock *sync.Mutex
+	// Output file path where data will be saved.
+	outputFilPath string
+}
+
+func NewShardedMap(shards int, mu *sync.RWMutex, mu2 *sync.Mutex, outputFilPath string) *ShardedMap {
+	if shards < 1 {
+		shards = 1
+	}
+
+	sm := &ShardedMap{
+		shards:        make([]*OrderedMap, shards),
+		lock:          mu,
+		fileLock:      mu2,
+		outputFilPath: outputFilPath,
+	}
+	for i := range sm.shards {
+		shard := NewOrderedMap(&sync.RWMutex{}, nil, "")
+		shard.nextPosition = func() uint64 { return sm.position.Add(1) }
+		shard.positions = newSkiplist[*item]()
+		sm.shards[i] = shard
+	}
+	return sm
+}
+
+// shard returns the shard of the key by it's FNV-1a hash.
+func (sm *ShardedMap) shard(key string) *OrderedMap {
+	h := uint32(2166136261)
+	for i := 0; i < len(key); i++ {
+		h ^= uint32(key[i])
+		h *= 16777619
+	}
+	return sm.shards[h%uint32(len(sm.shards))]
+}
+
+func (sm *ShardedMap) KeyLock(key string) *sync.RWMutex {
+	return sm.shard(key).lock
+}
+
+// rlockShards read-locks all shards, so items of many shards are read consistently.
+func (sm *ShardedMap) rlockShards() func() {
+	for _, shard := range sm.shards {
+		shard.lock.RLock()
+	}
+	return func() {
+		for _, shard := range sm.shards {
+			shard.lock.RUnlock()
+		}
+	}
+}
+
+func (sm *ShardedMap) Add(key string, value string, now int64) bool {
+	return sm.shard(key).Add(key, value, now)
+}
+
+func (sm *ShardedMap) Remove(key string) bool {
+	return sm.shard(key).Remove(key)
+}
+
+func (sm *ShardedMap) Get(key string) (string, bool) {
+	return sm.shard(key).Get(key)
+}
+
+func (sm *ShardedMap) GetItem(key string) (models.Item, bool) {
+	return sm.shard(key).GetItem(key)
+}
+
+func (sm *ShardedMap) GetItemAt(key string, now int64) (models.Item, bool) {
+	return sm.shard(key).GetItemAt(key, now)
+}
+
+func (sm *ShardedMap) Update(key string, value string, now int64) bool {
+	return sm.shard(key).Update(key, value, now)
+}
+
+func (sm *ShardedMap) MoveToBack(key string) bool {
+	return sm.shard(key).MoveToBack(key)
+}
+
+func (sm *ShardedMap) GetAll() []models.Item {
+	var result []models.Item
+	_ = sm.Iterate("", false, func(item models.Item, _ string) bool {
+		result = append(result, item)
+		return true
+	})
+	return result
+}
+
+// Iterate merges the lists of the shards by the positions of their items.
+// The shards are read-locked for the whole iteration.
+func (sm *ShardedMap) Iterate(cursor string, reverse bool, fn func(models.Item, string) bool) error {
+	defer sm.rlockShards()()
+
+	// The next item of every shard.
+	heads := make([]*item, len(sm.shards))
+	for i, shard := range sm.shards {
+		start, err := shard.seek(cursor, reverse)
+		if err != nil {
+			return err
+		}
+		heads[i] = start
+	}
+
+	now := time.Now().UnixNano()
+	for {
+		// The item with the lowest position goes first (the highest in the reverse order).
+		next := -1
+		for i, head := range heads {
+			if head != nil && (next < 0 || (head.position < heads[next].position) != reverse) {
+				next = i
+			}
+		}
+		if next < 0 {
+			return nil
+		}
+
+		item := heads[next]
+		if reverse {
+			heads[next] = item.prev
+		} else {
+			heads[next] = item.next
+		}
+
+		if !expired(item.expiresAt, now) && !fn(item.toModel(), formatCursor(item.position, item.key)) {
+			return nil
+		}
+	}
+}
+
+// Cursor read-locks the shard, since it's used by list reads which share the store lock with writers.
+func (sm *ShardedMap) Cursor(key string) (string, bool) {
+	shard := sm.shard(key)
+	shard.lock.RLock()
+	defer shard.lock.RUnlock()
+	return shard.Cursor(key)
+}
+
+func (sm *ShardedMap) Scan(prefix, after string, reverse bool, fn func(models.Item) bool) {
+	from, to, ok := prefixRange(prefix, after, reverse)
+	if !ok {
+		return
+	}
+	sm.Range(from, to, reverse, fn)
+}
+
+// Range merges the key indexes of the shards.
+// The shards are read-locked for the whole iteration.
+func (sm *ShardedMap) Range(from, to string, reverse bool, fn func(models.Item) bool) {
+	defer sm.rlockShards()()
+
+	// The next node of every shard.
+	heads := make([]*skipnode[*item], len(sm.shards))
+	for i, shard := range sm.shards {
+		heads[i] = shard.keys.start(from, to, reverse)
+	}
+
+	now := time.Now().UnixNano()
+	for {
+		// The lowest key goes first (the highest in the reverse order).
+		next := -1
+		for i, head := range heads {
+			if inRange(head, from, to) && (next < 0 || (head.key < heads[next].key) != reverse) {
+				next = i
+			}
+		}
+		if next < 0 {
+			return
+		}
+
+		node := heads[next]
+		heads[next] = node.step(reverse)
+
+		if !expired(node.value.expiresAt, now) && !fn(node.value.toModel()) {
+			return
+		}
+	}
+}
+
+func (sm *ShardedMap) Expire(key string, at int64) bool {
+	return sm.shard(key).Expire(key, at)
+}
+
+// Revision returns the greatest revision given by the shards,
+// it should be called while the sharded map is locked exclusively.
+func (sm *ShardedMap) Revision() (revision uint64) {
+	for _, shard := range sm.shards {
+		if r := shard.Revision(); r > revision {
+			revision = r
+		}
+	}
+	return
+}
+
+// SetRevision should be called while the sharded map is locked exclusively.
+func (sm *ShardedMap) SetRevision(revision uint64) {
+	for _, shard := range sm.shards {
+		shard.SetRevision(revision)
+	}
+}
+
+func (sm *ShardedMap) SetSeq(key string, seq uint64) bool {
+	return sm.shard(key).SetSeq(key, seq)
+}
+
+// RemoveExpired should be called while the sharded map is locked exclusively.
+func (sm *ShardedMap) RemoveExpired(now int64) (keys []string) {
+	for _, shard := range sm.shards {
+		keys = append(keys, shard.RemoveExpired(now)...)
+	}
+	return
+}
+
+func (sm *ShardedMap) Lock() *sync.RWMutex {
+	return sm.lock
+}
+
+func (sm *ShardedMap) FileLock() *sync.Mutex {
+	return sm.fileLock
+}
+
+func (sm *ShardedMap) GetOutputFilePath() string {
+	return sm.outputFilPath
+}
+
+// Snapshot writes items as JSON lines in the insertion order of the whole store,
+// so the snapshot is the same as the one of the OrderedMap.
+func (sm *ShardedMap) Snapshot(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	var err error
+	iterErr := sm.Iterate("", false, func(item models.Item, cursor string) bool {
+		position, _, _ := parseCursor(cursor)
+		err = enc.Encode(snapshotItem{Item: item, Position: position})
+		return err == nil
+	})
+	if err != nil {
+		return err
+	}
+	return iterErr
+}
+
+// Restore clears the shards and adds items written by Snapshot in the same order.
+// Items keep their positions, so their cursors stay valid.
+// It should be called while the sharded map is locked exclusively.
+func (sm *ShardedMap) Restore(r io.Reader) error {
+	for _, shard := range sm.shards {
+		shard.reset()
+	}
+	sm.position.Store(0)
+
+	dec := json.NewDecoder(r)
+	for {
+		var i snapshotItem
+		err := dec.Decode(&i)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if i.Position != 0 {
+			sm.position.Store(i.Position - 1)
+		}
+
+		shard := sm.shard(i.Key)
+		if !shard.Add(i.Key, string(i.Value), 0) {
+			continue
+		}
+		shard.Expire(i.Key, i.ExpiresAt)
+		if i.Revision != 0 {
+			shard.tail.revision = i.Revision
+		}
+		shard.SetRevision(shard.tail.revision)
+		shard.tail.seq = i.Seq
+	}
+}
@@ -1,22 +1,38 @@
 package store
 
 import (
-	"fmt"
+	"encoding/json"
+	"io"
 	_ "net/http/pprof"
+	"sort"
+	"strings"
 	"sync"
+	"time"
+
+	"github.com/LukaGiorgadze/bloXroute/internal/models"
 )
 
 type item2 struct {
-	key  string
-	val  string
-	next *item2
-	prev *item2
+	key       string
+	val       string
+	expiresAt int64
+	revision  uint64
+	seq       uint64
+	position  uint64
+	next      *item2
+	prev      *item2
+}
+
+func (i *item2) toModel() models.Item {
+	return models.Item{Key: i.key, Value: models.Value(i.val), ExpiresAt: i.expiresAt, Revision: i.revision, Seq: i.seq}
 }
 
 type LinkedList struct {
 	head          *item2
 	tile          *item2
 	size          int
+	position      uint64
+	revision      uint64
 	lock          *sync.RWMutex
 	fileLock      *sync.Mutex
 	outputFilPath string
@@ -30,11 +46,27 @@ func NewLinkedList(mu *sync.RWMutex, mu2 *sync.Mutex, outputFilPath string) *Lin
 	}
 }
 
-func (ll *LinkedList) Add(key, val string) bool {
+func (ll *LinkedList) Add(key, val string, now int64) bool {
+
+	for current := ll.head; current != nil; current = current.next {
+		if current.key != key {
+			continue
+		}
+		// Expired item which wasn't removed by the reaper yet is replaced by the new one.
+		if !expired(current.expiresAt, now) {
+			return false
+		}
+		ll.Remove(key)
+		break
+	}
 
+	ll.position++
+	ll.revision++
 	new := &item2{
-		key: key,
-		val: val,
+		key:      key,
+		val:      val,
+		revision: ll.revision,
+		position: ll.position,
 	}
 
 	if ll.head == nil {
@@ -57,6 +89,9 @@ func (ll *LinkedList) Get(key string) (string, bool) {
 
 	for ; current != nil; current = current.next {
 		if current.key == key {
+			if expired(current.expiresAt, time.Now().UnixNano()) {
+				return "", false
+			}
 			return current.val, true
 		}
 	}
@@ -64,6 +99,75 @@ func (ll *LinkedList) Get(key string) (string, bool) {
 	return "", false
 }
 
+func (ll *LinkedList) GetItem(key string) (models.Item, bool) {
+	return ll.GetItemAt(key, time.Now().UnixNano())
+}
+
+func (ll *LinkedList) GetItemAt(key string, now int64) (models.Item, bool) {
+
+	current := ll.head
+
+	for ; current != nil; current = current.next {
+		if current.key == key {
+			if expired(current.expiresAt, now) {
+				return models.Item{}, false
+			}
+			return current.toModel(), true
+		}
+	}
+
+	return models.Item{}, false
+}
+
+func (ll *LinkedList) Update(key, val string, now int64) bool {
+
+	current := ll.head
+
+	for ; current != nil; current = current.next {
+		if current.key == key {
+			if expired(current.expiresAt, now) {
+				return false
+			}
+			ll.revision++
+			current.val = val
+			current.revision = ll.revision
+			return true
+		}
+	}
+
+	return false
+}
+
+func (ll *LinkedList) MoveToBack(key string) bool {
+
+	current := ll.head
+
+	for ; current != nil; current = current.next {
+		if current.key == key {
+			if current == ll.tile {
+				return true
+			}
+
+			if current.prev != nil {
+				current.prev.next = current.next
+			} else {
+				ll.head = current.next
+			}
+			current.next.prev = current.prev
+
+			ll.position++
+			current.position = ll.position
+			current.prev = ll.tile
+			current.next = nil
+			ll.tile.next = current
+			ll.tile = current
+			return true
+		}
+	}
+
+	return false
+}
+
 func (ll *LinkedList) Remove(key string) bool {
 
 	current := ll.head
@@ -91,18 +195,172 @@ func (ll *LinkedList) Remove(key string) bool {
 
 }
 
-func (ll *LinkedList) GetAll() []string {
+func (ll *LinkedList) GetAll() []models.Item {
 
 	current := ll.head
-	result := make([]string, ll.size)
+	result := make([]models.Item, 0, ll.size)
+	now := time.Now().UnixNano()
 
 	for ; current != nil; current = current.next {
-		result = append(result, fmt.Sprintf("%s: %s \n", current.key, current.val))
+		if expired(current.expiresAt, now) {
+			continue
+		}
+		result = append(result, current.toModel())
 	}
 
 	return result
 }
 
+func (ll *LinkedList) Iterate(cursor string, reverse bool, fn func(models.Item, string) bool) error {
+
+	current := ll.head
+	if reverse {
+		current = ll.tile
+	}
+
+	if cursor != "" {
+		position, _, err := parseCursor(cursor)
+		if err != nil {
+			return err
+		}
+		for ; current != nil; current = ll.step(current, reverse) {
+			if (!reverse && current.position > position) || (reverse && current.position < position) {
+				break
+			}
+		}
+	}
+
+	now := time.Now().UnixNano()
+	for ; current != nil; current = ll.step(current, reverse) {
+		if !expired(current.expiresAt, now) && !fn(current.toModel(), formatCursor(current.position, current.key)) {
+			return nil
+		}
+	}
+
+	return nil
+}
+
+func (ll *LinkedList) step(current *item2, reverse bool) *item2 {
+	if reverse {
+		return current.prev
+	}
+	return current.next
+}
+
+// Scan collects and sorts matching items first, since the list has no key index.
+func (ll *LinkedList) Scan(prefix, after string, reverse bool, fn func(models.Item) bool) {
+
+	ll.sorted(reverse, func(key string) bool {
+		if !strings.HasPrefix(key, prefix) {
+			return false
+		}
+		return after == "" || (!reverse && key > after) || (reverse && key < after)
+	}, fn)
+}
+
+// Range collects and sorts matching items first, since the list has no key index.
+func (ll *LinkedList) Range(from, to string, reverse bool, fn func(models.Item) bool) {
+
+	ll.sorted(reverse, func(key string) bool {
+		return key >= from && (to == "" || key < to)
+	}, fn)
+}
+
+// sorted calls fn for not expired items with matching keys, in the lexical order of keys.
+func (ll *LinkedList) sorted(reverse bool, match func(string) bool, fn func(models.Item) bool) {
+
+	var items []models.Item
+	now := time.Now().UnixNano()
+
+	for current := ll.head; current != nil; current = current.next {
+		if !match(current.key) || expired(current.expiresAt, now) {
+			continue
+		}
+		items = append(items, current.toModel())
+	}
+
+	sort.Slice(items, func(i, j int) bool {
+		if reverse {
+			return items[i].Key > items[j].Key
+		}
+		return items[i].Key < items[j].Key
+	})
+
+	for _, item := range items {
+		if !fn(item) {
+			return
+		}
+	}
+}
+
+func (ll *LinkedList) Cursor(key string) (string, bool) {
+
+	current := ll.head
+
+	for ; current != nil; current = current.next {
+		if current.key == key {
+			return formatCursor(current.position, current.key), true
+		}
+	}
+
+	return "", false
+}
+
+func (ll *LinkedList) Expire(key string, at int64) bool {
+
+	current := ll.head
+
+	for ; current != nil; current = current.next {
+		if current.key == key {
+			current.expiresAt = at
+			return true
+		}
+	}
+
+	return false
+}
+
+func (ll *LinkedList) Revision() uint64 {
+	return ll.revision
+}
+
+func (ll *LinkedList) SetRevision(revision uint64) {
+	if revision > ll.revision {
+		ll.revision = revision
+	}
+}
+
+func (ll *LinkedList) SetSeq(key string, seq uint64) bool {
+
+	current := ll.head
+
+	for ; current != nil; current = current.next {
+		if current.key == key {
+			current.seq = seq
+			return true
+		}
+	}
+
+	return false
+}
+
+func (ll *LinkedList) RemoveExpired(now int64) (keys []string) {
+
+	current := ll.head
+
+	for ; current != nil; current = current.next {
+		if expired(current.expiresAt, now) {
+			keys = append(keys, current.key)
+		}
+	}
+
+	for _, key := range keys {
+		ll.Remove(key)
+	}
+
+	return
+}
+
 func (ll *LinkedList) Lock() *sync.RWMutex {
 	return ll.lock
 }
@@ -114,3 +372,47 @@ func (ll *LinkedList) FileLock() *sync.Mutex {
 func (ll *LinkedList) GetOutputFilePath() string {
 	return ll.outputFilPath
 }
+
+func (ll *LinkedList) Snapshot(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	for current := ll.head; current != nil; current = current.next {
+		if err := enc.Encode(snapshotItem{Item: current.toModel(), Position: current.position}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Restore clears the list and adds items written by Snapshot in the same order.
+// Items keep their positions, so their cursors stay valid.
+func (ll *LinkedList) Restore(r io.Reader) error {
+	ll.head = nil
+	ll.tile = nil
+	ll.size = 0
+	ll.position = 0
+	ll.revision = 0
+
+	dec := json.NewDecoder(r)
+	for {
+		var i snapshotItem
+		err := dec.Decode(&i)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if i.Position != 0 {
+			ll.position = i.Position - 1
+		}
+		if !ll.Add(i.Key, string(i.Value), 0) {
+			continue
+		}
+		ll.tile.expiresAt = i.ExpiresAt
+		if i.Revision != 0 {
+			ll.tile.revision = i.Revision
+		}
+		ll.SetRevision(ll.tile.revision)
+		ll.tile.seq = i.Seq
+	}
+}
@@ -0,0 +1,39 @@
+package store
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestLinkedListAddExistingKey(t *testing.T) {
+	expiresAt := time.Now().Add(time.Minute).UnixNano()
+
+	tests := []struct {
+		name  string
+		now   int64
+		added bool
+		value string
+	}{
+		{name: "live item is kept", now: expiresAt - 1, added: false, value: "a"},
+		{name: "expired item is replaced", now: expiresAt, added: true, value: "b"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ll := NewLinkedList(&sync.RWMutex{}, &sync.Mutex{}, "")
+			ll.Add("k", "a", 0)
+			ll.Expire("k", expiresAt)
+
+			if added := ll.Add("k", "b", tt.now); added != tt.added {
+				t.Fatalf("Add() = %v, want %v", added, tt.added)
+			}
+			if n := len(ll.GetAll()); n != 1 {
+				t.Fatalf("list has %d items, want 1", n)
+			}
+			if item, _ := ll.GetItemAt("k", tt.now); string(item.Value) != tt.value {
+				t.Fatalf("GetItemAt() = %q, want %q", string(item.Value), tt.value)
+			}
+		})
+	}
+}
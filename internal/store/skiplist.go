@@ -0,0 +1,215 @@
+package store
+
+import "math/rand"
+
+const (
+	skiplistMaxLevel = 32
+	// Every node has the next level with the probability of 1/skiplistP.
+	skiplistP = 4
+)
+
+type skipnode[V any] struct {
+	key   string
+	value V
+	// Previous node on the lowest level, nil for the first node.
+	prev *skipnode[V]
+	next []*skipnode[V]
+}
+
+// skiplist keeps values sorted by their keys lexically.
+// Search, insertion and removal take O(log n) on average and the nodes
+// are linked in both directions on the lowest level for ordered iteration.
+type skiplist[V any] struct {
+	// head is the sentinel node which has all levels.
+	head  skipnode[V]
+	tail  *skipnode[V]
+	level int
+	size  int
+}
+
+func newSkiplist[V any]() *skiplist[V] {
+	return &skiplist[V]{
+		head:  skipnode[V]{next: make([]*skipnode[V], skiplistMaxLevel)},
+		level: 1,
+	}
+}
+
+func randomLevel() int {
+	level := 1
+	for level < skiplistMaxLevel && rand.Intn(skiplistP) == 0 {
+		level++
+	}
+	return level
+}
+
+// find returns the first node with the key greater or equal to the given one.
+// If update is not nil, it's filled with the last node before the key on every level.
+func (s *skiplist[V]) find(key string, update []*skipnode[V]) *skipnode[V] {
+	x := &s.head
+	for i := s.level - 1; i >= 0; i-- {
+		for x.next[i] != nil && x.next[i].key < key {
+			x = x.next[i]
+		}
+		if update != nil {
+			update[i] = x
+		}
+	}
+	return x.next[0]
+}
+
+// lower returns the last node with the key less than the given one.
+func (s *skiplist[V]) lower(key string) *skipnode[V] {
+	x := &s.head
+	for i := s.level - 1; i >= 0; i-- {
+		for x.next[i] != nil && x.next[i].key < key {
+			x = x.next[i]
+		}
+	}
+	if x == &s.head {
+		return nil
+	}
+	return x
+}
+
+func (s *skiplist[V]) Get(key string) (value V, ok bool) {
+	n := s.find(key, nil)
+	if n == nil || n.key != key {
+		return value, false
+	}
+	return n.value, true
+}
+
+// Set inserts the key or replaces the value of the existing one.
+func (s *skiplist[V]) Set(key string, value V) {
+	var update [skiplistMaxLevel]*skipnode[V]
+	n := s.find(key, update[:])
+	if n != nil && n.key == key {
+		n.value = value
+		return
+	}
+
+	level := randomLevel()
+	if level > s.level {
+		for i := s.level; i < level; i++ {
+			update[i] = &s.head
+		}
+		s.level = level
+	}
+
+	n = &skipnode[V]{key: key, value: value, next: make([]*skipnode[V], level)}
+	for i := 0; i < level; i++ {
+		n.next[i] = update[i].next[i]
+		update[i].next[i] = n
+	}
+	if update[0] != &s.head {
+		n.prev = update[0]
+	}
+	if n.next[0] != nil {
+		n.next[0].prev = n
+	} else {
+		s.tail = n
+	}
+	s.size++
+}
+
+func (s *skiplist[V]) Delete(key string) bool {
+	var update [skiplistMaxLevel]*skipnode[V]
+	n := s.find(key, update[:])
+	if n == nil || n.key != key {
+		return false
+	}
+
+	for i := range n.next {
+		update[i].next[i] = n.next[i]
+	}
+	if n.next[0] != nil {
+		n.next[0].prev = n.prev
+	} else {
+		s.tail = n.prev
+	}
+	for s.level > 1 && s.head.next[s.level-1] == nil {
+		s.level--
+	}
+	s.size--
+	return true
+}
+
+func (s *skiplist[V]) Len() int {
+	return s.size
+}
+
+// Range calls the function for nodes with keys in the range [from, to), in the lexical order of keys
+// or in the reverse order. Empty `to` means there is no upper bound.
+// Iteration stops when the function returns false.
+func (s *skiplist[V]) Range(from, to string, reverse bool, fn func(key string, value V) bool) {
+	for n := s.start(from, to, reverse); inRange(n, from, to); n = n.step(reverse) {
+		if !fn(n.key, n.value) {
+			return
+		}
+	}
+}
+
+// Scan calls the function for nodes with keys starting with the prefix, in the lexical order of keys.
+// It starts after the given key (empty key starts from the first matching one), or before it in the reverse order.
+// Iteration stops when the function returns false.
+func (s *skiplist[V]) Scan(prefix, after string, reverse bool, fn func(key string, value V) bool) {
+	from, to, ok := prefixRange(prefix, after, reverse)
+	if !ok {
+		return
+	}
+	s.Range(from, to, reverse, fn)
+}
+
+// start returns the first node of the range [from, to) in the direction of the iteration.
+// The node may be out of the range, if the range is empty.
+func (s *skiplist[V]) start(from, to string, reverse bool) *skipnode[V] {
+	if !reverse {
+		return s.find(from, nil)
+	}
+	if to == "" {
+		return s.tail
+	}
+	return s.lower(to)
+}
+
+// step returns the next node in the direction of the iteration.
+func (n *skipnode[V]) step(reverse bool) *skipnode[V] {
+	if reverse {
+		return n.prev
+	}
+	return n.next[0]
+}
+
+// inRange reports whether the node exists and it's key is in the range [from, to).
+func inRange[V any](n *skipnode[V], from, to string) bool {
+	return n != nil && n.key >= from && (to == "" || n.key < to)
+}
+
+// prefixRange returns the range of keys starting with the prefix, which are after the given key
+// (or before it in the reverse order). It's not ok if the range is empty.
+func prefixRange(prefix, after string, reverse bool) (from, to string, ok bool) {
+	from, to = prefix, prefixEnd(prefix)
+	if after != "" {
+		if !reverse && after >= from {
+			// The first key greater than `after`.
+			from = after + "\x00"
+		}
+		if reverse && (to == "" || after < to) {
+			to = after
+		}
+	}
+	return from, to, to == "" || from < to
+}
+
+// prefixEnd returns the smallest key which is greater than all keys with the prefix,
+// or empty string if there is no such key (e.g. the prefix is empty).
+func prefixEnd(prefix string) string {
+	b := []byte(prefix)
+	for i := len(b) - 1; i >= 0; i-- {
+		if b[i] < 0xff {
+			b[i]++
+			return string(b[:i+1])
+		}
+	}
+	return ""
+}
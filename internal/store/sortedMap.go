@@ -0,0 +1,273 @@
+package store
+
+import (
+	"container/heap"
+	"encoding/json"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/LukaGiorgadze/bloXroute/internal/models"
+)
+
+type sortedItem struct {
+	key   string
+	value string
+	// Expiration time in Unix nanoseconds, 0 if the item never expires.
+	expiresAt int64
+	revision  uint64
+	seq       uint64
+}
+
+func (i *sortedItem) toModel() models.Item {
+	return models.Item{Key: i.key, Value: models.Value(i.value), ExpiresAt: i.expiresAt, Revision: i.revision, Seq: i.seq}
+}
+
+// SortedMap keeps items sorted by their keys lexically instead of the insertion order.
+// Items are kept in a skiplist, so range and prefix reads find the first item in O(log n)
+// and walk the following ones in order. The map is used for O(1) reads of a single item.
+type SortedMap struct {
+	items map[string]*sortedItem
+	keys  *skiplist[*sortedItem]
+	// Items with expiration time ordered by it.
+	expiries expiryHeap
+	// The last revision given to the items.
+	revision uint64
+	// Lock method returns the sync.RWMutex used to lock access to the sorted map data structure.
+	lock *sync.RWMutex
+	// Lock method returns the sync.Mutex used to lock access to the output file data.
+	fileLock *sync.Mutex
+	// Output file path where data will be saved.
+	outputFilPath string
+}
+
+func NewSortedMap(mu *sync.RWMutex, mu2 *sync.Mutex, outputFilPath string) *SortedMap {
+	return &SortedMap{
+		lock:          mu,
+		fileLock:      mu2,
+		items:         make(map[string]*sortedItem),
+		keys:          newSkiplist[*sortedItem](),
+		outputFilPath: outputFilPath,
+	}
+}
+
+func (sm *SortedMap) Add(key string, value string, now int64) bool {
+	if existing, exists := sm.items[key]; exists {
+		// Expired item which wasn't removed by the reaper yet is replaced by the new one.
+		if !expired(existing.expiresAt, now) {
+			return false
+		}
+		sm.Remove(key)
+	}
+
+	sm.revision++
+	item := &sortedItem{key: key, value: value, revision: sm.revision}
+	sm.items[key] = item
+	sm.keys.Set(key, item)
+	return true
+}
+
+func (sm *SortedMap) Remove(key string) bool {
+	if _, exists := sm.items[key]; !exists {
+		return false
+	}
+
+	delete(sm.items, key)
+	sm.keys.Delete(key)
+	return true
+}
+
+// Get returns the value of the item. Expired items are treated as removed, even if the reaper didn't remove them yet.
+func (sm *SortedMap) Get(key string) (string, bool) {
+	item, ok := sm.items[key]
+	if !ok || expired(item.expiresAt, time.Now().UnixNano()) {
+		return "", false
+	}
+
+	return item.value, true
+}
+
+func (sm *SortedMap) GetItem(key string) (models.Item, bool) {
+	return sm.GetItemAt(key, time.Now().UnixNano())
+}
+
+func (sm *SortedMap) GetItemAt(key string, now int64) (models.Item, bool) {
+	item, ok := sm.items[key]
+	if !ok || expired(item.expiresAt, now) {
+		return models.Item{}, false
+	}
+
+	return item.toModel(), true
+}
+
+func (sm *SortedMap) Update(key string, value string, now int64) bool {
+	item, ok := sm.items[key]
+	if !ok || expired(item.expiresAt, now) {
+		return false
+	}
+
+	sm.revision++
+	item.value = value
+	item.revision = sm.revision
+	return true
+}
+
+// MoveToBack doesn't change anything, the position of the item is defined by it's key.
+func (sm *SortedMap) MoveToBack(key string) bool {
+	_, ok := sm.items[key]
+	return ok
+}
+
+func (sm *SortedMap) GetAll() []models.Item {
+	result := make([]models.Item, 0, len(sm.items))
+	sm.Range("", "", false, func(item models.Item) bool {
+		result = append(result, item)
+		return true
+	})
+	return result
+}
+
+// Iterate uses keys as cursors, so the iteration continues after the key even if the item was removed.
+func (sm *SortedMap) Iterate(cursor string, reverse bool, fn func(models.Item, string) bool) error {
+	from, to := "", ""
+	if cursor != "" {
+		if reverse {
+			to = cursor
+		} else {
+			// The first key greater than the cursor.
+			from = cursor + "\x00"
+		}
+	}
+
+	sm.Range(from, to, reverse, func(item models.Item) bool {
+		return fn(item, item.Key)
+	})
+	return nil
+}
+
+func (sm *SortedMap) Cursor(key string) (string, bool) {
+	_, ok := sm.items[key]
+	return key, ok
+}
+
+func (sm *SortedMap) Scan(prefix, after string, reverse bool, fn func(models.Item) bool) {
+	now := time.Now().UnixNano()
+	sm.keys.Scan(prefix, after, reverse, func(_ string, item *sortedItem) bool {
+		if expired(item.expiresAt, now) {
+			return true
+		}
+		return fn(item.toModel())
+	})
+}
+
+func (sm *SortedMap) Range(from, to string, reverse bool, fn func(models.Item) bool) {
+	now := time.Now().UnixNano()
+	sm.keys.Range(from, to, reverse, func(_ string, item *sortedItem) bool {
+		if expired(item.expiresAt, now) {
+			return true
+		}
+		return fn(item.toModel())
+	})
+}
+
+func (sm *SortedMap) Expire(key string, at int64) bool {
+	item, ok := sm.items[key]
+	if !ok {
+		return false
+	}
+
+	item.expiresAt = at
+	if at != 0 {
+		heap.Push(&sm.expiries, expiry{key: key, at: at})
+	}
+	return true
+}
+
+func (sm *SortedMap) Revision() uint64 {
+	return sm.revision
+}
+
+func (sm *SortedMap) SetRevision(revision uint64) {
+	if revision > sm.revision {
+		sm.revision = revision
+	}
+}
+
+func (sm *SortedMap) SetSeq(key string, seq uint64) bool {
+	item, ok := sm.items[key]
+	if !ok {
+		return false
+	}
+
+	item.seq = seq
+	return true
+}
+
+// RemoveExpired pops items from the expiry heap until it reaches the one which is not expired yet.
+func (sm *SortedMap) RemoveExpired(now int64) (keys []string) {
+	for len(sm.expiries) > 0 && sm.expiries[0].at <= now {
+		e := heap.Pop(&sm.expiries).(expiry)
+
+		// The item could be removed or it's expiration changed after it was pushed to the heap.
+		item, ok := sm.items[e.key]
+		if !ok || item.expiresAt != e.at {
+			continue
+		}
+
+		sm.Remove(e.key)
+		keys = append(keys, e.key)
+	}
+	return
+}
+
+func (sm *SortedMap) Lock() *sync.RWMutex {
+	return sm.lock
+}
+
+func (sm *SortedMap) FileLock() *sync.Mutex {
+	return sm.fileLock
+}
+
+func (sm *SortedMap) GetOutputFilePath() string {
+	return sm.outputFilPath
+}
+
+// Snapshot writes items as JSON lines in the order of keys.
+func (sm *SortedMap) Snapshot(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	var err error
+	sm.keys.Range("", "", false, func(_ string, item *sortedItem) bool {
+		err = enc.Encode(item.toModel())
+		return err == nil
+	})
+	return err
+}
+
+// Restore clears the map and adds items written by Snapshot.
+func (sm *SortedMap) Restore(r io.Reader) error {
+	sm.items = make(map[string]*sortedItem)
+	sm.keys = newSkiplist[*sortedItem]()
+	sm.expiries = nil
+	sm.revision = 0
+
+	dec := json.NewDecoder(r)
+	for {
+		var i models.Item
+		err := dec.Decode(&i)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if !sm.Add(i.Key, string(i.Value), 0) {
+			continue
+		}
+		sm.Expire(i.Key, i.ExpiresAt)
+		if i.Revision != 0 {
+			sm.items[i.Key].revision = i.Revision
+		}
+		sm.SetRevision(sm.items[i.Key].revision)
+		sm.items[i.Key].seq = i.Seq
+	}
+}
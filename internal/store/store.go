@@ -1,6 +1,12 @@
 package store
 
-import "sync"
+import (
+	"errors"
+	"io"
+	"sync"
+
+	"github.com/LukaGiorgadze/bloXroute/internal/models"
+)
 
 // The IStore interface defines a set of methods that any
 // memory storage/data structure implementation should implement.
@@ -8,11 +14,102 @@ import "sync"
 // By using this interface, we can switch between different data structures
 // without changing the rest of the code that uses it, or just adding new ones.
 type IStore interface {
-	Add(string, string) bool
+	IReader
+	// Add adds the item with the value, values are kept as their JSON encoding (see models.Value).
+	// The item expired at the given time (Unix nanoseconds), which wasn't removed yet, is replaced.
+	Add(string, string, int64) bool
 	Remove(string) bool
-	Get(string) (string, bool)
-	GetAll() []string
+	// Update changes the value of the existing item, which isn't expired at the given time, in place
+	// and increments it's revision.
+	Update(string, string, int64) bool
+	// GetItemAt returns the item unless it's expired at the given time. Mutations check expiration at the time
+	// they were received (see models.Msg), not when they are applied, so they are applied the same way
+	// when they are replayed from the logs or applied by the other replicas.
+	GetItemAt(string, int64) (models.Item, bool)
+	// MoveToBack moves the existing item to the end of the order, as if it was added last.
+	MoveToBack(string) bool
 	Lock() *sync.RWMutex
 	FileLock() *sync.Mutex
 	GetOutputFilePath() string
+	// Expire sets the time (Unix nanoseconds) when the item expires.
+	// Expired items are not returned by Get and GetAll, RemoveExpired removes them from the store.
+	Expire(string, int64) bool
+	// Revision returns the last revision given to the items. Every change of the item gives it the next revision
+	// of the store, so revisions of the key keep growing after it's removed and added again.
+	Revision() uint64
+	// SetRevision sets the last revision given to the items, e.g. when it's restored from the snapshot,
+	// if it's greater than the current one.
+	SetRevision(uint64)
+	// SetSeq sets the sequence of the mutation which changed the item last (see models.Item).
+	SetSeq(string, uint64) bool
+	// RemoveExpired removes items expired at the given time (Unix nanoseconds) and returns their keys.
+	RemoveExpired(int64) []string
+	// Snapshot writes all items to the writer, keeping the order of the store.
+	Snapshot(io.Writer) error
+	// Restore replaces all items with the ones written by Snapshot.
+	Restore(io.Reader) error
+}
+
+// IReader is the read-only part of the store.
+type IReader interface {
+	Get(string) (string, bool)
+	// GetItem returns the item with it's revision and expiration time.
+	GetItem(string) (models.Item, bool)
+	GetAll() []models.Item
+	// Iterate calls the function for items after the cursor, in the order of the store or in the reverse order.
+	// Empty cursor starts from the beginning (or the end in the reverse order).
+	// The cursor of every item is passed to the function, so the iteration can be continued after it later.
+	// Iteration stops when the function returns false.
+	Iterate(cursor string, reverse bool, fn func(item models.Item, cursor string) bool) error
+	// Cursor returns the cursor of the item, which can be used to iterate items after it.
+	Cursor(string) (string, bool)
+	// Scan calls the function for items with keys starting with the prefix, in the lexical order of keys.
+	// It starts after the given key (empty key starts from the first matching one), or before it in the reverse order.
+	// Iteration stops when the function returns false.
+	Scan(prefix, after string, reverse bool, fn func(models.Item) bool)
+	// Range calls the function for items with keys in the range [from, to), in the lexical order of keys
+	// or in the reverse order. Empty `to` means there is no upper bound.
+	// Iteration stops when the function returns false.
+	Range(from, to string, reverse bool, fn func(models.Item) bool)
+}
+
+// Types of the store which can be selected in the configuration.
+const (
+	// OrderedMapType keeps items in the insertion order with O(1) access by key.
+	OrderedMapType = "orderedmap"
+	// LinkedListType keeps items in the insertion order, access by key scans the list.
+	LinkedListType = "linkedlist"
+	// SortedMapType keeps items sorted by their keys lexically.
+	SortedMapType = "sorted"
+	// ShardedMapType keeps items in the insertion order in independently locked shards.
+	ShardedMapType = "sharded"
+	// MVCCMapType keeps items in the insertion order in immutable versions, which are read without locking.
+	MVCCMapType = "mvcc"
+)
+
+// Options are the settings of the store, each of them is used only by some types of the store.
+type Options struct {
+	// Shards is the number of shards of the sharded store.
+	Shards int
+	// Versions is the number of the latest versions retained by the versioned store.
+	Versions int
+}
+
+var ErrUnknownStoreType = errors.New("Unknown store type.")
+
+// New creates the store of the given type.
+func New(storeType string, opts Options, mu *sync.RWMutex, mu2 *sync.Mutex, outputFilPath string) (IStore, error) {
+	switch storeType {
+	case OrderedMapType:
+		return NewOrderedMap(mu, mu2, outputFilPath), nil
+	case LinkedListType:
+		return NewLinkedList(mu, mu2, outputFilPath), nil
+	case SortedMapType:
+		return NewSortedMap(mu, mu2, outputFilPath), nil
+	case ShardedMapType:
+		return NewShardedMap(opts.Shards, mu, mu2, outputFilPath), nil
+	case MVCCMapType:
+		return NewMVCCMap(opts.Versions, mu, mu2, outputFilPath), nil
+	}
+	return nil, ErrUnknownStoreType
 }
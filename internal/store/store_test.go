@@ -0,0 +1,132 @@
+package store
+
+import (
+	"bytes"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/LukaGiorgadze/bloXroute/internal/models"
+)
+
+var storeTypes = []string{OrderedMapType, LinkedListType, SortedMapType, ShardedMapType, MVCCMapType}
+
+func newTestStore(t *testing.T, storeType string) IStore {
+	t.Helper()
+	s, err := New(storeType, Options{Shards: 4, Versions: 10}, &sync.RWMutex{}, &sync.Mutex{}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+// Expiration is checked at the time given by the mutation, not the current one.
+func TestStoreExpiryAtMutationTime(t *testing.T) {
+	const expiresAt = 1000
+
+	tests := []struct {
+		name    string
+		now     int64
+		exists  bool
+		added   bool
+		updated bool
+	}{
+		{name: "before expiration", now: expiresAt - 1, exists: true, added: false, updated: true},
+		{name: "at expiration", now: expiresAt, exists: false, added: true, updated: false},
+	}
+
+	for _, storeType := range storeTypes {
+		for _, tt := range tests {
+			t.Run(storeType+"/"+tt.name, func(t *testing.T) {
+				s := newTestStore(t, storeType)
+				s.Add("k", `"a"`, 0)
+				s.Expire("k", expiresAt)
+
+				if _, exists := s.GetItemAt("k", tt.now); exists != tt.exists {
+					t.Fatalf("GetItemAt() exists = %v, want %v", exists, tt.exists)
+				}
+				if updated := s.Update("k", `"b"`, tt.now); updated != tt.updated {
+					t.Fatalf("Update() = %v, want %v", updated, tt.updated)
+				}
+				if added := s.Add("k", `"c"`, tt.now); added != tt.added {
+					t.Fatalf("Add() = %v, want %v", added, tt.added)
+				}
+			})
+		}
+	}
+}
+
+// Revisions of the key keep growing after it's removed and added again, and after the store is restored.
+func TestStoreRevisionAfterReAdd(t *testing.T) {
+	for _, storeType := range storeTypes {
+		t.Run(storeType, func(t *testing.T) {
+			s := newTestStore(t, storeType)
+			s.Add("k", `"a"`, 0)
+			s.Update("k", `"b"`, 0)
+			before, _ := s.GetItem("k")
+
+			s.Remove("k")
+			s.Add("k", `"c"`, 0)
+			after, _ := s.GetItem("k")
+			if after.Revision <= before.Revision {
+				t.Fatalf("revision after re-add = %d, want > %d", after.Revision, before.Revision)
+			}
+
+			var data bytes.Buffer
+			if err := s.Snapshot(&data); err != nil {
+				t.Fatal(err)
+			}
+			revision := s.Revision()
+			s.Remove("k")
+
+			restored := newTestStore(t, storeType)
+			if err := restored.Restore(&data); err != nil {
+				t.Fatal(err)
+			}
+			restored.SetRevision(revision)
+			restored.Remove("k")
+			restored.Add("k", `"d"`, 0)
+			if item, _ := restored.GetItem("k"); item.Revision <= after.Revision {
+				t.Fatalf("revision after restore = %d, want > %d", item.Revision, after.Revision)
+			}
+		})
+	}
+}
+
+// Cursors of the items stay valid after the store is restored from the snapshot.
+func TestStoreCursorAfterRestore(t *testing.T) {
+	for _, storeType := range storeTypes {
+		t.Run(storeType, func(t *testing.T) {
+			s := newTestStore(t, storeType)
+			for _, key := range []string{"a", "b", "c", "d", "e"} {
+				s.Add(key, `"v"`, 0)
+			}
+			s.Remove("a")
+			s.Remove("b")
+			cursor, ok := s.Cursor("c")
+			if !ok {
+				t.Fatal("no cursor of the item")
+			}
+
+			var data bytes.Buffer
+			if err := s.Snapshot(&data); err != nil {
+				t.Fatal(err)
+			}
+			restored := newTestStore(t, storeType)
+			if err := restored.Restore(&data); err != nil {
+				t.Fatal(err)
+			}
+
+			var keys []string
+			if err := restored.Iterate(cursor, false, func(item models.Item, _ string) bool {
+				keys = append(keys, item.Key)
+				return true
+			}); err != nil {
+				t.Fatal(err)
+			}
+			if strings.Join(keys, ",") != "d,e" {
+				t.Fatalf("items after the cursor = %v, want [d e]", keys)
+			}
+		})
+	}
+}
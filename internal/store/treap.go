@@ -0,0 +1,147 @@
+package store
+
+// ordered is the type of keys of the treap.
+type ordered interface {
+	~string | ~uint64
+}
+
+// tnode is the node of the persistent treap. Nodes are never changed after they are created,
+// changes copy the path from the root to the changed node instead. So the root of the treap
+// is an immutable version of it, which can be read without locking while newer versions are created.
+type tnode[K ordered, V any] struct {
+	key   K
+	value V
+	// Nodes with the higher priority are closer to the root, which keeps the treap balanced.
+	prio        uint64
+	left, right *tnode[K, V]
+}
+
+// tput returns the root of the treap with the key set to the value.
+func tput[K ordered, V any](n *tnode[K, V], key K, value V, prio uint64) *tnode[K, V] {
+	if n == nil {
+		return &tnode[K, V]{key: key, value: value, prio: prio}
+	}
+
+	c := *n
+	switch {
+	case key < n.key:
+		c.left = tput(n.left, key, value, prio)
+		// Both nodes are new copies, so they can be rotated in place.
+		if c.left.prio > c.prio {
+			l := c.left
+			c.left = l.right
+			l.right = &c
+			return l
+		}
+	case key > n.key:
+		c.right = tput(n.right, key, value, prio)
+		if c.right.prio > c.prio {
+			r := c.right
+			c.right = r.left
+			r.left = &c
+			return r
+		}
+	default:
+		c.value = value
+	}
+	return &c
+}
+
+// tdelete returns the root of the treap without the key.
+func tdelete[K ordered, V any](n *tnode[K, V], key K) *tnode[K, V] {
+	if n == nil {
+		return nil
+	}
+
+	switch {
+	case key < n.key:
+		c := *n
+		c.left = tdelete(n.left, key)
+		return &c
+	case key > n.key:
+		c := *n
+		c.right = tdelete(n.right, key)
+		return &c
+	}
+	return tmerge(n.left, n.right)
+}
+
+// tmerge joins two treaps, all keys of the first one are less than keys of the second one.
+func tmerge[K ordered, V any](a, b *tnode[K, V]) *tnode[K, V] {
+	if a == nil {
+		return b
+	}
+	if b == nil {
+		return a
+	}
+	if a.prio > b.prio {
+		c := *a
+		c.right = tmerge(a.right, b)
+		return &c
+	}
+	c := *b
+	c.left = tmerge(a, b.left)
+	return &c
+}
+
+func tget[K ordered, V any](n *tnode[K, V], key K) (value V, ok bool) {
+	for n != nil {
+		switch {
+		case key < n.key:
+			n = n.left
+		case key > n.key:
+			n = n.right
+		default:
+			return n.value, true
+		}
+	}
+	return value, false
+}
+
+// tascend calls the function for nodes with keys greater or equal to `from` in the ascending order.
+// It returns false when the function stops the iteration.
+func tascend[K ordered, V any](n *tnode[K, V], from K, fn func(K, V) bool) bool {
+	if n == nil {
+		return true
+	}
+	if n.key >= from {
+		if !tascend(n.left, from, fn) || !fn(n.key, n.value) {
+			return false
+		}
+	}
+	return tascend(n.right, from, fn)
+}
+
+// tdescend calls the function for nodes with keys less than `to` (or for all nodes if it's not bounded)
+// in the descending order. It returns false when the function stops the iteration.
+func tdescend[K ordered, V any](n *tnode[K, V], to K, bounded bool, fn func(K, V) bool) bool {
+	if n == nil {
+		return true
+	}
+	if !bounded || n.key < to {
+		if !tdescend(n.right, to, bounded, fn) || !fn(n.key, n.value) {
+			return false
+		}
+	}
+	return tdescend(n.left, to, bounded, fn)
+}
+
+// stringPriority returns the pseudo-random priority of the key by it's FNV-1a hash.
+func stringPriority(key string) uint64 {
+	h := uint64(14695981039346656037)
+	for i := 0; i < len(key); i++ {
+		h ^= uint64(key[i])
+		h *= 1099511628211
+	}
+	return mix64(h)
+}
+
+// mix64 scrambles bits of the number (the finalizer of SplitMix64), so it can be used as the priority.
+func mix64(x uint64) uint64 {
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	x *= 0x94d049bb133111eb
+	x ^= x >> 31
+	return x
+}
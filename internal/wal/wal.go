@@ -0,0 +1,408 @@
+package wal
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// SyncPolicy defines when appended records are flushed (fsync) to the disk.
+type SyncPolicy string
+
+const (
+	// SyncAlways flushes every record before Append returns. It's the safest and the slowest policy.
+	SyncAlways SyncPolicy = "always"
+	// SyncInterval flushes records periodically, a crash of the machine may lose the last interval.
+	SyncInterval SyncPolicy = "interval"
+	// SyncNever leaves flushing to the operating system.
+	SyncNever SyncPolicy = "never"
+)
+
+const (
+	segmentExt = ".wal"
+	// Record header: index (8 bytes), payload length (4 bytes), checksum (4 bytes).
+	headerSize = 16
+)
+
+var (
+	ErrCorrupted     = errors.New("WAL record is corrupted.")
+	ErrClosed        = errors.New("WAL is closed.")
+	ErrUnknownPolicy = errors.New("Unknown WAL sync policy.")
+	ErrSyncInterval  = errors.New("WAL sync interval must be positive.")
+)
+
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+// WAL is a write-ahead log split into segment files.
+// Every record gets a monotonically increasing index and is stored with a checksum,
+// so partially written records (e.g. after a crash) are detected and dropped.
+// Segments are named after the index of their first record, which allows to remove
+// whole segments once their records are not needed anymore (e.g. after a snapshot).
+type WAL struct {
+	dir         string
+	segmentSize int64
+	policy      SyncPolicy
+
+	mu sync.Mutex
+	// Active segment where records are appended.
+	file     *os.File
+	fileSize int64
+	// First index of the active segment.
+	segStart uint64
+	// Index of the last appended record.
+	index  uint64
+	dirty  bool
+	closed bool
+	done   chan struct{}
+}
+
+// Open opens the log in the directory, creating it if it doesn't exist.
+// The incomplete record at the end of the last segment is truncated.
+// With SyncInterval policy records are flushed every interval in the background.
+func Open(dir string, segmentSize int64, policy SyncPolicy, interval time.Duration) (*WAL, error) {
+	if policy != SyncAlways && policy != SyncInterval && policy != SyncNever {
+		return nil, ErrUnknownPolicy
+	}
+	if policy == SyncInterval && interval <= 0 {
+		return nil, ErrSyncInterval
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	w := &WAL{
+		dir:         dir,
+		segmentSize: segmentSize,
+		policy:      policy,
+		done:        make(chan struct{}),
+	}
+
+	segments, err := w.segments()
+	if err != nil {
+		return nil, err
+	}
+
+	if len(segments) == 0 {
+		if err = w.createSegment(1); err != nil {
+			return nil, err
+		}
+	} else if err = w.openLastSegment(segments[len(segments)-1]); err != nil {
+		return nil, err
+	}
+
+	if policy == SyncInterval {
+		go w.syncWorker(interval)
+	}
+
+	return w, nil
+}
+
+// Append writes the record to the end of the log and returns it's index.
+func (w *WAL) Append(data []byte) (uint64, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.closed {
+		return 0, ErrClosed
+	}
+
+	if w.fileSize >= w.segmentSize && w.index >= w.segStart {
+		if err := w.rotate(); err != nil {
+			return 0, err
+		}
+	}
+
+	index := w.index + 1
+	buf := make([]byte, headerSize+len(data))
+	binary.LittleEndian.PutUint64(buf[0:8], index)
+	binary.LittleEndian.PutUint32(buf[8:12], uint32(len(data)))
+	copy(buf[headerSize:], data)
+	binary.LittleEndian.PutUint32(buf[12:16], checksum(buf[0:12], data))
+
+	if _, err := w.file.Write(buf); err != nil {
+		return 0, err
+	}
+	w.fileSize += int64(len(buf))
+	w.index = index
+	w.dirty = true
+
+	if w.policy == SyncAlways {
+		if err := w.sync(); err != nil {
+			return 0, err
+		}
+	}
+
+	return index, nil
+}
+
+// LastIndex returns the index of the last appended record, 0 if the log is empty.
+func (w *WAL) LastIndex() uint64 {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.index
+}
+
+// Replay reads records with index greater or equal to `from` in order and passes them to fn.
+// Replay stops on the first error returned by fn.
+func (w *WAL) Replay(from uint64, fn func(index uint64, data []byte) error) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	segments, err := w.segments()
+	if err != nil {
+		return err
+	}
+
+	for i, start := range segments {
+		// Skip segments which contain only older records.
+		if i+1 < len(segments) && segments[i+1] <= from {
+			continue
+		}
+
+		f, err := os.Open(w.segmentPath(start))
+		if err != nil {
+			return err
+		}
+		_, err = readSegment(f, func(index uint64, data []byte) error {
+			if index < from {
+				return nil
+			}
+			return fn(index, data)
+		})
+		f.Close()
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Truncate removes segments which contain only records with index less or equal to upTo.
+// If all records of the active segment are covered, a new segment is started,
+// so the active one can be removed as well.
+func (w *WAL) Truncate(upTo uint64) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.closed {
+		return ErrClosed
+	}
+
+	if w.index >= w.segStart && w.index <= upTo {
+		if err := w.rotate(); err != nil {
+			return err
+		}
+	}
+
+	segments, err := w.segments()
+	if err != nil {
+		return err
+	}
+
+	for i := 0; i+1 < len(segments); i++ {
+		// The last record of the segment is right before the first record of the next one.
+		if segments[i+1]-1 > upTo {
+			break
+		}
+		if err := os.Remove(w.segmentPath(segments[i])); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Sync flushes appended records to the disk.
+func (w *WAL) Sync() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.closed {
+		return ErrClosed
+	}
+	return w.sync()
+}
+
+// Close flushes and closes the active segment.
+func (w *WAL) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.closed {
+		return nil
+	}
+	w.closed = true
+	close(w.done)
+
+	if err := w.sync(); err != nil {
+		return err
+	}
+	return w.file.Close()
+}
+
+func (w *WAL) syncWorker(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-w.done:
+			return
+		case <-ticker.C:
+			// If it fails, the next tick or Close will try it again.
+			_ = w.Sync()
+		}
+	}
+}
+
+func (w *WAL) sync() error {
+	if !w.dirty {
+		return nil
+	}
+	if err := w.file.Sync(); err != nil {
+		return err
+	}
+	w.dirty = false
+	return nil
+}
+
+// rotate closes the active segment and starts a new one after the last record.
+func (w *WAL) rotate() error {
+	if err := w.sync(); err != nil {
+		return err
+	}
+	if err := w.file.Close(); err != nil {
+		return err
+	}
+	return w.createSegment(w.index + 1)
+}
+
+func (w *WAL) createSegment(start uint64) error {
+	f, err := os.OpenFile(w.segmentPath(start), os.O_CREATE|os.O_RDWR|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	w.file = f
+	w.fileSize = 0
+	w.segStart = start
+	w.index = start - 1
+	return nil
+}
+
+// openLastSegment reads the last segment to find the last index
+// and truncates the incomplete record written before a crash.
+func (w *WAL) openLastSegment(start uint64) error {
+	f, err := os.OpenFile(w.segmentPath(start), os.O_RDWR|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+
+	last := start - 1
+	size, err := readSegment(f, func(index uint64, _ []byte) error {
+		last = index
+		return nil
+	})
+	if err != nil && err != ErrCorrupted {
+		f.Close()
+		return err
+	}
+	if err = f.Truncate(size); err != nil {
+		f.Close()
+		return err
+	}
+
+	w.file = f
+	w.fileSize = size
+	w.segStart = start
+	w.index = last
+	return nil
+}
+
+// segments returns the first indexes of all segments in ascending order.
+func (w *WAL) segments() ([]uint64, error) {
+	entries, err := os.ReadDir(w.dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var segments []uint64
+	for _, e := range entries {
+		name := e.Name()
+		if e.IsDir() || !strings.HasSuffix(name, segmentExt) {
+			continue
+		}
+		start, err := strconv.ParseUint(strings.TrimSuffix(name, segmentExt), 10, 64)
+		if err != nil {
+			continue
+		}
+		segments = append(segments, start)
+	}
+	sort.Slice(segments, func(i, j int) bool { return segments[i] < segments[j] })
+
+	return segments, nil
+}
+
+func (w *WAL) segmentPath(start uint64) string {
+	return filepath.Join(w.dir, fmt.Sprintf("%020d%s", start, segmentExt))
+}
+
+// readSegment reads records from the beginning of the file and returns the size of the valid part.
+// ErrCorrupted is returned when the record is incomplete or it's checksum doesn't match.
+func readSegment(f *os.File, fn func(index uint64, data []byte) error) (int64, error) {
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return 0, err
+	}
+	stat, err := f.Stat()
+	if err != nil {
+		return 0, err
+	}
+
+	var size int64
+	header := make([]byte, headerSize)
+	for {
+		_, err = io.ReadFull(f, header)
+		if err == io.EOF {
+			return size, nil
+		}
+		if err != nil {
+			return size, ErrCorrupted
+		}
+
+		index := binary.LittleEndian.Uint64(header[0:8])
+		length := binary.LittleEndian.Uint32(header[8:12])
+		sum := binary.LittleEndian.Uint32(header[12:16])
+
+		// Length of the corrupted record can be anything, don't allocate more than the file has.
+		if size+int64(headerSize)+int64(length) > stat.Size() {
+			return size, ErrCorrupted
+		}
+
+		data := make([]byte, length)
+		if _, err = io.ReadFull(f, data); err != nil {
+			return size, ErrCorrupted
+		}
+		if checksum(header[0:12], data) != sum {
+			return size, ErrCorrupted
+		}
+
+		if err = fn(index, data); err != nil {
+			return size, err
+		}
+		size += int64(headerSize) + int64(length)
+	}
+}
+
+func checksum(header, data []byte) uint32 {
+	sum := crc32.Update(0, crcTable, header)
+	return crc32.Update(sum, crcTable, data)
+}
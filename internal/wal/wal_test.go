@@ -0,0 +1,249 @@
+package wal
+
+import (
+	"os"
+	"reflect"
+	"strconv"
+	"testing"
+)
+
+// recordSize is the size of the test record on the disk, the header and the payload of 4 bytes.
+const recordSize = headerSize + 4
+
+func openTest(t *testing.T, dir string, segmentSize int64) *WAL {
+	t.Helper()
+	w, err := Open(dir, segmentSize, SyncNever, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { w.Close() })
+	return w
+}
+
+// appendRecords appends the records "r001", "r002"... with the indexes from the last one to n.
+func appendRecords(t *testing.T, w *WAL, n int) {
+	t.Helper()
+	for i := int(w.LastIndex()) + 1; i <= n; i++ {
+		index, err := w.Append(testRecord(i))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if index != uint64(i) {
+			t.Fatalf("index = %d, want %d", index, i)
+		}
+	}
+}
+
+func testRecord(i int) []byte {
+	return []byte("r" + strconv.Itoa(1000 + i)[1:])
+}
+
+// replayed returns the indexes of the replayed records, checking their payloads.
+func replayed(t *testing.T, w *WAL, from uint64) (indexes []uint64) {
+	t.Helper()
+	err := w.Replay(from, func(index uint64, data []byte) error {
+		if string(data) != string(testRecord(int(index))) {
+			t.Fatalf("record %d = %q", index, data)
+		}
+		indexes = append(indexes, index)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return
+}
+
+func indexRange(from, to uint64) (indexes []uint64) {
+	for i := from; i <= to; i++ {
+		indexes = append(indexes, i)
+	}
+	return
+}
+
+func TestAppendReplay(t *testing.T) {
+	dir := t.TempDir()
+	w := openTest(t, dir, 1<<20)
+	appendRecords(t, w, 10)
+	if got := replayed(t, w, 1); !reflect.DeepEqual(got, indexRange(1, 10)) {
+		t.Fatalf("replayed %v", got)
+	}
+	w.Close()
+
+	// The reopened log continues after the last record.
+	w = openTest(t, dir, 1<<20)
+	if last := w.LastIndex(); last != 10 {
+		t.Fatalf("last index = %d, want 10", last)
+	}
+	appendRecords(t, w, 12)
+	if got := replayed(t, w, 1); !reflect.DeepEqual(got, indexRange(1, 12)) {
+		t.Fatalf("replayed %v", got)
+	}
+
+	w.Close()
+	if _, err := w.Append(testRecord(13)); err != ErrClosed {
+		t.Fatalf("append to the closed log: %v", err)
+	}
+}
+
+func TestSegmentRotation(t *testing.T) {
+	tests := []struct {
+		name        string
+		segmentSize int64
+		records     int
+		want        []uint64
+	}{
+		{name: "one segment", segmentSize: 1 << 20, records: 10, want: []uint64{1}},
+		{name: "full segments", segmentSize: 3 * recordSize, records: 9, want: []uint64{1, 4, 7}},
+		// The segment is rotated once it reaches the size, so it ends with the record which crossed it.
+		{name: "record over the size", segmentSize: 2*recordSize + 1, records: 7, want: []uint64{1, 4, 7}},
+		{name: "record larger than the segment", segmentSize: 1, records: 3, want: []uint64{1, 2, 3}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			w := openTest(t, dir, tt.segmentSize)
+			appendRecords(t, w, tt.records)
+
+			segments, err := w.segments()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(segments, tt.want) {
+				t.Fatalf("segments = %v, want %v", segments, tt.want)
+			}
+			w.Close()
+
+			w = openTest(t, dir, tt.segmentSize)
+			if got := replayed(t, w, 1); !reflect.DeepEqual(got, indexRange(1, uint64(tt.records))) {
+				t.Fatalf("replayed %v", got)
+			}
+		})
+	}
+}
+
+func TestTruncate(t *testing.T) {
+	tests := []struct {
+		name string
+		// Segments start at 1, 4, 7 and 10, the last one has a single record.
+		upTo         uint64
+		wantSegments []uint64
+		wantFirst    uint64
+	}{
+		{name: "nothing", upTo: 0, wantSegments: []uint64{1, 4, 7, 10}, wantFirst: 1},
+		{name: "part of the segment", upTo: 2, wantSegments: []uint64{1, 4, 7, 10}, wantFirst: 1},
+		{name: "whole segment", upTo: 3, wantSegments: []uint64{4, 7, 10}, wantFirst: 4},
+		{name: "segments before the active one", upTo: 9, wantSegments: []uint64{10}, wantFirst: 10},
+		// The new segment is started, so the active one can be removed as well.
+		{name: "all records", upTo: 10, wantSegments: []uint64{11}, wantFirst: 11},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			w := openTest(t, dir, 3*recordSize)
+			appendRecords(t, w, 10)
+
+			if err := w.Truncate(tt.upTo); err != nil {
+				t.Fatal(err)
+			}
+			segments, err := w.segments()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(segments, tt.wantSegments) {
+				t.Fatalf("segments = %v, want %v", segments, tt.wantSegments)
+			}
+
+			// Indexes keep growing after the truncation, also when the log is reopened.
+			appendRecords(t, w, 11)
+			w.Close()
+			w = openTest(t, dir, 3*recordSize)
+			if last := w.LastIndex(); last != 11 {
+				t.Fatalf("last index = %d, want 11", last)
+			}
+			if got := replayed(t, w, 1); !reflect.DeepEqual(got, indexRange(tt.wantFirst, 11)) {
+				t.Fatalf("replayed %v", got)
+			}
+		})
+	}
+}
+
+func TestRecovery(t *testing.T) {
+	tests := []struct {
+		name string
+		// damage changes the content of the last segment, which has 5 records.
+		damage func(data []byte) []byte
+	}{
+		{name: "torn header", damage: func(data []byte) []byte { return data[:len(data)-recordSize+headerSize/2] }},
+		{name: "torn payload", damage: func(data []byte) []byte { return data[:len(data)-1] }},
+		{name: "corrupted payload", damage: func(data []byte) []byte {
+			data[len(data)-1] ^= 0xff
+			return data
+		}},
+		{name: "corrupted length", damage: func(data []byte) []byte {
+			data[len(data)-recordSize+8] = 0xff
+			return data
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			w := openTest(t, dir, 5*recordSize)
+			appendRecords(t, w, 10)
+			w.Close()
+
+			path := w.segmentPath(6)
+			data, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err = os.WriteFile(path, tt.damage(data), 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			// The damaged last record is dropped and it's index is given to the next one.
+			w = openTest(t, dir, 5*recordSize)
+			if last := w.LastIndex(); last != 9 {
+				t.Fatalf("last index = %d, want 9", last)
+			}
+			if got := replayed(t, w, 1); !reflect.DeepEqual(got, indexRange(1, 9)) {
+				t.Fatalf("replayed %v", got)
+			}
+			appendRecords(t, w, 10)
+			w.Close()
+
+			w = openTest(t, dir, 5*recordSize)
+			if got := replayed(t, w, 1); !reflect.DeepEqual(got, indexRange(1, 10)) {
+				t.Fatalf("replayed after the append %v", got)
+			}
+		})
+	}
+}
+
+func TestReplayFrom(t *testing.T) {
+	tests := []struct {
+		name string
+		from uint64
+		want []uint64
+	}{
+		{name: "zero", from: 0, want: indexRange(1, 10)},
+		{name: "first record", from: 1, want: indexRange(1, 10)},
+		{name: "middle of the segment", from: 5, want: indexRange(5, 10)},
+		{name: "first record of the segment", from: 7, want: indexRange(7, 10)},
+		{name: "last record", from: 10, want: []uint64{10}},
+		{name: "after the last record", from: 11},
+	}
+
+	w := openTest(t, t.TempDir(), 3*recordSize)
+	appendRecords(t, w, 10)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := replayed(t, w, tt.from); !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("replayed %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -1,21 +1,32 @@
 package workers
 
 import (
+	"errors"
 	"fmt"
 	"strings"
 
 	"github.com/LukaGiorgadze/bloXroute/internal/models"
+	"github.com/LukaGiorgadze/bloXroute/internal/store"
+)
+
+var (
+	ErrItemNotFound    = errors.New("Item not found.")
+	ErrHistoryDisabled = errors.New("Item history is disabled.")
+	ErrStoreNotBounded = errors.New("Store is not bounded.")
 )
 
 // SemaphoreReader is a structure that limits the maximum number of concurrent readers.
 type SemaphoreReader struct {
-	queue         chan struct{}
+	queue chan struct{}
+	// pageSize is the default and the maximum number of items in the list page.
+	pageSize      int
 	workersConfig *WorkersConfig
 }
 
-func NewSemaphoreReader(max uint8, cfg *WorkersConfig) *SemaphoreReader {
+func NewSemaphoreReader(max uint8, pageSize int, cfg *WorkersConfig) *SemaphoreReader {
 	return &SemaphoreReader{
 		queue:         make(chan struct{}, max),
+		pageSize:      pageSize,
 		workersConfig: cfg,
 	}
 }
@@ -30,52 +41,362 @@ func (s *SemaphoreReader) Release() {
 	<-s.queue
 }
 
-// ReadAll reads all items safely in the store using RLock.
-func (s *SemaphoreReader) ReadAll(fileWriterCh chan<- string) {
+// Stale replies with ErrStale to the read request, if the replica is the follower which is behind the leader
+// longer than the staleness bound, so the client doesn't get outdated items.
+func (s *SemaphoreReader) Stale(item *models.Msg) bool {
+	if s.workersConfig.Replicator == nil || !s.workersConfig.Replicator.Stale() {
+		return false
+	}
+	respond(s.workersConfig, item.Reply, models.Response{Error: ErrStale.Error()})
+	return true
+}
+
+// linearize waits until the node of the Raft cluster applied all mutations committed before the read request,
+// so the client never reads the state older than the one acknowledged to it (or to any other client) before.
+// It replies with the error if the node can't catch up in time, e.g. there is no leader.
+// Without the Raft cluster reads are served right away.
+func (s *SemaphoreReader) linearize(item *models.Msg) bool {
+	if s.workersConfig.Consensus == nil {
+		return true
+	}
+	if err := s.workersConfig.Consensus.Barrier(); err != nil {
+		respond(s.workersConfig, item.Reply, models.Response{Error: err.Error()})
+		return false
+	}
+	return true
+}
+
+// ReadAll reads a page of items safely in the store using RLock and replies with it to the requester.
+// The page starts after the cursor (or the item with the After key) and holds up to Limit items,
+// the cursor of the next page is sent with it if there are more items.
+// The lock is held only while the page is copied, so listing a large store doesn't block writers for long.
+func (s *SemaphoreReader) ReadAll(item *models.Msg, fileWriterCh chan<- string) {
 
 	defer s.Release()
 
-	s.workersConfig.Store.Lock().RLock()
-	items := s.workersConfig.Store.GetAll()
-	s.workersConfig.Store.Lock().RUnlock()
+	s.list(item, fileWriterCh, s.page)
 
-	str := strings.Join(items, ",")
+}
 
-	// Print data in the server's stdout
-	fmt.Println(str)
+// ReadPrefix reads a page of items with keys starting with the Prefix and matching the Pattern (if it's given).
+// Items are listed in the order of the store with the same cursors as in ReadAll,
+// or in the lexical order of keys if Lexical is set, then the cursor is the last listed key.
+func (s *SemaphoreReader) ReadPrefix(item *models.Msg, fileWriterCh chan<- string) {
 
-	// Send data to the file writer channel, so FileWriter worker can start it's job.
-	fileWriterCh <- str
+	defer s.Release()
+
+	s.list(item, fileWriterCh, s.scan)
+
+}
+
+// ReadRange reads a page of items with keys in the range [From, To), in the lexical order of keys.
+// The cursor is the last listed key, the page starts after it (or before it in the reverse order).
+func (s *SemaphoreReader) ReadRange(item *models.Msg, fileWriterCh chan<- string) {
+
+	defer s.Release()
+
+	s.list(item, fileWriterCh, s.keyRange)
+
+}
+
+// list reads the page, replies with it to the requester and outputs it.
+// The store is read-locked while the page is read, unless it's read from the committed version of the versioned store.
+func (s *SemaphoreReader) list(item *models.Msg, fileWriterCh chan<- string, read func(store.IReader, *models.Msg, int) ([]models.Item, string, error)) {
+
+	if !s.linearize(item) {
+		return
+	}
+
+	limit := item.Limit
+	if limit <= 0 || limit > s.pageSize {
+		limit = s.pageSize
+	}
+
+	b, ok := s.workersConfig.bucket(item.Bucket)
+	if !ok {
+		respond(s.workersConfig, item.Reply, models.Response{Error: ErrBucketNotFound.Error()})
+		return
+	}
+
+	reader, seq, release, err := readStore(b.Store, item.AsOf, "")
+	if err != nil {
+		respond(s.workersConfig, item.Reply, models.Response{Error: err.Error()})
+		return
+	}
+	// The sequence of the last applied mutation is taken before the page is read,
+	// so it's items are at least as new as it.
+	mutationSeq := s.workersConfig.MutationSeq.Load()
+	items, next, err := read(reader, item, limit)
+	release()
+	if err != nil {
+		respond(s.workersConfig, item.Reply, models.Response{Error: err.Error()})
+		return
+	}
+
+	// Reply to the client first, so it doesn't wait for the server's own outputs.
+	respond(s.workersConfig, item.Reply, models.Response{Items: items, Next: next, Seq: seq, MutationSeq: mutationSeq})
+
+	// The page is written as the JSON array of items.
+	strs := make([]string, len(items))
+	for i := range items {
+		strs[i] = items[i].String()
+	}
+	s.output("["+strings.Join(strs, ",")+"]", fileWriterCh)
+
+}
+
+// page collects up to limit items after the requested position.
+// The cursor of the last collected item is returned only when there is at least one more item.
+func (s *SemaphoreReader) page(reader store.IReader, item *models.Msg, limit int) ([]models.Item, string, error) {
+	cursor, err := s.cursor(reader, item)
+	if err != nil {
+		return nil, "", err
+	}
+
+	p := newPage(limit, nil)
+	err = reader.Iterate(cursor, item.Reverse, p.collect)
+	return p.items, p.next, err
+}
+
+// scan collects up to limit matching items after the requested position.
+// Keys are looked up in the store's key index by the prefix, which is extended by the literal beginning of the pattern.
+// In the order of the store all items after the position are checked instead.
+func (s *SemaphoreReader) scan(reader store.IReader, item *models.Msg, limit int) ([]models.Item, string, error) {
+	prefix := item.Prefix
+	if item.Pattern != "" {
+		literal := globPrefix(item.Pattern)
+		switch {
+		case strings.HasPrefix(literal, prefix):
+			prefix = literal
+		case !strings.HasPrefix(prefix, literal):
+			// No key can start with both of them.
+			return []models.Item{}, "", nil
+		}
+	}
+
+	p := newPage(limit, func(i models.Item) bool {
+		return strings.HasPrefix(i.Key, prefix) && (item.Pattern == "" || matchGlob(item.Pattern, i.Key))
+	})
+
+	if item.Lexical {
+		after := item.After
+		if item.Cursor != "" {
+			after = item.Cursor
+		}
+		reader.Scan(prefix, after, item.Reverse, func(i models.Item) bool {
+			return p.collect(i, i.Key)
+		})
+		return p.items, p.next, nil
+	}
+
+	cursor, err := s.cursor(reader, item)
+	if err != nil {
+		return nil, "", err
+	}
+	err = reader.Iterate(cursor, item.Reverse, p.collect)
+	return p.items, p.next, err
+}
+
+// keyRange collects up to limit items in the requested range after the cursor.
+func (s *SemaphoreReader) keyRange(reader store.IReader, item *models.Msg, limit int) ([]models.Item, string, error) {
+	from, to := item.From, item.To
+
+	cursor := item.After
+	if item.Cursor != "" {
+		cursor = item.Cursor
+	}
+	if cursor != "" {
+		if !item.Reverse && cursor >= from {
+			// The first key greater than the cursor.
+			from = cursor + "\x00"
+		}
+		if item.Reverse && (to == "" || cursor < to) {
+			to = cursor
+		}
+	}
+
+	p := newPage(limit, nil)
+	reader.Range(from, to, item.Reverse, func(i models.Item) bool {
+		return p.collect(i, i.Key)
+	})
+	return p.items, p.next, nil
+}
+
+// cursor returns the requested position in the order of the store, the After key takes precedence over the Cursor.
+func (s *SemaphoreReader) cursor(reader store.IReader, item *models.Msg) (string, error) {
+	if item.After == "" {
+		return item.Cursor, nil
+	}
+	cursor, ok := reader.Cursor(item.After)
+	if !ok {
+		return "", ErrItemNotFound
+	}
+	return cursor, nil
+}
+
+// listPage collects items of the list page.
+type listPage struct {
+	limit int
+	// match selects collected items, all items are collected if it's nil.
+	match func(models.Item) bool
+	items []models.Item
+	last  string
+	// next is the cursor of the last collected item, it's set only when there is at least one more item.
+	next string
+}
+
+func newPage(limit int, match func(models.Item) bool) *listPage {
+	return &listPage{limit: limit, match: match, items: make([]models.Item, 0, limit)}
+}
 
+// collect adds the item to the page and returns false when the page is full, so the iteration stops.
+func (p *listPage) collect(item models.Item, cursor string) bool {
+	if p.match != nil && !p.match(item) {
+		return true
+	}
+	if len(p.items) == p.limit {
+		p.next = p.last
+		return false
+	}
+	p.items = append(p.items, item)
+	p.last = cursor
+	return true
 }
 
-// ReadOne reads one item from the store by key.
+// ReadOne reads one item from the store by key and replies with it to the requester.
 func (s *SemaphoreReader) ReadOne(item *models.Msg, fileWriterCh chan<- string) {
 
 	defer s.Release()
 
-	s.workersConfig.Store.Lock().RLock()
-	val, ok := s.workersConfig.Store.Get(item.Key)
-	s.workersConfig.Store.Lock().RUnlock()
+	if !s.linearize(item) {
+		return
+	}
+
+	b, ok := s.workersConfig.bucket(item.Bucket)
+	if !ok {
+		respond(s.workersConfig, item.Reply, models.Response{Error: ErrBucketNotFound.Error()})
+		return
+	}
+
+	reader, seq, release, err := readStore(b.Store, item.AsOf, item.Key)
+	if err != nil {
+		respond(s.workersConfig, item.Reply, models.Response{Error: err.Error()})
+		return
+	}
+	found, ok := reader.GetItem(item.Key)
+	release()
 	if !ok {
+		respond(s.workersConfig, item.Reply, models.Response{Error: ErrItemNotFound.Error()})
 		fmt.Println(item.Key, "= no data")
 		return
 	}
 
-	// Build the string to be sent to the channel.
-	// The reason of using strings.Builder instead of string concatenation is
-	// that string is immutable and concatenation allocates memory each time,
-	// which may lead to performance issues. Using strings.Builder, we can efficiently build the
-	// string by appending each piece to the buffer without creating intermediate copies of the string.
-	var sb strings.Builder
-	sb.WriteString(item.Key)
-	sb.WriteString("=")
-	sb.WriteString(val)
+	// The read counts as the use of the item for the eviction policy.
+	// Nodes of the Raft cluster count only mutations, so all of them evict the same items.
+	if b.Evictor != nil && s.workersConfig.Consensus == nil {
+		b.Evictor.Touch(item.Key)
+	}
+
+	// Only the part of the document selected by the path is returned.
+	if item.Path != "" {
+		if found.Value, err = found.Value.Path(item.Path); err != nil {
+			respond(s.workersConfig, item.Reply, models.Response{Error: err.Error()})
+			return
+		}
+	}
+
+	respond(s.workersConfig, item.Reply, models.Response{Items: []models.Item{found}, Seq: seq})
+
+	s.output(found.String(), fileWriterCh)
+
+}
+
+// ReadHistory reads recent changes of the item by key, including it's removal, and replies with them to the requester.
+// The history has it's own lock, so the store isn't locked.
+func (s *SemaphoreReader) ReadHistory(item *models.Msg, fileWriterCh chan<- string) {
+
+	defer s.Release()
+
+	if !s.linearize(item) {
+		return
+	}
+
+	b, ok := s.workersConfig.bucket(item.Bucket)
+	if !ok {
+		respond(s.workersConfig, item.Reply, models.Response{Error: ErrBucketNotFound.Error()})
+		return
+	}
+	if b.History == nil {
+		respond(s.workersConfig, item.Reply, models.Response{Error: ErrHistoryDisabled.Error()})
+		return
+	}
+
+	history := b.History.Get(item.Key)
+	if len(history) == 0 {
+		respond(s.workersConfig, item.Reply, models.Response{Error: ErrItemNotFound.Error()})
+		fmt.Println(item.Key, "= no history")
+		return
+	}
+
+	respond(s.workersConfig, item.Reply, models.Response{History: history})
+
+	s.output(fmt.Sprintf("%s history: %d changes", item.Key, len(history)), fileWriterCh)
+
+}
+
+// ReadStats replies with the usage of the bounded store: the number and the size of items and the number of evictions.
+func (s *SemaphoreReader) ReadStats(item *models.Msg) {
+
+	defer s.Release()
+
+	if !s.linearize(item) {
+		return
+	}
+
+	b, ok := s.workersConfig.bucket(item.Bucket)
+	if !ok {
+		respond(s.workersConfig, item.Reply, models.Response{Error: ErrBucketNotFound.Error()})
+		return
+	}
+	if b.Evictor == nil {
+		respond(s.workersConfig, item.Reply, models.Response{Error: ErrStoreNotBounded.Error()})
+		return
+	}
+
+	stats := b.Evictor.Stats()
+	respond(s.workersConfig, item.Reply, models.Response{Stats: &stats})
+
+}
+
+// ReadBuckets replies with the names and the settings of the named buckets, sorted by their names.
+func (s *SemaphoreReader) ReadBuckets(item *models.Msg) {
+
+	defer s.Release()
+
+	if !s.linearize(item) {
+		return
+	}
+
+	buckets := []models.Bucket{}
+	for _, b := range s.workersConfig.allBuckets()[1:] {
+		buckets = append(buckets, b.Spec)
+	}
+	respond(s.workersConfig, item.Reply, models.Response{Buckets: buckets})
+
+}
+
+// output prints data in the server's stdout and sends it to the file writer channel.
+// FileWriter worker doesn't run when no output file is configured, so nothing is sent in that case,
+// otherwise readers would block on the full channel forever.
+func (s *SemaphoreReader) output(str string, fileWriterCh chan<- string) {
 
 	// Print data in the server's stdout
-	fmt.Println(sb.String())
+	fmt.Println(str)
 
-	// Send data to the file writer channel, so FileWriter worker can start it's job.
-	fileWriterCh <- sb.String()
+	if s.workersConfig.Store.GetOutputFilePath() == "" {
+		return
+	}
 
+	// Send data to the file writer channel, so FileWriter worker can start it's job.
+	fileWriterCh <- str
 }
@@ -0,0 +1,107 @@
+package workers
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/LukaGiorgadze/bloXroute/internal/models"
+)
+
+var ErrUnknownOperation = errors.New("Unknown operation.")
+
+// batchOps maps names of the batch operations to the mutate subjects.
+var batchOps = map[string]string{
+	"add":    ADD_ITEM,
+	"delete": DELETE_ITEM,
+	"update": UPDATE_ITEM,
+	"cas":    CAS_ITEM,
+}
+
+// batchState is the state of the item as it would be after the preceding operations of the batch.
+// The revision of the item changed by them is given by the store when it's applied, so it's not known (0)
+// and the conditional operation on such item fails.
+type batchState struct {
+	exists   bool
+	revision uint64
+}
+
+// applyBatch applies operations of the batch in order, all or nothing.
+// First, every operation is checked against the state of the store, changed by the preceding operations
+// of the same batch. If any of them would fail, nothing is applied and the error is returned.
+// Otherwise, all of them are applied and the items after the mutations are returned.
+// The store stays locked for the whole batch, so readers never see it partially applied.
+// It should be called while the store is locked.
+func (o *OnceMutator) applyBatch(batch *models.Msg) (resp models.Response) {
+	states := make(map[string]batchState)
+
+	for i := range batch.Ops {
+		op := &batch.Ops[i]
+
+		subject, ok := batchOps[op.Op]
+		if !ok {
+			resp.Error = fmt.Sprintf("Batch operation %d failed: %s", i, ErrUnknownOperation)
+			return
+		}
+		op.Subject = subject
+
+		if err := o.checkBatchOp(op, states); err != nil {
+			resp.Error = fmt.Sprintf("Batch operation %d failed: %s", i, err)
+			return
+		}
+	}
+
+	// All items changed by the batch get it's sequence once it's numbered, see number.
+	for i := range batch.Ops {
+		opResp := o.applyItem(&batch.Ops[i])
+		resp.Items = append(resp.Items, opResp.Items...)
+	}
+
+	return
+}
+
+// checkBatchOp checks whether the operation would succeed and changes the state of the item accordingly.
+func (o *OnceMutator) checkBatchOp(op *models.Msg, states map[string]batchState) error {
+	if op.Subject != DELETE_ITEM && o.bucket.Evictor != nil && !o.bucket.Evictor.Fits(op.Key, string(op.Value)) {
+		return ErrItemTooLarge
+	}
+
+	state, ok := states[op.Key]
+	if !ok {
+		current, exists := o.bucket.Store.GetItemAt(op.Key, op.Time)
+		state = batchState{exists: exists, revision: current.Revision}
+	}
+
+	switch op.Subject {
+	case ADD_ITEM:
+		if state.exists {
+			return ErrItemExists
+		}
+		state = batchState{exists: true}
+
+	case DELETE_ITEM:
+		if !state.exists {
+			return ErrItemNotFound
+		}
+		if op.Revision != 0 && op.Revision != state.revision {
+			return ErrRevisionMismatch
+		}
+		state = batchState{}
+
+	case UPDATE_ITEM:
+		state = batchState{exists: true}
+
+	case CAS_ITEM:
+		switch {
+		case op.Revision == 0 && state.exists:
+			return ErrItemExists
+		case !state.exists && op.Revision != 0:
+			return ErrItemNotFound
+		case op.Revision != state.revision:
+			return ErrRevisionMismatch
+		}
+		state = batchState{exists: true}
+	}
+
+	states[op.Key] = state
+	return nil
+}
@@ -0,0 +1,122 @@
+package workers
+
+import (
+	"errors"
+	"sort"
+
+	"github.com/LukaGiorgadze/bloXroute/internal/client"
+	"github.com/LukaGiorgadze/bloXroute/internal/models"
+)
+
+const (
+	CREATE_BUCKET = string(client.BucketCreateSubject)
+	DELETE_BUCKET = string(client.BucketDeleteSubject)
+)
+
+var (
+	ErrBucketNotFound      = errors.New("Bucket not found.")
+	ErrBucketExists        = errors.New("Bucket already exists.")
+	ErrInvalidBucketName   = errors.New("Invalid bucket name.")
+	ErrBucketsNotSupported = errors.New("Buckets are not supported.")
+)
+
+// bucket returns the bucket with the name, the default bucket if the name is empty.
+func (cfg *WorkersConfig) bucket(name string) (*Bucket, bool) {
+	if name == "" {
+		return &cfg.Bucket, true
+	}
+
+	cfg.bucketsLock.RLock()
+	defer cfg.bucketsLock.RUnlock()
+
+	b, ok := cfg.buckets[name]
+	return b, ok
+}
+
+// allBuckets returns the default bucket followed by the named buckets sorted by their names,
+// so their stores are always locked in the same order.
+func (cfg *WorkersConfig) allBuckets() []*Bucket {
+	cfg.bucketsLock.RLock()
+	defer cfg.bucketsLock.RUnlock()
+
+	buckets := make([]*Bucket, 0, len(cfg.buckets)+1)
+	for _, b := range cfg.buckets {
+		buckets = append(buckets, b)
+	}
+	sort.Slice(buckets, func(i, j int) bool { return buckets[i].Spec.Name < buckets[j].Spec.Name })
+
+	return append([]*Bucket{&cfg.Bucket}, buckets...)
+}
+
+// createBucket creates the named bucket with the empty store.
+func (cfg *WorkersConfig) createBucket(spec models.Bucket) (*Bucket, error) {
+	if cfg.NewBucket == nil {
+		return nil, ErrBucketsNotSupported
+	}
+	if !client.ValidBucketName(spec.Name) {
+		return nil, ErrInvalidBucketName
+	}
+
+	cfg.bucketsLock.Lock()
+	defer cfg.bucketsLock.Unlock()
+
+	if _, ok := cfg.buckets[spec.Name]; ok {
+		return nil, ErrBucketExists
+	}
+	b, err := cfg.NewBucket(spec)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.buckets == nil {
+		cfg.buckets = make(map[string]*Bucket)
+	}
+	cfg.buckets[spec.Name] = b
+	return b, nil
+}
+
+// deleteBucket removes the named bucket with all of it's items.
+// Readers which have already got the bucket finish reading it's store.
+func (cfg *WorkersConfig) deleteBucket(name string) (*Bucket, error) {
+	cfg.bucketsLock.Lock()
+	defer cfg.bucketsLock.Unlock()
+
+	b, ok := cfg.buckets[name]
+	if !ok {
+		return nil, ErrBucketNotFound
+	}
+	delete(cfg.buckets, name)
+	return b, nil
+}
+
+// resetBuckets removes all named buckets, e.g. before the state is replaced by the leader's snapshot.
+func (cfg *WorkersConfig) resetBuckets() {
+	cfg.bucketsLock.Lock()
+	defer cfg.bucketsLock.Unlock()
+
+	cfg.buckets = nil
+}
+
+// applyBucket creates or deletes the bucket of the admin message and returns it in the response.
+// It's applied by the mutator in the order of mutations, so mutations of the bucket sent after it's created
+// are applied to it, and written in the write-ahead log with them.
+func (o *OnceMutator) applyBucket(item *models.Msg) (resp models.Response) {
+	if item.Spec == nil {
+		resp.Error = ErrInvalidBucketName.Error()
+		return
+	}
+
+	var b *Bucket
+	var err error
+	if item.Subject == CREATE_BUCKET {
+		b, err = o.workersConfig.createBucket(*item.Spec)
+	} else {
+		b, err = o.workersConfig.deleteBucket(item.Spec.Name)
+	}
+	if err != nil {
+		resp.Error = err.Error()
+		return
+	}
+
+	resp.Buckets = []models.Bucket{b.Spec}
+	return
+}
@@ -1,10 +1,88 @@
 package workers
 
 import (
+	"sync"
+	"sync/atomic"
+
+	"github.com/LukaGiorgadze/bloXroute/internal/client"
+	"github.com/LukaGiorgadze/bloXroute/internal/models"
 	"github.com/LukaGiorgadze/bloXroute/internal/store"
+	"github.com/LukaGiorgadze/bloXroute/internal/wal"
 )
 
 // Global/shared configuration for workers
 type WorkersConfig struct {
+	// Bucket is the default bucket, used by the subjects without the bucket name.
+	Bucket
+
+	// buckets are the named buckets by their names, see buckets.go.
+	buckets     map[string]*Bucket
+	bucketsLock sync.RWMutex
+
+	// NewBucket creates the named bucket with the store of the given type and limits.
+	// It's nil when named buckets are not supported.
+	NewBucket func(models.Bucket) (*Bucket, error)
+
+	// MsgClient is used by workers to send responses back to the clients.
+	MsgClient client.IMessageClient
+
+	// AppliedSeq is the mutation log sequence up to which messages are applied to the store.
+	// Lanes of the mutator pool apply messages in parallel, so later messages may be applied before the earlier ones,
+	// sequences of those are kept in appliedAbove until AppliedSeq reaches them (see progress.go).
+	// They are changed by the mutator while the item is locked (see lockItem), under the appliedLock since lanes
+	// change them in parallel, and read under lockConsistent of the stores of all buckets,
+	// so they are always consistent with the store data.
+	AppliedSeq   uint64
+	appliedAbove map[uint64]struct{}
+	// pendingSeqs are the sequences of messages dispatched to the lanes which are not processed yet.
+	pendingSeqs map[uint64]int
+	appliedLock sync.Mutex
+
+	// WAL is the write-ahead log where mutations are appended before they are applied to the store.
+	// It's nil when the write-ahead log is disabled.
+	WAL *wal.WAL
+	// walLock is held from the append of the mutation to the write-ahead log until it's numbered, see OnceMutator.process.
+	walLock sync.Mutex
+
+	// AppliedIndex is the write-ahead log index of the last mutation applied to the store.
+	// Mutations are appended to the write-ahead log while their item is locked, so all mutations up to it are applied.
+	// It's guarded the same way as AppliedSeq.
+	AppliedIndex uint64
+
+	// EventSeq is the sequence of the last change event of all buckets.
+	// Events are created while the store of their bucket is locked, but the stores of different buckets
+	// are changed in parallel (by the mutator and the reaper), so it's changed atomically.
+	EventSeq atomic.Uint64
+
+	// MutationSeq is the sequence of the last mutation applied to the store, mutations of all buckets are numbered
+	// in the order they are applied (see sequence). Lanes of the mutator number them in parallel, so it's changed atomically.
+	MutationSeq atomic.Uint64
+
+	// producers are the sequences of the last applied mutations of the producers by their names, see models.Msg.
+	producers     map[string]uint64
+	producersLock sync.Mutex
+
+	// Replicator replicates mutations from the leader to the followers, see replication.go.
+	// It's nil when the replication is disabled.
+	Replicator *Replicator
+
+	// Consensus replicates mutations through the Raft log of the cluster, see consensus.go.
+	// It's nil when the Raft cluster is disabled.
+	Consensus *Consensus
+}
+
+// Bucket is the keyspace of items with it's own store, history and limits.
+type Bucket struct {
+	// Spec is the name of the bucket and the settings it was created with, the name is empty for the default bucket.
+	Spec models.Bucket
+
 	Store store.IStore
+
+	// History keeps recent changes of items, they are recorded with the change events.
+	// It's nil when the history is disabled.
+	History *store.History
+
+	// Evictor keeps the store within it's limits, it's told about changes of items with the change events.
+	// It's nil when the store isn't bounded.
+	Evictor *store.Evictor
 }
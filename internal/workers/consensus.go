@@ -0,0 +1,218 @@
+package workers
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/LukaGiorgadze/bloXroute/internal/models"
+	"github.com/LukaGiorgadze/bloXroute/internal/raft"
+)
+
+// REAP_ITEMS is the subject of the entries proposed by the reaper of the leader. Every node removes items
+// of the bucket expired at the Time of the entry and evicts items over the limits, at the same entry of the log.
+const REAP_ITEMS = "raft.reap"
+
+// consensusTimeout is the maximum time to wait until the proposed mutation is applied,
+// or until the node catches up with the leader before the read.
+const consensusTimeout = 5 * time.Second
+
+var ErrProposalTimeout = errors.New("Mutation wasn't committed in time.")
+
+// ConsensusOptions configure the node of the Raft cluster.
+type ConsensusOptions struct {
+	// Raft are the options of the node, it's OnLead and OnFollow are set by the Consensus.
+	Raft raft.Options
+	// OnLead is called when the node is elected and it applied all mutations of the previous leaders,
+	// so it starts receiving mutations. OnFollow is called when it's not the leader anymore.
+	OnLead   func() error
+	OnFollow func()
+}
+
+// Consensus replicates the store through the Raft log: the leader proposes every mutation of the clients
+// to the log and every node applies it to it's store once it's committed by the majority of the cluster.
+// The leader replies to the client (and acknowledges the message of the mutation log) after that,
+// so the acknowledged mutation survives the failure of any minority of the nodes.
+//
+// Every node applies the same mutations in the same order, with the time the leader received them,
+// so the stores of all nodes are the same. Bounded stores evict the same items on every node, since only mutations
+// count as the use of the item. Expired items are removed with the entries proposed by the reaper of the leader.
+// Watchers are notified by the node which proposed the mutation.
+type Consensus struct {
+	opts          ConsensusOptions
+	node          *raft.Node
+	workersConfig *WorkersConfig
+	snapshotter   *Snapshotter
+
+	// mutator applies the committed mutations, mutations of the clients are proposed by the other one.
+	mutator *OnceMutator
+
+	// pending are the mutations proposed by this node by the index of their entries.
+	pending     map[uint64]*proposal
+	pendingLock sync.Mutex
+}
+
+// proposal is the mutation proposed in the term, it's outcome is sent once it's entry is applied.
+type proposal struct {
+	term uint64
+	done chan proposalResult
+}
+
+type proposalResult struct {
+	resp *models.Response
+	err  error
+}
+
+// NewConsensus creates the node of the Raft cluster and sets it in the workers configuration.
+// The store is restored from the snapshot of the node, mutations of the log after it are applied
+// once the node finds out they are committed. The snapshotter is used to take and restore snapshots of the stores.
+func NewConsensus(opts ConsensusOptions, cfg *WorkersConfig, snapshotter *Snapshotter) (*Consensus, error) {
+	c := &Consensus{
+		opts:          opts,
+		workersConfig: cfg,
+		snapshotter:   snapshotter,
+		mutator:       NewOnceMutator(cfg),
+		pending:       make(map[uint64]*proposal),
+	}
+	cfg.Consensus = c
+
+	raftOptions := opts.Raft
+	raftOptions.OnLead = c.lead
+	raftOptions.OnFollow = c.follow
+	node, err := raft.New(raftOptions, c)
+	if err != nil {
+		cfg.Consensus = nil
+		return nil, err
+	}
+	c.node = node
+	return c, nil
+}
+
+// Start joins the cluster, it should be called once the mutator of the clients is running.
+func (c *Consensus) Start() error {
+	return c.node.Start()
+}
+
+// Stop leaves the cluster and closes the Raft log.
+func (c *Consensus) Stop() {
+	c.node.Stop()
+}
+
+// Leading reports whether the node is the leader which applied all mutations of the previous leaders.
+func (c *Consensus) Leading() bool {
+	return c.node.Leading()
+}
+
+// Barrier waits until the node applied all mutations committed before the read, see raft.Node.Barrier.
+func (c *Consensus) Barrier() error {
+	return c.node.Barrier(consensusTimeout)
+}
+
+func (c *Consensus) lead() {
+	log.Println("raft: leading the cluster")
+	if c.opts.OnLead != nil {
+		if err := c.opts.OnLead(); err != nil {
+			log.Println(err)
+		}
+	}
+}
+
+func (c *Consensus) follow() {
+	log.Println("raft: following the cluster")
+	if c.opts.OnFollow != nil {
+		c.opts.OnFollow()
+	}
+}
+
+// propose proposes the mutation of the client to the Raft log and waits until it's applied, it returns it's outcome.
+// The mutation is rejected if the node isn't the leader, the message of the mutation log isn't acknowledged then,
+// so it's delivered to the leader.
+func (c *Consensus) propose(item *models.Msg) (*models.Response, error) {
+	data, err := json.Marshal(item)
+	if err != nil {
+		return nil, err
+	}
+
+	// The entry may be applied right after it's proposed, so it's pending before that.
+	c.pendingLock.Lock()
+	index, term, err := c.node.Propose(data)
+	if err != nil {
+		c.pendingLock.Unlock()
+		return rejected(item, err)
+	}
+	p := &proposal{term: term, done: make(chan proposalResult, 1)}
+	c.pending[index] = p
+	c.pendingLock.Unlock()
+
+	timer := time.NewTimer(consensusTimeout)
+	defer timer.Stop()
+
+	select {
+	case result := <-p.done:
+		if result.err != nil {
+			return rejected(item, result.err)
+		}
+		return result.resp, nil
+	case <-timer.C:
+		c.pendingLock.Lock()
+		delete(c.pending, index)
+		c.pendingLock.Unlock()
+		return rejected(item, ErrProposalTimeout)
+	}
+}
+
+// rejected returns the error of the rejected mutation: the message of the mutation log isn't acknowledged,
+// so it's delivered again, other clients receive the error.
+func rejected(item *models.Msg, err error) (*models.Response, error) {
+	if item.Ack != nil {
+		return nil, err
+	}
+	return &models.Response{Error: err.Error()}, nil
+}
+
+// Apply applies the committed mutation to the store and sends the outcome to the proposer, if it's this node.
+// The entry of another term at the index of the proposal means the proposal was replaced by the next leader.
+// It implements raft.FSM.
+func (c *Consensus) Apply(entry raft.Entry) {
+	c.pendingLock.Lock()
+	p := c.pending[entry.Index]
+	delete(c.pending, entry.Index)
+	c.pendingLock.Unlock()
+	if p != nil && p.term != entry.Term {
+		p.done <- proposalResult{err: raft.ErrNotLeader}
+		p = nil
+	}
+
+	var item models.Msg
+	if err := json.Unmarshal(entry.Data, &item); err != nil {
+		log.Println("raft:", err)
+		if p != nil {
+			p.done <- proposalResult{err: err}
+		}
+		return
+	}
+	item.RaftIndex = entry.Index
+	item.Replayed = p == nil
+
+	resp, err := c.mutator.process(&item)
+	if err != nil {
+		log.Println("raft:", err)
+	}
+	if p != nil {
+		p.done <- proposalResult{resp: resp, err: err}
+	}
+}
+
+// Snapshot writes the stores of all buckets, it implements raft.FSM.
+func (c *Consensus) Snapshot(w io.Writer) error {
+	_, err := c.snapshotter.snapshot(w)
+	return err
+}
+
+// Restore replaces the stores of all buckets by the snapshot, it implements raft.FSM.
+func (c *Consensus) Restore(r io.Reader) error {
+	return c.snapshotter.replace(r)
+}
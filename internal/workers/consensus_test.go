@@ -0,0 +1,103 @@
+package workers
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/LukaGiorgadze/bloXroute/internal/models"
+	"github.com/LukaGiorgadze/bloXroute/internal/raft"
+	"github.com/LukaGiorgadze/bloXroute/internal/store"
+)
+
+// newTestConsensus returns the consensus of the node without the Raft node, entries are applied by the test.
+func newTestConsensus(t *testing.T) *Consensus {
+	t.Helper()
+	cfg := newTestConfig(t, store.OrderedMapType)
+	cfg.MsgClient = &testClient{bus: newTestBus()}
+	c := &Consensus{workersConfig: cfg, mutator: NewOnceMutator(cfg), pending: make(map[uint64]*proposal)}
+	cfg.Consensus = c
+	return c
+}
+
+func testEntry(t *testing.T, index, term uint64, item models.Msg) raft.Entry {
+	t.Helper()
+	data, err := json.Marshal(item)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return raft.Entry{Index: index, Term: term, Data: data}
+}
+
+func TestConsensusApply(t *testing.T) {
+	tests := []struct {
+		name string
+		// proposed is the term of the proposal at the index of the entry, 0 if this node didn't propose it.
+		proposed uint64
+		term     uint64
+		wantErr  error
+	}{
+		{name: "proposal", proposed: 2, term: 2},
+		{name: "proposal replaced by the next leader", proposed: 2, term: 3, wantErr: raft.ErrNotLeader},
+		{name: "entry of another node", term: 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newTestConsensus(t)
+			var p *proposal
+			if tt.proposed != 0 {
+				p = &proposal{term: tt.proposed, done: make(chan proposalResult, 1)}
+				c.pending[5] = p
+			}
+
+			item := models.Msg{Subject: ADD_ITEM, Item: models.Item{Key: "a", Value: models.StringValue("1")}, Time: time.Now().UnixNano()}
+			c.Apply(testEntry(t, 5, tt.term, item))
+
+			// The committed entry is applied, whoever proposed it.
+			if _, ok := c.workersConfig.Store.GetItem("a"); !ok {
+				t.Fatal("entry wasn't applied")
+			}
+			if len(c.pending) != 0 {
+				t.Fatalf("pending = %d", len(c.pending))
+			}
+			if p == nil {
+				return
+			}
+			result := <-p.done
+			if result.err != tt.wantErr {
+				t.Fatalf("error = %v, want %v", result.err, tt.wantErr)
+			}
+			if tt.wantErr == nil && (result.resp == nil || result.resp.Error != "") {
+				t.Fatalf("response = %+v", result.resp)
+			}
+		})
+	}
+}
+
+func TestConsensusReap(t *testing.T) {
+	now := time.Now().UnixNano()
+	nodes := []*Consensus{newTestConsensus(t), newTestConsensus(t)}
+
+	// Items are removed at the time of the reap entry, not when the node applies it.
+	for _, c := range nodes {
+		for j, key := range []string{"short", "long"} {
+			item := models.Msg{Subject: ADD_ITEM, Item: models.Item{Key: key, Value: models.StringValue("v"), ExpiresAt: now + int64(j+1)*int64(time.Second)}, Time: now}
+			c.Apply(testEntry(t, uint64(j+1), 1, item))
+		}
+		c.Apply(testEntry(t, 3, 1, models.Msg{Subject: REAP_ITEMS, Time: now + int64(1500*time.Millisecond)}))
+	}
+
+	for i, c := range nodes {
+		items := c.workersConfig.Store
+		if _, ok := items.GetItemAt("short", now); ok {
+			t.Fatalf("node %d: expired item wasn't removed", i)
+		}
+		if _, ok := items.GetItemAt("long", now); !ok {
+			t.Fatalf("node %d: item which expires later was removed", i)
+		}
+		if seq := c.workersConfig.MutationSeq.Load(); seq != 2 {
+			t.Fatalf("node %d: mutation seq = %d, reaps aren't numbered", i, seq)
+		}
+	}
+}
@@ -0,0 +1,110 @@
+package workers
+
+import (
+	"encoding/json"
+	"log"
+
+	"github.com/LukaGiorgadze/bloXroute/internal/client"
+	"github.com/LukaGiorgadze/bloXroute/internal/models"
+)
+
+const (
+	EVENT_ADD    = "add"
+	EVENT_UPDATE = "update"
+	EVENT_DELETE = "delete"
+	EVENT_EXPIRE = "expire"
+	EVENT_EVICT  = "evict"
+)
+
+// newEvent compares the item before and after the mutation made at the given time and returns the change event.
+// Nothing is returned if the item didn't change, e.g. the mutation failed.
+// The event is recorded in the history of the item and tracked by the evictor, if they are enabled.
+// It should be called while the store is locked, so the events sequence follows the order of mutations.
+func newEvent(cfg *WorkersConfig, b *Bucket, key string, at int64, before models.Item, existed bool, after models.Item, exists bool) (models.Event, bool) {
+	event := models.Event{Bucket: b.Spec.Name, Key: key, Time: at}
+
+	switch {
+	case !existed && exists:
+		event.Op = EVENT_ADD
+	case existed && !exists:
+		event.Op = EVENT_DELETE
+	case existed && exists && (before.Revision != after.Revision || before.Value != after.Value):
+		event.Op = EVENT_UPDATE
+	default:
+		return event, false
+	}
+
+	if existed {
+		event.OldValue = before.Value
+	}
+	if exists {
+		event.NewValue = after.Value
+		event.Revision = after.Revision
+	}
+
+	event.Seq = cfg.EventSeq.Add(1)
+	record(b, event)
+	track(b, event)
+
+	return event, true
+}
+
+// newExpireEvent returns the event of the item removed by the reaper at the given time.
+// It should be called while the store is locked.
+func newExpireEvent(cfg *WorkersConfig, b *Bucket, key string, at int64) models.Event {
+	return newRemoveEvent(cfg, b, EVENT_EXPIRE, key, at)
+}
+
+// newEvictEvent returns the event of the item evicted from the bounded store at the given time.
+// It should be called while the store is locked.
+func newEvictEvent(cfg *WorkersConfig, b *Bucket, key string, at int64) models.Event {
+	return newRemoveEvent(cfg, b, EVENT_EVICT, key, at)
+}
+
+func newRemoveEvent(cfg *WorkersConfig, b *Bucket, op string, key string, at int64) models.Event {
+	event := models.Event{Bucket: b.Spec.Name, Op: op, Key: key, Seq: cfg.EventSeq.Add(1), Time: at}
+	record(b, event)
+	track(b, event)
+	return event
+}
+
+// record appends the event to the history of the item, if the history is enabled.
+func record(b *Bucket, event models.Event) {
+	if b.History == nil {
+		return
+	}
+	b.History.Record(event)
+}
+
+// track tells the evictor about the change of the item, if the store is bounded.
+func track(b *Bucket, event models.Event) {
+	if b.Evictor == nil {
+		return
+	}
+	switch event.Op {
+	case EVENT_ADD, EVENT_UPDATE:
+		b.Evictor.Set(event.Key, string(event.NewValue))
+	default:
+		b.Evictor.Remove(event.Key)
+	}
+}
+
+// publishEvents publishes events on the subjects of their keys in their buckets.
+// Lanes of the mutator publish events of their items after they are unlocked, so only the events of the same item
+// are published in the order of their sequences.
+func publishEvents(cfg *WorkersConfig, events []models.Event) {
+	if cfg.MsgClient == nil {
+		return
+	}
+
+	for _, event := range events {
+		data, err := json.Marshal(event)
+		if err != nil {
+			log.Println(err)
+			continue
+		}
+		if err := cfg.MsgClient.Publish(client.BucketSubject(event.Bucket, client.ItemEventsKeySubject(event.Key)), data); err != nil {
+			log.Println(err)
+		}
+	}
+}
@@ -0,0 +1,29 @@
+package workers
+
+import (
+	"github.com/LukaGiorgadze/bloXroute/internal/models"
+)
+
+// evict removes items chosen by the evictor of the bucket at the given time until it's store is within
+// the limits and returns their events. Nothing is evicted if the bucket isn't bounded.
+// Items of any shard can be evicted, so it should be called while the store is locked exclusively.
+func evict(cfg *WorkersConfig, b *Bucket, at int64) (events []models.Event) {
+	if b.Evictor == nil {
+		return
+	}
+
+	for b.Evictor.Over() {
+		key, ok := b.Evictor.Victim(b.Store)
+		if !ok {
+			return
+		}
+		if !b.Store.Remove(key) {
+			// The evictor shouldn't track items which are not in the store, but it must not choose them again.
+			b.Evictor.Remove(key)
+			continue
+		}
+		b.Evictor.Evicted()
+		events = append(events, newEvictEvent(cfg, b, key, at))
+	}
+	return
+}
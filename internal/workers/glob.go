@@ -0,0 +1,125 @@
+package workers
+
+import "strings"
+
+// matchGlob reports whether the whole key matches the glob pattern.
+// `*` matches any sequence of characters (including the empty one), `?` matches any single character,
+// `[...]` matches any character of the class (e.g. `[abc]`, `[a-z]`, `[!0-9]` for the ones not in it)
+// and `\` escapes the next character, so it's matched literally. `[` without the closing `]` is matched literally.
+func matchGlob(pattern, key string) bool {
+	p, k := []rune(pattern), []rune(key)
+	pi, ki := 0, 0
+	// Position of the last `*` in the pattern and the position in the key it was matched from.
+	star, mark := -1, 0
+
+	for ki < len(k) {
+		if pi < len(p) {
+			switch c := p[pi]; {
+			case c == '*':
+				star, mark = pi, ki
+				pi++
+				continue
+			case c == '?':
+				pi++
+				ki++
+				continue
+			case c == '[':
+				matched, next, ok := matchClass(p, pi, k[ki])
+				if !ok {
+					// The class isn't closed, so `[` is matched literally.
+					matched, next = c == k[ki], pi+1
+				}
+				if matched {
+					pi = next
+					ki++
+					continue
+				}
+			case c == '\\' && pi+1 < len(p):
+				if p[pi+1] == k[ki] {
+					pi += 2
+					ki++
+					continue
+				}
+			case c == k[ki]:
+				pi++
+				ki++
+				continue
+			}
+		}
+
+		// Mismatch, let the last `*` match one more character.
+		if star < 0 {
+			return false
+		}
+		mark++
+		pi, ki = star+1, mark
+	}
+
+	for pi < len(p) && p[pi] == '*' {
+		pi++
+	}
+	return pi == len(p)
+}
+
+// matchClass matches the character with the class which starts at p[start] (`[`).
+// It returns the position after the class, ok is false if the class isn't closed.
+func matchClass(p []rune, start int, c rune) (matched bool, next int, ok bool) {
+	i := start + 1
+	negated := i < len(p) && (p[i] == '!' || p[i] == '^')
+	if negated {
+		i++
+	}
+
+	for first := true; i < len(p); first = false {
+		// `]` right after the opening (or the negation) is the member of the class.
+		if p[i] == ']' && !first {
+			return matched != negated, i + 1, true
+		}
+		lo, n := classChar(p, i)
+		if n == 0 {
+			return false, 0, false
+		}
+		i += n
+		hi := lo
+		if i+1 < len(p) && p[i] == '-' && p[i+1] != ']' {
+			if hi, n = classChar(p, i+1); n == 0 {
+				return false, 0, false
+			}
+			i += 1 + n
+		}
+		if lo <= c && c <= hi {
+			matched = true
+		}
+	}
+	return false, 0, false
+}
+
+// classChar returns the character of the class at p[i] and the number of runes it takes, 0 if the pattern ends.
+func classChar(p []rune, i int) (rune, int) {
+	if p[i] == '\\' {
+		if i+1 < len(p) {
+			return p[i+1], 2
+		}
+		return 0, 0
+	}
+	return p[i], 1
+}
+
+// globPrefix returns the literal beginning of the pattern, every matching key starts with it.
+func globPrefix(pattern string) string {
+	var sb strings.Builder
+	escaped := false
+	for _, c := range pattern {
+		switch {
+		case escaped:
+			escaped = false
+		case c == '\\':
+			escaped = true
+			continue
+		case c == '*' || c == '?' || c == '[':
+			return sb.String()
+		}
+		sb.WriteRune(c)
+	}
+	return sb.String()
+}
@@ -0,0 +1,142 @@
+package workers
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/LukaGiorgadze/bloXroute/internal/models"
+	"github.com/LukaGiorgadze/bloXroute/internal/store"
+)
+
+func TestMatchGlob(t *testing.T) {
+	tests := []struct {
+		pattern string
+		key     string
+		want    bool
+	}{
+		{pattern: "", key: "", want: true},
+		{pattern: "", key: "a"},
+		{pattern: "user:1", key: "user:1", want: true},
+		{pattern: "user:1", key: "user:10"},
+
+		// `*` matches any sequence, including the empty one.
+		{pattern: "*", key: "", want: true},
+		{pattern: "*", key: "user:1:profile", want: true},
+		{pattern: "user:*:profile", key: "user:123:profile", want: true},
+		{pattern: "user:*:profile", key: "user::profile", want: true},
+		{pattern: "user:*:profile", key: "user:1:2:profile", want: true},
+		{pattern: "user:*:profile", key: "user:1:settings"},
+		{pattern: "*:profile", key: "user:1:profile:old"},
+		{pattern: "a*b*c", key: "abxbc", want: true},
+		{pattern: "a*b*c", key: "acb"},
+		{pattern: "**", key: "abc", want: true},
+
+		// `?` matches a single character.
+		{pattern: "user:?", key: "user:1", want: true},
+		{pattern: "user:?", key: "user:"},
+		{pattern: "user:?", key: "user:12"},
+		{pattern: "?", key: "ł", want: true},
+
+		// Character classes.
+		{pattern: "user:[123]", key: "user:2", want: true},
+		{pattern: "user:[123]", key: "user:4"},
+		{pattern: "user:[0-9]", key: "user:7", want: true},
+		{pattern: "user:[0-9]", key: "user:a"},
+		{pattern: "user:[a-cx]", key: "user:x", want: true},
+		{pattern: "user:[!0-9]", key: "user:a", want: true},
+		{pattern: "user:[!0-9]", key: "user:7"},
+		{pattern: "user:[^0-9]", key: "user:7"},
+		{pattern: "[]]", key: "]", want: true},
+		{pattern: "[!]]", key: "]"},
+		{pattern: "[a-]", key: "-", want: true},
+		{pattern: "[\\]]", key: "]", want: true},
+		{pattern: "[0-9]*", key: "1abc", want: true},
+		{pattern: "*[0-9]", key: "abc1", want: true},
+		{pattern: "*[0-9]", key: "abc"},
+		// The class which isn't closed is matched literally.
+		{pattern: "user:[1", key: "user:[1", want: true},
+		{pattern: "user:[1", key: "user:1"},
+
+		// Escaped metacharacters are matched literally.
+		{pattern: "a\\*", key: "a*", want: true},
+		{pattern: "a\\*", key: "ab"},
+		{pattern: "a\\?", key: "a?", want: true},
+		{pattern: "a\\?", key: "ab"},
+		{pattern: "a\\[1]", key: "a[1]", want: true},
+		{pattern: "a\\[1]", key: "a1"},
+		{pattern: "a\\\\", key: "a\\", want: true},
+		{pattern: "a\\", key: "a\\", want: true},
+	}
+
+	for _, tt := range tests {
+		if got := matchGlob(tt.pattern, tt.key); got != tt.want {
+			t.Errorf("matchGlob(%q, %q) = %v, want %v", tt.pattern, tt.key, got, tt.want)
+		}
+	}
+}
+
+func TestGlobPrefix(t *testing.T) {
+	tests := []struct {
+		pattern string
+		want    string
+	}{
+		{pattern: "user:1", want: "user:1"},
+		{pattern: "user:*:profile", want: "user:"},
+		{pattern: "user:?", want: "user:"},
+		{pattern: "user:[0-9]", want: "user:"},
+		{pattern: "user\\*:*", want: "user*:"},
+		{pattern: "a\\[1]", want: "a[1]"},
+		// Patterns which start with the metacharacter have no literal prefix, all keys are checked.
+		{pattern: "", want: ""},
+		{pattern: "*:profile", want: ""},
+		{pattern: "?:profile", want: ""},
+		{pattern: "[a-z]:profile", want: ""},
+	}
+
+	for _, tt := range tests {
+		if got := globPrefix(tt.pattern); got != tt.want {
+			t.Errorf("globPrefix(%q) = %q, want %q", tt.pattern, got, tt.want)
+		}
+	}
+}
+
+func TestScanPattern(t *testing.T) {
+	tests := []struct {
+		name    string
+		prefix  string
+		pattern string
+		lexical bool
+		want    []string
+	}{
+		{name: "literal prefix", pattern: "user:*:profile", want: []string{"user:2:profile", "user:1:profile"}},
+		{name: "empty literal prefix", pattern: "*:profile", want: []string{"user:2:profile", "user:1:profile", "team:1:profile"}},
+		{name: "empty literal prefix in lexical order", pattern: "*:profile", lexical: true, want: []string{"team:1:profile", "user:1:profile", "user:2:profile"}},
+		{name: "class", pattern: "[tu]*:1:*", lexical: true, want: []string{"team:1:profile", "user:1:profile", "user:1:settings"}},
+		{name: "prefix with the pattern", prefix: "user:", pattern: "?*:profile", want: []string{"user:2:profile", "user:1:profile"}},
+		{name: "prefix which doesn't match the pattern", prefix: "team:", pattern: "user:*", want: []string{}},
+	}
+
+	cfg := newTestConfig(t, store.OrderedMapType)
+	for _, key := range []string{"user:2:profile", "user:1:profile", "team:1:profile", "user:1:settings"} {
+		if resp := mutate(cfg, models.Msg{Subject: ADD_ITEM, Item: models.Item{Key: key, Value: models.StringValue("v")}}); resp.Error != "" {
+			t.Fatal(resp.Error)
+		}
+	}
+	s := NewSemaphoreReader(1, 100, cfg)
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			items, _, err := s.scan(cfg.Store, &models.Msg{Prefix: tt.prefix, Pattern: tt.pattern, Lexical: tt.lexical}, 100)
+			if err != nil {
+				t.Fatal(err)
+			}
+			keys := []string{}
+			for _, item := range items {
+				keys = append(keys, item.Key)
+			}
+			if !reflect.DeepEqual(keys, tt.want) {
+				t.Fatalf("keys = %v, want %v", keys, tt.want)
+			}
+		})
+	}
+}
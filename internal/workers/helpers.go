@@ -0,0 +1,27 @@
+package workers
+
+import (
+	"encoding/json"
+	"log"
+
+	"github.com/LukaGiorgadze/bloXroute/internal/client"
+	"github.com/LukaGiorgadze/bloXroute/internal/models"
+)
+
+// respond sends the response to the reply subject of the request.
+// Messages which were published without waiting for a response have an empty reply subject, so nothing is sent.
+func respond(cfg *WorkersConfig, reply string, resp models.Response) {
+	if reply == "" || cfg.MsgClient == nil {
+		return
+	}
+
+	data, err := json.Marshal(resp)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+
+	if err := cfg.MsgClient.Publish(client.Subject(reply), data); err != nil {
+		log.Println(err)
+	}
+}
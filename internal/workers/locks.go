@@ -0,0 +1,82 @@
+package workers
+
+import (
+	"errors"
+
+	"github.com/LukaGiorgadze/bloXroute/internal/store"
+)
+
+var ErrVersionsNotSupported = errors.New("Store doesn't keep versions.")
+
+// lockItem locks the store for an operation on the single item and returns the function which unlocks it.
+// Sharded stores are locked only by the shard of the item, while the store lock is shared with operations
+// on other shards. Other stores are locked entirely: exclusively to write and shared to read.
+func lockItem(s store.IStore, key string, write bool) (unlock func()) {
+	sharded, ok := s.(store.IShardedStore)
+	if !ok {
+		if write {
+			s.Lock().Lock()
+			return s.Lock().Unlock
+		}
+		s.Lock().RLock()
+		return s.Lock().RUnlock
+	}
+
+	s.Lock().RLock()
+	keyLock := sharded.KeyLock(key)
+	if write {
+		keyLock.Lock()
+		return func() {
+			keyLock.Unlock()
+			s.Lock().RUnlock()
+		}
+	}
+	keyLock.RLock()
+	return func() {
+		keyLock.RUnlock()
+		s.Lock().RUnlock()
+	}
+}
+
+// lockConsistent locks the whole store for reading, so it's state is consistent with the mutation counters
+// of the WorkersConfig, and returns the function which unlocks it.
+// Sharded stores are changed while their lock is shared, so it's taken exclusively for them.
+func lockConsistent(s store.IStore) (unlock func()) {
+	if _, ok := s.(store.IShardedStore); ok {
+		s.Lock().Lock()
+		return s.Lock().Unlock
+	}
+	s.Lock().RLock()
+	return s.Lock().RUnlock
+}
+
+// readStore returns the reader of the store for the request and the function which releases it.
+// Versioned stores are read from the committed version (the one with the sequence asOf, if it's set)
+// without locking, the sequence of the version is returned with it. Other stores are locked for reading:
+// only by the shard of the item, if the key is given, or entirely.
+func readStore(s store.IStore, asOf uint64, key string) (reader store.IReader, seq uint64, release func(), err error) {
+	if versioned, ok := s.(store.IVersionedStore); ok {
+		version, err := versioned.View(asOf)
+		if err != nil {
+			return nil, 0, nil, err
+		}
+		return version, version.Seq(), func() {}, nil
+	}
+
+	if asOf != 0 {
+		return nil, 0, nil, ErrVersionsNotSupported
+	}
+	if key != "" {
+		return s, 0, lockItem(s, key, false), nil
+	}
+	s.Lock().RLock()
+	return s, 0, s.Lock().RUnlock, nil
+}
+
+// commit makes changes of the versioned store visible to the readers, which don't lock it.
+// It should be called once the mutation is applied, before the store is unlocked.
+func commit(s store.IStore) {
+	if versioned, ok := s.(store.IVersionedStore); ok {
+		versioned.Commit()
+	}
+}
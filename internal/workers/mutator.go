@@ -1,23 +1,53 @@
 package workers
 
 import (
+	"encoding/json"
+	"errors"
+	"log"
+	"math"
+	"strconv"
+
 	"github.com/LukaGiorgadze/bloXroute/internal/client"
 	"github.com/LukaGiorgadze/bloXroute/internal/models"
+	"github.com/LukaGiorgadze/bloXroute/internal/store"
 )
 
 const (
 	ADD_ITEM    = string(client.ItemMutateAddSubject)
 	DELETE_ITEM = string(client.ItemMutateDeleteSubject)
+	CAS_ITEM    = string(client.ItemMutateCasSubject)
+	UPDATE_ITEM = string(client.ItemMutateUpdateSubject)
+	BATCH_ITEM  = string(client.ItemMutateBatchSubject)
+	INCR_ITEM   = string(client.ItemMutateIncrSubject)
+	DECR_ITEM   = string(client.ItemMutateDecrSubject)
+)
+
+var (
+	ErrItemExists       = errors.New("Item already exists.")
+	ErrRevisionMismatch = errors.New("Item revision doesn't match.")
+	ErrNotCounter       = errors.New("Item value is not an integer.")
+	ErrCounterOverflow  = errors.New("Counter overflow.")
+	ErrItemTooLarge     = errors.New("Item is larger than the size limit of the store.")
+	// ErrSeqMismatch and ErrDuplicateMutation reject mutations with the sequences given by the client, see checkSequence.
+	ErrSeqMismatch       = errors.New("Mutation sequence doesn't match.")
+	ErrDuplicateMutation = errors.New("Mutation was already applied.")
 )
 
 // Once is a struct that represents a single worker that can process one item at a time.
+// It's the lane of the MutatorPool, which processes mutations of many keys in parallel (see pool.go).
 type OnceMutator struct {
 
-	// Queue is a channel that is used to send messages to the worker.
+	// Queue is a channel that is used to send messages to the worker of the lane.
 	Queue chan *models.Msg
 
 	// workersConfig is a pointer to a WorkersConfig struct that is shared among all workers.
 	workersConfig *WorkersConfig
+
+	// events collects change events of the mutation being applied, they are published once it's done.
+	events []models.Event
+
+	// bucket is the bucket of the mutation being applied.
+	bucket *Bucket
 }
 
 func NewOnceMutator(cfg *WorkersConfig) *OnceMutator {
@@ -27,25 +57,456 @@ func NewOnceMutator(cfg *WorkersConfig) *OnceMutator {
 	}
 }
 
-// MutatorWorker is a function that listens for messages on the Queue channel and performs mutations on the workersConfig store.
+// handle performs the mutation of the message on the workersConfig store.
 // If the subject is ADD_ITEM, it adds the map item to the workersConfig store.
 // If the subject is DELETE_ITEM, it removes the map item from the workersConfig store.
-// The function is designed to run indefinitely, waiting for messages on the Queue channel.
-func (o *OnceMutator) MutatorWorker() {
-	for {
-		item := <-o.Queue
+// If the subject is CAS_ITEM, it adds or updates the item only if it's revision matches.
+// If the subject is UPDATE_ITEM, it updates the item or adds it if it doesn't exist.
+// If the subject is INCR_ITEM or DECR_ITEM, it adds to or subtracts from the integer value of the item.
+// If the subject is BATCH_ITEM, it applies all operations of the batch or none of them.
+// If the subject is CREATE_BUCKET or DELETE_BUCKET, it creates or deletes the named bucket.
+// Items are mutated in the bucket of the message, the default one if it's not set.
+// The outcome is sent back to the client if it waits for the response.
+// Messages delivered from the mutation log are acknowledged after the mutation is applied,
+// so they are redelivered if the server stops before that.
+func (o *OnceMutator) handle(item *models.Msg) {
+	resp, err := o.process(item)
+	if err != nil {
+		// The message isn't acknowledged, so the mutation log delivers it again.
+		log.Println(err)
+		return
+	}
+	if resp != nil {
+		respond(o.workersConfig, item.Reply, *resp)
+	}
+
+	if item.Ack != nil {
+		if err := item.Ack(); err != nil {
+			log.Println(err)
+		}
+	}
+}
+
+// ReplayWAL applies mutations from the write-ahead log which are not in the store yet,
+// e.g. after the snapshot was loaded. It should be called on startup, before MutatorWorker receives messages.
+// It returns the number of replayed mutations.
+func (o *OnceMutator) ReplayWAL() (n int, err error) {
+	if o.workersConfig.WAL == nil {
+		return
+	}
+
+	// The follower doesn't evict items itself, it removes them with the records of the leader.
+	following, release := o.workersConfig.holdRole()
+	defer release()
+
+	err = o.workersConfig.WAL.Replay(o.workersConfig.AppliedIndex+1, func(index uint64, data []byte) error {
+		var item models.Msg
+		if err := json.Unmarshal(data, &item); err != nil {
+			return err
+		}
+
+		b, locked, unlock := o.lock(&item)
+		resp := o.apply(b, &item)
+		o.number(&item, &resp)
+		if o.workersConfig.Replicator != nil {
+			o.workersConfig.Replicator.append(&item)
+		}
+		commit(locked)
+		o.workersConfig.markIndex(index)
+		unlock()
+
+		// Items of other shards may be evicted, so the whole store is locked for it, like in process.
+		if !following && b != nil && b.Evictor != nil && b.Evictor.Over() {
+			b.Store.Lock().Lock()
+			o.workersConfig.replicateRemovals(evict(o.workersConfig, b, item.Time))
+			commit(b.Store)
+			b.Store.Lock().Unlock()
+		}
+
+		// Watchers were notified when the mutation was applied for the first time.
+		o.events = nil
+
+		n++
+		return nil
+	})
+
+	return
+}
+
+// process appends the mutation to the write-ahead log (if it's enabled) and then applies it to the store.
+// If the store exceeds it's limits after that, items are evicted from it.
+// It returns the outcome of the mutation, which is sent to the client.
+// Messages which were already applied (e.g. redelivered by the mutation log) are skipped, nil is returned for them.
+// With the replication, the follower applies only the records of the leader (see replication.go),
+// mutations of the clients are rejected before anything is changed.
+// With the Raft cluster, mutations of the clients are proposed to the Raft log and applied (by every node)
+// once they are committed, see consensus.go.
+func (o *OnceMutator) process(item *models.Msg) (*models.Response, error) {
+
+	committed := item.RaftIndex != 0
+	if o.workersConfig.Consensus != nil && !committed {
+		return o.workersConfig.Consensus.propose(item)
+	}
+
+	// The role doesn't change until the mutation is applied.
+	following, release := o.workersConfig.holdRole()
+	defer release()
+
+	record := item.ReplicationSeq != 0
+	switch {
+	case committed:
+	case following && !record && item.Ack != nil:
+		// The message isn't acknowledged, so it's delivered to the leader.
+		return nil, ErrNotLeader
+	case following && !record:
+		return &models.Response{Error: ErrNotLeader.Error()}, nil
+	case !following && record:
+		// Records of the previous leader received after the promotion.
+		return nil, nil
+	}
+
+	// Messages of the mutation log are delivered to the same lane as their earlier deliveries,
+	// so the message isn't applied by the other lane after it's checked.
+	if !record && item.StreamSeq != 0 && o.workersConfig.seqApplied(item.StreamSeq) {
+		return nil, nil
+	}
+
+	// Records of the leader passed the checks when the leader applied them.
+	if !record {
+		if err := o.workersConfig.checkSequence(item); err != nil {
+			return &models.Response{Error: err.Error(), MutationSeq: o.workersConfig.MutationSeq.Load()}, nil
+		}
+	}
 
-		switch item.Subject {
-		case ADD_ITEM:
-			o.workersConfig.Store.Lock().Lock()
-			_ = o.workersConfig.Store.Add(item.Key, item.Value)
-			o.workersConfig.Store.Lock().Unlock()
+	// The mutation is appended to the write-ahead log and numbered while it's item is locked,
+	// so the snapshot never contains the later mutation without the earlier one of the other lane.
+	// Lanes append and number mutations one at a time then, so they are numbered in the order of the write-ahead log
+	// and get the same sequences when it's replayed.
+	b, locked, unlock := o.lock(item)
+	var index uint64
+	if o.workersConfig.WAL != nil {
+		o.workersConfig.walLock.Lock()
+		data, err := json.Marshal(item)
+		if err == nil {
+			index, err = o.workersConfig.WAL.Append(data)
+		}
+		if err != nil {
+			o.workersConfig.walLock.Unlock()
+			unlock()
+			return nil, err
+		}
+	}
+
+	resp := o.apply(b, item)
+	o.number(item, &resp)
+	if o.workersConfig.WAL != nil {
+		o.workersConfig.walLock.Unlock()
+	}
+	if o.workersConfig.Replicator != nil {
+		o.workersConfig.Replicator.append(item)
+	}
+	commit(locked)
+	if index != 0 {
+		o.workersConfig.markIndex(index)
+	}
+	unlock()
 
-		case DELETE_ITEM:
-			o.workersConfig.Store.Lock().Lock()
-			_ = o.workersConfig.Store.Remove(item.Key)
-			o.workersConfig.Store.Lock().Unlock()
+	// Items of other shards may be evicted, so the whole store is locked for it.
+	// The follower removes items evicted by the leader with it's records.
+	if !following && b != nil && b.Evictor != nil && b.Evictor.Over() {
+		b.Store.Lock().Lock()
+		evicted := evict(o.workersConfig, b, item.Time)
+		o.workersConfig.replicateRemovals(evicted)
+		o.events = append(o.events, evicted...)
+		commit(b.Store)
+		b.Store.Lock().Unlock()
+	}
+
+	// Watchers were notified when the replayed mutation was applied for the first time,
+	// or by the leader for it's records.
+	if !item.Replayed {
+		publishEvents(o.workersConfig, o.events)
+	}
+	o.events = nil
+
+	return &resp, nil
+}
+
+// number numbers the applied mutation (see sequence) and gives it's sequence to the items it changed.
+// Mutations which failed aren't numbered, the response has the sequence of the last applied mutation then.
+// It should be called right after the mutation is applied, while the store is locked.
+func (o *OnceMutator) number(item *models.Msg, resp *models.Response) {
+	if resp.Error != "" {
+		resp.MutationSeq = o.workersConfig.MutationSeq.Load()
+		return
+	}
+	o.workersConfig.sequence(item)
+	resp.MutationSeq = item.Seq
+	if item.Seq == 0 {
+		return
+	}
 
+	// The item keeps the sequence of the mutation which changed it.
+	for _, event := range o.events {
+		if event.Op == EVENT_ADD || event.Op == EVENT_UPDATE {
+			_ = o.bucket.Store.SetSeq(event.Key, item.Seq)
 		}
 	}
+	for i := range resp.Items {
+		resp.Items[i].Seq = item.Seq
+	}
+}
+
+// lock returns the bucket of the mutation (nil if it doesn't exist) and the locked store.
+// The batch (and the reap of the Raft leader) changes many items, so it locks the whole store of the bucket.
+// Bucket admin messages and mutations of missing buckets don't change items, but the default store is locked for them,
+// so the applied sequences are changed consistently.
+func (o *OnceMutator) lock(item *models.Msg) (b *Bucket, locked store.IStore, unlock func()) {
+	b, ok := o.workersConfig.bucket(item.Bucket)
+
+	switch {
+	case !ok || item.Subject == CREATE_BUCKET || item.Subject == DELETE_BUCKET:
+		if !ok {
+			b = nil
+		}
+		locked = o.workersConfig.Store
+		locked.Lock().Lock()
+		return b, locked, locked.Lock().Unlock
+
+	case item.Subject == BATCH_ITEM || item.Subject == REAP_ITEMS:
+		b.Store.Lock().Lock()
+		return b, b.Store, b.Store.Lock().Unlock
+	}
+
+	return b, b.Store, lockItem(b.Store, item.Key, true)
+}
+
+// apply performs the mutation on the store of the bucket and remembers the mutation log sequence of the message.
+// The returned response contains the item after the mutation, or the error if the mutation wasn't applied.
+// The change event is collected if the item has changed.
+// It should be called while the store is locked (see lock).
+func (o *OnceMutator) apply(b *Bucket, item *models.Msg) (resp models.Response) {
+	if item.StreamSeq != 0 {
+		o.workersConfig.markApplied(item.StreamSeq)
+	}
+
+	switch {
+	case item.Subject == CREATE_BUCKET || item.Subject == DELETE_BUCKET:
+		return o.applyBucket(item)
+	case b == nil:
+		resp.Error = ErrBucketNotFound.Error()
+		return
+	}
+
+	o.bucket = b
+	store := b.Store
+
+	// Every operation of the batch is applied (and collects it's event) separately.
+	if item.Subject == BATCH_ITEM {
+		return o.applyBatch(item)
+	}
+
+	if item.Subject == REAP_ITEMS {
+		o.events = append(o.events, expire(o.workersConfig, b, item.Time)...)
+		return
+	}
+
+	// Items expired or evicted by the leader are removed with the same event.
+	if item.Subject == REMOVE_ITEM {
+		if store.Remove(item.Key) {
+			if item.Op == EVENT_EVICT && b.Evictor != nil {
+				b.Evictor.Evicted()
+			}
+			o.events = append(o.events, newRemoveEvent(o.workersConfig, b, item.Op, item.Key, item.Time))
+		}
+		return
+	}
+
+	return o.applyItem(item)
+}
+
+// applyItem performs the mutation of the single item on the store of the bucket, e.g. the operation of the batch.
+// It should be called while the store is locked (see lock).
+func (o *OnceMutator) applyItem(item *models.Msg) (resp models.Response) {
+	b := o.bucket
+	store := b.Store
+
+	// The item larger than the size limit would be evicted right after it's added, so it's rejected.
+	switch item.Subject {
+	case ADD_ITEM, UPDATE_ITEM, CAS_ITEM:
+		if b.Evictor != nil && !b.Evictor.Fits(item.Key, string(item.Value)) {
+			resp.Error = ErrItemTooLarge.Error()
+			return
+		}
+	}
+
+	before, existed := store.GetItemAt(item.Key, item.Time)
+
+	switch item.Subject {
+	case ADD_ITEM:
+		if !store.Add(item.Key, string(item.Value), item.Time) {
+			resp.Error = ErrItemExists.Error()
+			break
+		}
+		if item.ExpiresAt != 0 {
+			_ = store.Expire(item.Key, item.ExpiresAt)
+		}
+
+	case DELETE_ITEM:
+		// Delete is conditional when the revision is set.
+		if item.Revision != 0 {
+			if !existed {
+				resp.Error = ErrItemNotFound.Error()
+				break
+			}
+			if before.Revision != item.Revision {
+				resp.Error = ErrRevisionMismatch.Error()
+				resp.Items = []models.Item{before}
+				break
+			}
+		}
+		if !store.Remove(item.Key) {
+			resp.Error = ErrItemNotFound.Error()
+		}
+
+	case CAS_ITEM:
+		resp = o.compareAndSwap(item)
+
+	case UPDATE_ITEM:
+		o.upsert(item)
+
+	case INCR_ITEM, DECR_ITEM:
+		resp = o.increment(item)
+
+	}
+
+	after, exists := store.GetItemAt(item.Key, item.Time)
+	if event, ok := newEvent(o.workersConfig, b, item.Key, item.Time, before, existed, after, exists); ok {
+		o.events = append(o.events, event)
+	}
+
+	if resp.Error == "" && exists {
+		resp.Items = []models.Item{after}
+	}
+
+	return
+}
+
+// upsert updates the value of the existing item or adds it, if it doesn't exist.
+// The updated item keeps it's position, unless the Tail is set.
+// Expiration of the existing item is changed only when the new TTL is given.
+func (o *OnceMutator) upsert(item *models.Msg) {
+	store := o.bucket.Store
+
+	if store.Update(item.Key, string(item.Value), item.Time) {
+		if item.Tail {
+			_ = store.MoveToBack(item.Key)
+		}
+	} else {
+		_ = store.Add(item.Key, string(item.Value), item.Time)
+	}
+
+	if item.ExpiresAt != 0 {
+		_ = store.Expire(item.Key, item.ExpiresAt)
+	}
+}
+
+// increment adds the amount (By) to the integer value of the item, or subtracts it for DECR_ITEM,
+// so counters are changed atomically without reading them first. The missing item is created with the value 0 before that.
+// The item keeps it's position, it's expiration is changed only when the new TTL is given.
+// The current item is returned when it's value is not an integer or the result overflows.
+func (o *OnceMutator) increment(item *models.Msg) (resp models.Response) {
+	store := o.bucket.Store
+
+	by := item.By
+	if by == 0 {
+		by = 1
+	}
+	if item.Subject == DECR_ITEM {
+		if by == math.MinInt64 {
+			resp.Error = ErrCounterOverflow.Error()
+			return
+		}
+		by = -by
+	}
+
+	var n int64
+	current, ok := store.GetItemAt(item.Key, item.Time)
+	if ok {
+		var err error
+		if n, err = counter(current.Value); err != nil {
+			resp.Error = ErrNotCounter.Error()
+			resp.Items = []models.Item{current}
+			return
+		}
+	}
+	if (by > 0 && n > math.MaxInt64-by) || (by < 0 && n < math.MinInt64-by) {
+		resp.Error = ErrCounterOverflow.Error()
+		resp.Items = []models.Item{current}
+		return
+	}
+
+	value := strconv.FormatInt(n+by, 10)
+	if ok {
+		_ = store.Update(item.Key, value, item.Time)
+	} else {
+		_ = store.Add(item.Key, value, item.Time)
+	}
+
+	if item.ExpiresAt != 0 {
+		_ = store.Expire(item.Key, item.ExpiresAt)
+	}
+	return
+}
+
+// counter returns the integer of the counter value. Values added as plain strings (e.g. by `add -v 5`)
+// are counters as well, the changed counter is kept as the JSON number.
+func counter(value models.Value) (n int64, err error) {
+	if err = json.Unmarshal([]byte(value), &n); err == nil {
+		return
+	}
+	var s string
+	if json.Unmarshal([]byte(value), &s) != nil {
+		return 0, err
+	}
+	return strconv.ParseInt(s, 10, 64)
+}
+
+// compareAndSwap creates the item only if it doesn't exist when the revision is 0,
+// otherwise it updates the item only if it's current revision equals to the given one.
+// The current item is returned when the revision doesn't match, so the client can retry with it.
+func (o *OnceMutator) compareAndSwap(item *models.Msg) (resp models.Response) {
+	store := o.bucket.Store
+
+	current, ok := store.GetItemAt(item.Key, item.Time)
+
+	switch {
+	case item.Revision == 0 && ok:
+		resp.Error = ErrItemExists.Error()
+		resp.Items = []models.Item{current}
+		return
+
+	case item.Revision == 0:
+		_ = store.Add(item.Key, string(item.Value), item.Time)
+
+	case !ok:
+		resp.Error = ErrItemNotFound.Error()
+		return
+
+	case current.Revision != item.Revision:
+		resp.Error = ErrRevisionMismatch.Error()
+		resp.Items = []models.Item{current}
+		return
+
+	default:
+		_ = store.Update(item.Key, string(item.Value), item.Time)
+		if item.Tail {
+			_ = store.MoveToBack(item.Key)
+		}
+	}
+
+	if item.ExpiresAt != 0 {
+		_ = store.Expire(item.Key, item.ExpiresAt)
+	}
+	return
 }
@@ -0,0 +1,261 @@
+package workers
+
+import (
+	"encoding/json"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/LukaGiorgadze/bloXroute/internal/models"
+	"github.com/LukaGiorgadze/bloXroute/internal/store"
+	"github.com/LukaGiorgadze/bloXroute/internal/wal"
+)
+
+// newTestConfig returns the config of workers with the default bucket of the store type, without the message client.
+func newTestConfig(t *testing.T, storeType string) *WorkersConfig {
+	t.Helper()
+	s, err := store.New(storeType, store.Options{Shards: 4, Versions: 10}, &sync.RWMutex{}, &sync.Mutex{}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &WorkersConfig{Bucket: Bucket{Store: s}}
+}
+
+// mutate applies the mutation to the default bucket, like the mutator does.
+func mutate(cfg *WorkersConfig, item models.Msg) models.Response {
+	if item.Time == 0 {
+		item.Time = time.Now().UnixNano()
+	}
+	o := NewOnceMutator(cfg)
+	b, _, unlock := o.lock(&item)
+	defer unlock()
+	return o.apply(b, &item)
+}
+
+func TestIncrement(t *testing.T) {
+	tests := []struct {
+		name    string
+		initial models.Value
+		by      int64
+		want    string
+		err     error
+	}{
+		{name: "missing item", by: 2, want: "2"},
+		{name: "number", initial: models.Value("5"), by: 1, want: "6"},
+		{name: "numeric string", initial: models.StringValue("5"), by: 1, want: "6"},
+		{name: "negative numeric string", initial: models.StringValue("-5"), by: -1, want: "-6"},
+		{name: "string", initial: models.StringValue("five"), err: ErrNotCounter},
+		{name: "document", initial: models.Value(`{"n":5}`), err: ErrNotCounter},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := newTestConfig(t, store.OrderedMapType)
+			if tt.initial != "" {
+				mutate(cfg, models.Msg{Subject: ADD_ITEM, Item: models.Item{Key: "c", Value: tt.initial}})
+			}
+
+			resp := mutate(cfg, models.Msg{Subject: INCR_ITEM, Item: models.Item{Key: "c"}, By: tt.by})
+			if tt.err != nil {
+				if resp.Error != tt.err.Error() {
+					t.Fatalf("error = %q, want %q", resp.Error, tt.err)
+				}
+				return
+			}
+			if resp.Error != "" {
+				t.Fatal(resp.Error)
+			}
+			if len(resp.Items) != 1 || string(resp.Items[0].Value) != tt.want {
+				t.Fatalf("items = %v, want the counter %s", resp.Items, tt.want)
+			}
+		})
+	}
+}
+
+func TestMutationSequence(t *testing.T) {
+	tests := []struct {
+		name    string
+		msgs    []models.Msg
+		wantErr []string
+		wantSeq uint64
+	}{
+		{
+			name: "failed mutation isn't numbered",
+			msgs: []models.Msg{
+				{Subject: ADD_ITEM, Item: models.Item{Key: "a", Value: models.StringValue("1")}},
+				{Subject: ADD_ITEM, Item: models.Item{Key: "a", Value: models.StringValue("2")}},
+			},
+			wantErr: []string{"", ErrItemExists.Error()},
+			wantSeq: 1,
+		},
+		{
+			name: "expected sequence after the failed mutation",
+			msgs: []models.Msg{
+				{Subject: ADD_ITEM, Item: models.Item{Key: "a", Value: models.StringValue("1")}},
+				{Subject: DELETE_ITEM, Item: models.Item{Key: "b"}},
+				{Subject: UPDATE_ITEM, Item: models.Item{Key: "a", Value: models.StringValue("2")}, ExpectSeq: 1},
+			},
+			wantErr: []string{"", ErrItemNotFound.Error(), ""},
+			wantSeq: 2,
+		},
+		{
+			name: "producer sends the failed mutation again",
+			msgs: []models.Msg{
+				{Subject: INCR_ITEM, Item: models.Item{Key: "a"}, By: 1, Producer: "p", ProducerSeq: 1},
+				{Subject: CAS_ITEM, Item: models.Item{Key: "a", Value: models.Value("5"), Revision: 100}, Producer: "p", ProducerSeq: 2},
+				{Subject: CAS_ITEM, Item: models.Item{Key: "a", Value: models.Value("5"), Revision: 1}, Producer: "p", ProducerSeq: 2},
+				{Subject: CAS_ITEM, Item: models.Item{Key: "a", Value: models.Value("6"), Revision: 2}, Producer: "p", ProducerSeq: 2},
+			},
+			wantErr: []string{"", ErrRevisionMismatch.Error(), "", ErrDuplicateMutation.Error()},
+			wantSeq: 2,
+		},
+		{
+			name: "failed batch isn't numbered",
+			msgs: []models.Msg{
+				{Subject: ADD_ITEM, Item: models.Item{Key: "a", Value: models.StringValue("1")}},
+				{Subject: BATCH_ITEM, Ops: []models.Msg{{Op: "add", Item: models.Item{Key: "b"}}, {Op: "add", Item: models.Item{Key: "a"}}}},
+				{Subject: BATCH_ITEM, Ops: []models.Msg{{Op: "add", Item: models.Item{Key: "b"}}, {Op: "add", Item: models.Item{Key: "c"}}}},
+			},
+			wantErr: []string{"", "Batch operation 1 failed: " + ErrItemExists.Error(), ""},
+			wantSeq: 2,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := newTestConfig(t, store.OrderedMapType)
+			o := NewOnceMutator(cfg)
+			for i := range tt.msgs {
+				item := tt.msgs[i]
+				item.Time = time.Now().UnixNano()
+				resp, err := o.process(&item)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if resp.Error != tt.wantErr[i] {
+					t.Fatalf("mutation %d: error = %q, want %q", i, resp.Error, tt.wantErr[i])
+				}
+				if resp.MutationSeq != cfg.MutationSeq.Load() {
+					t.Fatalf("mutation %d: reply seq = %d, want %d", i, resp.MutationSeq, cfg.MutationSeq.Load())
+				}
+				// Changed items have the sequence of the mutation.
+				for _, got := range resp.Items {
+					stored, _ := cfg.Store.GetItem(got.Key)
+					if got.Seq != resp.MutationSeq || stored.Seq != resp.MutationSeq {
+						t.Fatalf("mutation %d: item %s seq = %d (stored %d), want %d", i, got.Key, got.Seq, stored.Seq, resp.MutationSeq)
+					}
+				}
+			}
+			if seq := cfg.MutationSeq.Load(); seq != tt.wantSeq {
+				t.Fatalf("seq = %d, want %d", seq, tt.wantSeq)
+			}
+		})
+	}
+}
+
+func TestMutationSequenceReplay(t *testing.T) {
+	dir := t.TempDir()
+	openWAL := func(cfg *WorkersConfig) {
+		w, err := wal.Open(dir, 1<<20, wal.SyncNever, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { w.Close() })
+		cfg.WAL = w
+	}
+
+	// Lanes apply mutations of different items in parallel, some of them fail.
+	cfg := newTestConfig(t, store.ShardedMapType)
+	openWAL(cfg)
+	p := NewMutatorPool(cfg, 4, nil)
+	p.Start()
+	keys := testKeys(20)
+	for i := 0; i < 5; i++ {
+		for _, key := range keys {
+			p.Dispatch(&models.Msg{Subject: ADD_ITEM, Item: models.Item{Key: key, Value: models.StringValue(strconv.Itoa(i))}, Time: time.Now().UnixNano()})
+			p.Dispatch(&models.Msg{Subject: UPDATE_ITEM, Item: models.Item{Key: key, Value: models.StringValue(strconv.Itoa(i))}, Time: time.Now().UnixNano()})
+		}
+	}
+	p.pending.Wait()
+	cfg.WAL.Close()
+
+	replayed := newTestConfig(t, store.ShardedMapType)
+	openWAL(replayed)
+	if _, err := NewOnceMutator(replayed).ReplayWAL(); err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := replayed.MutationSeq.Load(), cfg.MutationSeq.Load(); got != want || want != uint64(len(keys)*6) {
+		t.Fatalf("replayed seq = %d, want %d (%d)", got, want, len(keys)*6)
+	}
+	for _, key := range keys {
+		want, _ := cfg.Store.GetItem(key)
+		got, _ := replayed.Store.GetItem(key)
+		if got.Seq != want.Seq || got.Value != want.Value {
+			t.Fatalf("replayed %s = %+v, want %+v", key, got, want)
+		}
+	}
+}
+
+func TestReplayWALEviction(t *testing.T) {
+	w, err := wal.Open(t.TempDir(), 1<<20, wal.SyncNever, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys := testKeys(5)
+	for _, key := range keys {
+		data, _ := json.Marshal(models.Msg{Subject: ADD_ITEM, Item: models.Item{Key: key, Value: models.StringValue("v")}, Time: time.Now().UnixNano()})
+		if _, err := w.Append(data); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The victim of the sharded store is searched in all shards, the key of the mutation must not be locked then.
+	cfg := newTestConfig(t, store.ShardedMapType)
+	cfg.WAL = w
+	if cfg.Evictor, err = store.NewEvictor(store.FIFOEviction, 2, 0); err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan error, 1)
+	go func() {
+		_, err := NewOnceMutator(cfg).ReplayWAL()
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		w.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("replay deadlocked")
+	}
+
+	for i, key := range keys {
+		if _, ok := cfg.Store.GetItem(key); ok != (i >= 3) {
+			t.Fatalf("item %s exists = %v", key, ok)
+		}
+	}
+}
+
+func TestBatchStreamSeq(t *testing.T) {
+	cfg := newTestConfig(t, store.OrderedMapType)
+	cfg.dispatchSeq(5)
+	cfg.dispatchSeq(7)
+
+	// Only the batch is the message of the mutation log, the sequence of it's operation must not mark the other message applied.
+	batch := models.Msg{Subject: BATCH_ITEM, StreamSeq: 5, Time: time.Now().UnixNano(), Ops: []models.Msg{
+		{Op: "add", Item: models.Item{Key: "a", Value: models.StringValue("1")}, StreamSeq: 7},
+	}}
+	resp, err := NewOnceMutator(cfg).process(&batch)
+	if err != nil || resp.Error != "" {
+		t.Fatalf("batch: %v %v", err, resp)
+	}
+	if !cfg.seqApplied(5) {
+		t.Fatal("batch isn't applied")
+	}
+	if cfg.seqApplied(7) {
+		t.Fatal("message of the operation's sequence is applied")
+	}
+}
@@ -0,0 +1,108 @@
+package workers
+
+import (
+	"sync"
+
+	"github.com/LukaGiorgadze/bloXroute/internal/models"
+)
+
+// MutatorPool processes mutations in parallel lanes, every lane is the OnceMutator with it's own worker.
+// Mutations are hashed to the lanes by their bucket and key, so mutations of the same item are applied
+// one at a time in the order they were received, while mutations of different items don't wait for each other.
+//
+// Mutations which change many items or the buckets (batches and bucket admin messages), the ones of the ordered subjects
+// and the ones with the sequences given by the client (see checkSequence) are applied in the global order:
+// after all mutations dispatched before them and before the ones dispatched after them.
+// With the single lane every mutation is applied in the global order, like with the single worker.
+type MutatorPool struct {
+	lanes []*OnceMutator
+
+	// ordered are the subjects of mutations which are applied in the global order.
+	ordered map[string]bool
+
+	// dispatchLock keeps mutations dispatched one at a time, consumers of many subjects dispatch them concurrently.
+	dispatchLock sync.Mutex
+	// pending are the mutations dispatched to the lanes which are not processed yet.
+	pending sync.WaitGroup
+
+	workersConfig *WorkersConfig
+}
+
+// NewMutatorPool creates the pool with the number of lanes (at least one) and the subjects of mutations
+// which are applied in the global order, in addition to batches and bucket admin messages.
+func NewMutatorPool(cfg *WorkersConfig, lanes int, orderedSubjects []string) *MutatorPool {
+	if lanes < 1 {
+		lanes = 1
+	}
+	p := &MutatorPool{
+		lanes:         make([]*OnceMutator, lanes),
+		ordered:       map[string]bool{BATCH_ITEM: true, CREATE_BUCKET: true, DELETE_BUCKET: true},
+		workersConfig: cfg,
+	}
+	for i := range p.lanes {
+		p.lanes[i] = NewOnceMutator(cfg)
+	}
+	for _, subject := range orderedSubjects {
+		p.ordered[subject] = true
+	}
+	return p
+}
+
+// Start runs the workers of all lanes.
+func (p *MutatorPool) Start() {
+	for _, lane := range p.lanes {
+		go p.laneWorker(lane)
+	}
+}
+
+// laneWorker is a function that listens for messages on the Queue channel of the lane and performs mutations,
+// see OnceMutator.handle. The function is designed to run indefinitely, waiting for messages on the Queue channel.
+func (p *MutatorPool) laneWorker(lane *OnceMutator) {
+	for {
+		item := <-lane.Queue
+		lane.handle(item)
+		p.workersConfig.processedSeq(item.StreamSeq)
+		p.pending.Done()
+	}
+}
+
+// Dispatch sends the mutation to the lane of it's item. The Queue of the lane has the capacity of 1,
+// so the consumer is blocked until the lane has finished the previous mutation.
+// The mutation of the global order is processed by the consumer itself once all lanes are idle,
+// nothing else is dispatched until it's done.
+func (p *MutatorPool) Dispatch(item *models.Msg) {
+	p.dispatchLock.Lock()
+	defer p.dispatchLock.Unlock()
+
+	if p.ordered[item.Subject] || item.ExpectSeq != 0 || item.Producer != "" && item.ProducerSeq != 0 {
+		p.pending.Wait()
+		// Lanes are idle, so the mutator of the first one is used.
+		p.lanes[0].handle(item)
+		return
+	}
+
+	p.workersConfig.dispatchSeq(item.StreamSeq)
+	p.pending.Add(1)
+	p.lanes[p.lane(item)].Queue <- item
+}
+
+// lane returns the index of the lane of the item by the FNV-1a hash of it's bucket and key.
+func (p *MutatorPool) lane(item *models.Msg) int {
+	if len(p.lanes) == 1 {
+		return 0
+	}
+	h := uint32(2166136261)
+	for _, s := range []string{item.Bucket, "\x00", item.Key} {
+		for i := 0; i < len(s); i++ {
+			h ^= uint32(s[i])
+			h *= 16777619
+		}
+	}
+	return int(h % uint32(len(p.lanes)))
+}
+
+// ReplayWAL applies mutations from the write-ahead log which are not in the store yet, see OnceMutator.ReplayWAL.
+// It should be called on startup, before the lanes are started.
+func (p *MutatorPool) ReplayWAL() (int, error) {
+	return p.lanes[0].ReplayWAL()
+}
@@ -0,0 +1,100 @@
+package workers
+
+import (
+	"encoding/json"
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/LukaGiorgadze/bloXroute/internal/client"
+	"github.com/LukaGiorgadze/bloXroute/internal/models"
+	"github.com/LukaGiorgadze/bloXroute/internal/store"
+	"github.com/nats-io/nats.go"
+)
+
+func TestMutatorPoolLane(t *testing.T) {
+	tests := []struct {
+		name  string
+		lanes int
+	}{
+		{name: "single lane", lanes: 1},
+		{name: "many lanes", lanes: 4},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := NewMutatorPool(newTestConfig(t, store.ShardedMapType), tt.lanes, nil)
+			used := map[int]bool{}
+			for _, key := range testKeys(100) {
+				item := &models.Msg{Bucket: "b", Item: models.Item{Key: key}}
+				lane := p.lane(item)
+				if lane < 0 || lane >= tt.lanes {
+					t.Fatalf("lane of %s = %d", key, lane)
+				}
+				if again := p.lane(&models.Msg{Bucket: "b", Item: models.Item{Key: key}}); again != lane {
+					t.Fatalf("lane of %s = %d, then %d", key, lane, again)
+				}
+				used[lane] = true
+			}
+			if len(used) != tt.lanes {
+				t.Fatalf("keys use %d of %d lanes", len(used), tt.lanes)
+			}
+		})
+	}
+}
+
+func TestMutatorPoolOrder(t *testing.T) {
+	cfg := newTestConfig(t, store.ShardedMapType)
+	bus := newTestBus()
+	cfg.MsgClient = &testClient{bus: bus}
+	p := NewMutatorPool(cfg, 4, []string{DELETE_ITEM})
+	p.Start()
+
+	keys := testKeys(8)
+	var lock sync.Mutex
+	events := map[string][]models.Event{}
+	for _, key := range keys {
+		cfg.MsgClient.Subscribe(client.BucketSubject("", client.ItemEventsKeySubject(key)), func(msg *nats.Msg) {
+			var event models.Event
+			json.Unmarshal(msg.Data, &event)
+			lock.Lock()
+			events[event.Key] = append(events[event.Key], event)
+			lock.Unlock()
+		})
+	}
+
+	const updates = 50
+	for i := 0; i < updates; i++ {
+		for _, key := range keys {
+			p.Dispatch(&models.Msg{Subject: UPDATE_ITEM, Item: models.Item{Key: key, Value: models.StringValue(strconv.Itoa(i))}})
+		}
+	}
+	// The mutation of the ordered subject waits for all mutations dispatched before it.
+	p.Dispatch(&models.Msg{Subject: DELETE_ITEM, Item: models.Item{Key: keys[0]}})
+
+	lock.Lock()
+	defer lock.Unlock()
+	for _, key := range keys {
+		want := updates
+		if key == keys[0] {
+			want++
+		}
+		if len(events[key]) != want {
+			t.Fatalf("%s has %d events, want %d", key, len(events[key]), want)
+		}
+
+		// Events of the item are published in the order of mutations and of their sequences,
+		// events of different items may be published out of the order of sequences.
+		for i, event := range events[key] {
+			if i > 0 && event.Seq <= events[key][i-1].Seq {
+				t.Fatalf("%s: event seq %d after %d", key, event.Seq, events[key][i-1].Seq)
+			}
+			if i < updates && event.NewValue.String() != strconv.Itoa(i) {
+				t.Fatalf("%s: event %d has value %s", key, i, event.NewValue)
+			}
+		}
+	}
+	if last := events[keys[0]][updates]; last.Op != EVENT_DELETE {
+		t.Fatalf("last event of %s is %s, want delete", keys[0], last.Op)
+	}
+}
@@ -0,0 +1,200 @@
+package workers
+
+import (
+	"math"
+	"sort"
+
+	"github.com/LukaGiorgadze/bloXroute/internal/models"
+)
+
+// dispatchSeq marks the message of the mutation log as dispatched to the lane, so AppliedSeq doesn't pass it
+// until it's processed, even if later messages are applied before it by the other lanes.
+func (cfg *WorkersConfig) dispatchSeq(seq uint64) {
+	if seq == 0 {
+		return
+	}
+	cfg.appliedLock.Lock()
+	defer cfg.appliedLock.Unlock()
+
+	if cfg.pendingSeqs == nil {
+		cfg.pendingSeqs = make(map[uint64]int)
+	}
+	cfg.pendingSeqs[seq]++
+}
+
+// processedSeq marks the dispatched message as processed, whether it was applied or not.
+func (cfg *WorkersConfig) processedSeq(seq uint64) {
+	if seq == 0 {
+		return
+	}
+	cfg.appliedLock.Lock()
+	defer cfg.appliedLock.Unlock()
+
+	if cfg.pendingSeqs[seq]--; cfg.pendingSeqs[seq] <= 0 {
+		delete(cfg.pendingSeqs, seq)
+	}
+	cfg.advanceSeq()
+}
+
+// seqApplied reports whether the message of the mutation log with the sequence is already applied to the store,
+// e.g. when it's delivered again.
+func (cfg *WorkersConfig) seqApplied(seq uint64) bool {
+	cfg.appliedLock.Lock()
+	defer cfg.appliedLock.Unlock()
+
+	_, ok := cfg.appliedAbove[seq]
+	return ok || seq <= cfg.AppliedSeq
+}
+
+// markApplied marks the message of the mutation log as applied, it should be called while the item is locked.
+func (cfg *WorkersConfig) markApplied(seq uint64) {
+	cfg.appliedLock.Lock()
+	defer cfg.appliedLock.Unlock()
+
+	if seq <= cfg.AppliedSeq {
+		return
+	}
+	if cfg.appliedAbove == nil {
+		cfg.appliedAbove = make(map[uint64]struct{})
+	}
+	cfg.appliedAbove[seq] = struct{}{}
+	cfg.advanceSeq()
+}
+
+// markIndex sets AppliedIndex to the write-ahead log index of the applied mutation, if it's greater,
+// it should be called while the item is locked.
+func (cfg *WorkersConfig) markIndex(index uint64) {
+	cfg.appliedLock.Lock()
+	defer cfg.appliedLock.Unlock()
+
+	if index > cfg.AppliedIndex {
+		cfg.AppliedIndex = index
+	}
+}
+
+// advanceSeq moves AppliedSeq to the greatest applied sequence before the first pending message.
+// Messages which were never dispatched (e.g. skipped by the consumer) don't hold it back.
+// It's called under the appliedLock.
+func (cfg *WorkersConfig) advanceSeq() {
+	limit := uint64(math.MaxUint64)
+	for seq := range cfg.pendingSeqs {
+		if seq <= limit {
+			limit = seq - 1
+		}
+	}
+	for seq := range cfg.appliedAbove {
+		if seq <= limit {
+			if seq > cfg.AppliedSeq {
+				cfg.AppliedSeq = seq
+			}
+			delete(cfg.appliedAbove, seq)
+		}
+	}
+}
+
+// appliedPosition returns the applied sequences of the mutation log and the write-ahead log index,
+// for the snapshot. Sequences applied after AppliedSeq are returned in order.
+func (cfg *WorkersConfig) appliedPosition() (seq uint64, above []uint64, index uint64) {
+	cfg.appliedLock.Lock()
+	defer cfg.appliedLock.Unlock()
+
+	for s := range cfg.appliedAbove {
+		above = append(above, s)
+	}
+	sort.Slice(above, func(i, j int) bool { return above[i] < above[j] })
+	return cfg.AppliedSeq, above, cfg.AppliedIndex
+}
+
+// resetApplied sets the applied sequences of the mutation log restored from the snapshot.
+func (cfg *WorkersConfig) resetApplied(seq uint64, above []uint64) {
+	cfg.appliedLock.Lock()
+	defer cfg.appliedLock.Unlock()
+
+	cfg.AppliedSeq = seq
+	cfg.appliedAbove = make(map[uint64]struct{}, len(above))
+	for _, s := range above {
+		if s > seq {
+			cfg.appliedAbove[s] = struct{}{}
+		}
+	}
+}
+
+// checkSequence checks the sequences given by the client before the mutation is numbered: the expected sequence
+// of the last applied mutation and the sequence of the producer, which should be greater than it's last one.
+// Mutations with them are applied in the global order (see MutatorPool), so no other mutation is numbered in between.
+func (cfg *WorkersConfig) checkSequence(item *models.Msg) error {
+	if item.ExpectSeq != 0 && item.ExpectSeq != cfg.MutationSeq.Load() {
+		return ErrSeqMismatch
+	}
+	if item.Producer == "" || item.ProducerSeq == 0 {
+		return nil
+	}
+
+	cfg.producersLock.Lock()
+	defer cfg.producersLock.Unlock()
+
+	if item.ProducerSeq <= cfg.producers[item.Producer] {
+		return ErrDuplicateMutation
+	}
+	return nil
+}
+
+// sequence numbers the applied mutation by the next sequence and records the sequence of it's producer.
+// Mutations which failed are not numbered, so they don't change the sequence expected by the clients
+// and the producer can send the mutation with the same sequence again.
+// Records of the leader keep their sequence. Items removed by the leader itself (expired or evicted) aren't numbered,
+// like on the leader, neither are the ones removed with the entries of the Raft leader's reaper.
+// It should be called while the item is locked, see OnceMutator.number.
+func (cfg *WorkersConfig) sequence(item *models.Msg) {
+	switch {
+	case item.Subject == REMOVE_ITEM || item.Subject == REAP_ITEMS:
+		return
+	case item.Seq == 0:
+		item.Seq = cfg.MutationSeq.Add(1)
+	default:
+		for current := cfg.MutationSeq.Load(); item.Seq > current; current = cfg.MutationSeq.Load() {
+			if cfg.MutationSeq.CompareAndSwap(current, item.Seq) {
+				break
+			}
+		}
+	}
+
+	if item.Producer == "" || item.ProducerSeq == 0 {
+		return
+	}
+	cfg.producersLock.Lock()
+	defer cfg.producersLock.Unlock()
+
+	if cfg.producers == nil {
+		cfg.producers = make(map[string]uint64)
+	}
+	if item.ProducerSeq > cfg.producers[item.Producer] {
+		cfg.producers[item.Producer] = item.ProducerSeq
+	}
+}
+
+// producerSeqs returns the copy of the sequences of the producers, for the snapshot.
+func (cfg *WorkersConfig) producerSeqs() map[string]uint64 {
+	cfg.producersLock.Lock()
+	defer cfg.producersLock.Unlock()
+
+	if len(cfg.producers) == 0 {
+		return nil
+	}
+	seqs := make(map[string]uint64, len(cfg.producers))
+	for producer, seq := range cfg.producers {
+		seqs[producer] = seq
+	}
+	return seqs
+}
+
+// resetProducers sets the sequences of the producers restored from the snapshot.
+func (cfg *WorkersConfig) resetProducers(seqs map[string]uint64) {
+	cfg.producersLock.Lock()
+	defer cfg.producersLock.Unlock()
+
+	cfg.producers = make(map[string]uint64, len(seqs))
+	for producer, seq := range seqs {
+		cfg.producers[producer] = seq
+	}
+}
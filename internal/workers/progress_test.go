@@ -0,0 +1,104 @@
+package workers
+
+import (
+	"testing"
+)
+
+func TestAppliedSeq(t *testing.T) {
+	type step struct {
+		// op is dispatch, apply or process.
+		op  string
+		seq uint64
+	}
+	tests := []struct {
+		name      string
+		steps     []step
+		wantSeq   uint64
+		wantAbove []uint64
+	}{
+		{
+			name:    "in order",
+			steps:   []step{{"dispatch", 1}, {"apply", 1}, {"process", 1}, {"dispatch", 2}, {"apply", 2}, {"process", 2}},
+			wantSeq: 2,
+		},
+		{
+			name:      "later message applied first",
+			steps:     []step{{"dispatch", 1}, {"dispatch", 2}, {"apply", 2}, {"process", 2}},
+			wantAbove: []uint64{2},
+		},
+		{
+			name:    "earlier message applied later",
+			steps:   []step{{"dispatch", 1}, {"dispatch", 2}, {"apply", 2}, {"process", 2}, {"apply", 1}, {"process", 1}},
+			wantSeq: 2,
+		},
+		{
+			name:    "messages which were never dispatched",
+			steps:   []step{{"apply", 3}},
+			wantSeq: 3,
+		},
+		{
+			name:    "message processed without being applied",
+			steps:   []step{{"dispatch", 1}, {"dispatch", 2}, {"apply", 2}, {"process", 2}, {"process", 1}},
+			wantSeq: 2,
+		},
+		{
+			name:      "redelivered message pending twice",
+			steps:     []step{{"dispatch", 1}, {"dispatch", 1}, {"dispatch", 2}, {"apply", 2}, {"process", 2}, {"process", 1}},
+			wantAbove: []uint64{2},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &WorkersConfig{}
+			for _, s := range tt.steps {
+				switch s.op {
+				case "dispatch":
+					cfg.dispatchSeq(s.seq)
+				case "apply":
+					cfg.markApplied(s.seq)
+				case "process":
+					cfg.processedSeq(s.seq)
+				}
+			}
+
+			seq, above, _ := cfg.appliedPosition()
+			if seq != tt.wantSeq || !equalSeqs(above, tt.wantAbove) {
+				t.Fatalf("applied = %d %v, want %d %v", seq, above, tt.wantSeq, tt.wantAbove)
+			}
+			for _, s := range tt.steps {
+				if s.op == "apply" && !cfg.seqApplied(s.seq) {
+					t.Fatalf("seq %d isn't applied", s.seq)
+				}
+			}
+		})
+	}
+}
+
+func TestResetApplied(t *testing.T) {
+	cfg := &WorkersConfig{}
+	cfg.markApplied(9)
+	cfg.resetApplied(5, []uint64{3, 7})
+
+	seq, above, _ := cfg.appliedPosition()
+	if seq != 5 || !equalSeqs(above, []uint64{7}) {
+		t.Fatalf("applied = %d %v, want 5 [7]", seq, above)
+	}
+	for s, want := range map[uint64]bool{4: true, 5: true, 6: false, 7: true, 9: false} {
+		if cfg.seqApplied(s) != want {
+			t.Fatalf("seq %d applied = %v, want %v", s, !want, want)
+		}
+	}
+}
+
+func equalSeqs(a, b []uint64) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
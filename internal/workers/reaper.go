@@ -0,0 +1,90 @@
+package workers
+
+import (
+	"log"
+	"time"
+
+	"github.com/LukaGiorgadze/bloXroute/internal/models"
+)
+
+// Reaper periodically removes expired items from the store.
+// Expired items are not returned by reads even before they are removed,
+// the reaper only frees the memory they hold.
+type Reaper struct {
+	interval      time.Duration
+	workersConfig *WorkersConfig
+}
+
+func NewReaper(interval time.Duration, cfg *WorkersConfig) *Reaper {
+	return &Reaper{
+		interval:      interval,
+		workersConfig: cfg,
+	}
+}
+
+// ReaperWorker removes expired items of all buckets every interval under the store's write lock
+// and publishes expire events of the removed items. Old changes are removed from the history of items as well.
+// If the bounded store still exceeds it's limits (e.g. they were lowered and the store was restored from the snapshot),
+// items are evicted from it.
+// The function is designed to run indefinitely, zero (or negative) interval disables the reaper.
+func (r *Reaper) ReaperWorker() {
+	if r.interval <= 0 {
+		return
+	}
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+
+	for now := range ticker.C {
+		for _, b := range r.workersConfig.allBuckets() {
+			r.reap(b, now.UnixNano())
+		}
+	}
+}
+
+// reap removes expired items of the bucket (and evicts items if it's still over the limits) and publishes their events.
+// The follower only removes old changes, expired and evicted items are removed with the records of the leader.
+// Nodes of the Raft cluster remove them with the entry proposed by the leader, so they are removed at the same
+// point of the log on every node.
+func (r *Reaper) reap(b *Bucket, now int64) {
+	if b.History != nil {
+		b.History.RemoveOld(now)
+	}
+
+	if c := r.workersConfig.Consensus; c != nil {
+		if !c.Leading() {
+			return
+		}
+		resp, err := c.propose(&models.Msg{Subject: REAP_ITEMS, Bucket: b.Spec.Name, Time: now})
+		if err != nil {
+			log.Println(err)
+		} else if resp != nil && resp.Error != "" {
+			log.Println("reaper:", resp.Error)
+		}
+		return
+	}
+
+	following, release := r.workersConfig.holdRole()
+	defer release()
+	if following {
+		return
+	}
+
+	b.Store.Lock().Lock()
+	events := expire(r.workersConfig, b, now)
+	r.workersConfig.replicateRemovals(events)
+	commit(b.Store)
+	b.Store.Lock().Unlock()
+
+	publishEvents(r.workersConfig, events)
+}
+
+// expire removes items of the bucket expired at the given time, evicts items if it's still over the limits
+// and returns their events. It should be called while the store is locked exclusively.
+func expire(cfg *WorkersConfig, b *Bucket, at int64) []models.Event {
+	keys := b.Store.RemoveExpired(at)
+	events := make([]models.Event, len(keys))
+	for i, key := range keys {
+		events[i] = newExpireEvent(cfg, b, key, at)
+	}
+	return append(events, evict(cfg, b, at)...)
+}
@@ -0,0 +1,803 @@
+package workers
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"log"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/LukaGiorgadze/bloXroute/internal/client"
+	"github.com/LukaGiorgadze/bloXroute/internal/models"
+	"github.com/nats-io/nats.go"
+)
+
+const (
+	LeaderRole   = "leader"
+	FollowerRole = "follower"
+
+	// REMOVE_ITEM is the subject of the records of items removed by the leader itself,
+	// i.e. expired by the reaper or evicted from the bounded store. Op is the event of the removal.
+	REMOVE_ITEM = "replication.remove"
+)
+
+const (
+	// syncPageSize is the maximum size of records (or of the snapshot chunk) sent in one sync reply,
+	// so the reply fits in the message with the default NATS max payload of 1MB.
+	syncPageSize = 256 * 1024
+
+	// syncTimeout is the maximum time to wait for the leader's sync reply.
+	syncTimeout = 5 * time.Second
+
+	// pendingHeartbeats is the maximum number of the leader's heartbeats the follower waits to catch up with.
+	pendingHeartbeats = 64
+)
+
+var (
+	ErrUnknownRole     = errors.New("Unknown replication role.")
+	ErrNotLeader       = errors.New("Not the leader.")
+	ErrStale           = errors.New("Replica is stale.")
+	ErrSnapshotExpired = errors.New("Snapshot expired.")
+)
+
+// ReplicationOptions configure the replica.
+type ReplicationOptions struct {
+	// Role is the role the replica starts with: leader or follower.
+	Role string
+	// Node is the unique name of the replica.
+	Node string
+	// LogSize is the number of recent records kept by the replica,
+	// followers which are behind the leader by more than that catch up from the snapshot.
+	LogSize int
+	// Heartbeat is the interval of the status published by the replica.
+	Heartbeat time.Duration
+	// MaxStaleness is how long the follower can be behind the leader, it doesn't serve reads after that.
+	MaxStaleness time.Duration
+	// OnLead is called when the follower is promoted to the leader, so it starts receiving mutations.
+	// OnFollow is called when the leader steps down.
+	OnLead   func() error
+	OnFollow func()
+	// OnStale is called when the replica becomes stale, so it stops receiving reads (e.g. leaves the read queue group).
+	// OnCaughtUp is called when it's not stale anymore, also when the worker starts and the replica isn't stale.
+	OnStale    func()
+	OnCaughtUp func() error
+}
+
+// Replicator keeps replicas of the store consistent: the leader applies mutations and numbers them
+// with the term and the sequence, followers apply these records in the same order.
+//
+// Records are published on the replication log subject as they are applied. The follower which misses
+// some of them (or starts behind the leader) requests the records after the last one it applied,
+// or the snapshot of the leader's state if the leader doesn't keep them anymore.
+// Every replica publishes it's status in heartbeats, followers use the ones of the leader
+// to find out how far behind they are.
+//
+// The follower is promoted to the leader by the promote request, it starts the next term.
+// The previous leader steps down when it sees the heartbeat of the newer term.
+type Replicator struct {
+	opts          ReplicationOptions
+	workersConfig *WorkersConfig
+
+	// mutator applies the records of the leader. Mutations of the clients are applied by the other one,
+	// which rejects them while the replica is the follower.
+	mutator     *OnceMutator
+	snapshotter *Snapshotter
+
+	// roleLock is held (shared) while mutations are processed and expired items are removed,
+	// and exclusively while the role changes, so it doesn't change in the middle of the mutation.
+	roleLock  sync.RWMutex
+	following atomic.Bool
+
+	// lock guards the fields below.
+	lock sync.Mutex
+	role string
+	// outbox are the records numbered by the leader which aren't published yet. They are published
+	// by flush after the lock is released, publishLock keeps them in order.
+	outbox      []models.Msg
+	publishLock sync.Mutex
+	// term is incremented by every promotion, the follower keeps the term of it's leader.
+	term   uint64
+	leader string
+	// lastTerm and lastSeq number the last record applied by the replica.
+	lastTerm, lastSeq uint64
+	// log keeps recent records, they are numbered one after another after the prevTerm and the prevSeq.
+	log               []models.Msg
+	prevTerm, prevSeq uint64
+	// diverged is set when the follower applied mutations which were never numbered by the leader,
+	// e.g. the ones replayed from the write-ahead log after it was the leader. It needs the snapshot then.
+	diverged bool
+
+	// synced is set when the follower caught up with the leader of it's term,
+	// heartbeats are the ones of the leader it waits to catch up with. They are used by the worker only.
+	synced     bool
+	heartbeats []heartbeat
+	// stale is the staleness the OnStale and OnCaughtUp were called for, it's used by the worker only.
+	// The replica starts stale, so OnCaughtUp is called once the worker finds out it's not.
+	stale bool
+
+	// caughtUp is the time (Unix nanoseconds) when the follower had the records of the leader's heartbeat.
+	caughtUp atomic.Int64
+
+	// snapshot is the recent snapshot of the leader, it's sent to followers in chunks.
+	snapshot     *models.ReplicationSnapshot
+	snapshotLock sync.Mutex
+
+	received   chan models.Msg
+	statuses   chan models.ReplicationStatus
+	promotions chan string
+	stop       chan struct{}
+}
+
+// heartbeat is the sequence of the leader's heartbeat and the time when it was received.
+type heartbeat struct {
+	seq uint64
+	at  int64
+}
+
+// NewReplicator creates the replicator of the replica and sets it in the workers configuration.
+// It should be created before the snapshot is loaded, since the snapshot has the position of the replica.
+func NewReplicator(opts ReplicationOptions, cfg *WorkersConfig) (*Replicator, error) {
+	if opts.Role != LeaderRole && opts.Role != FollowerRole {
+		return nil, ErrUnknownRole
+	}
+
+	r := &Replicator{
+		opts:          opts,
+		workersConfig: cfg,
+		mutator:       NewOnceMutator(cfg),
+		role:          opts.Role,
+		stale:         true,
+		received:      make(chan models.Msg, 1024),
+		statuses:      make(chan models.ReplicationStatus, 16),
+		promotions:    make(chan string, 1),
+		stop:          make(chan struct{}),
+	}
+	if opts.Role == LeaderRole {
+		r.term = 1
+		r.leader = opts.Node
+	}
+	r.following.Store(opts.Role == FollowerRole)
+	cfg.Replicator = r
+	return r, nil
+}
+
+// Start subscribes to the replication subjects and runs the ReplicationWorker.
+// It should be called after the write-ahead log is replayed.
+func (r *Replicator) Start(snapshotter *Snapshotter) error {
+	r.snapshotter = snapshotter
+	msgClient := r.workersConfig.MsgClient
+
+	err := msgClient.Subscribe(client.ReplicationLogSubject, r.enqueue)
+	if err != nil {
+		return err
+	}
+
+	err = msgClient.Subscribe(client.ReplicationHeartbeatSubject, func(msg *nats.Msg) {
+		var status models.ReplicationStatus
+		if err := json.Unmarshal(msg.Data, &status); err != nil {
+			log.Println(err)
+			return
+		}
+		select {
+		case r.statuses <- status:
+		default:
+		}
+	})
+	if err != nil {
+		return err
+	}
+
+	err = msgClient.Subscribe(client.ReplicationPromoteSubject, func(msg *nats.Msg) {
+		var status models.ReplicationStatus
+		if err := json.Unmarshal(msg.Data, &status); err != nil || status.Node != r.opts.Node {
+			return
+		}
+		select {
+		case r.promotions <- client.ReplySubject(msg):
+		default:
+		}
+	})
+	if err != nil {
+		return err
+	}
+
+	// The leader serves followers right away, the snapshot is taken while the stores are locked.
+	err = msgClient.Subscribe(client.ReplicationSyncSubject, r.serveSync)
+	if err != nil {
+		return err
+	}
+
+	go r.ReplicationWorker()
+	return nil
+}
+
+// enqueue passes the record of the replication log to the ReplicationWorker.
+// Records are dropped when the worker is busy (e.g. catching up with the leader), missed ones are requested again.
+func (r *Replicator) enqueue(msg *nats.Msg) {
+	var rec models.Msg
+	if err := json.Unmarshal(msg.Data, &rec); err != nil {
+		log.Println(err)
+		return
+	}
+	select {
+	case r.received <- rec:
+	default:
+	}
+}
+
+// Stop stops the ReplicationWorker and unsubscribes from the replication subjects.
+func (r *Replicator) Stop() {
+	close(r.stop)
+	for _, subj := range []client.Subject{client.ReplicationLogSubject, client.ReplicationHeartbeatSubject, client.ReplicationPromoteSubject, client.ReplicationSyncSubject} {
+		r.workersConfig.MsgClient.Unsubscribe(subj)
+	}
+}
+
+// ReplicationWorker applies the records received from the leader, follows heartbeats of other replicas,
+// handles the promotion and publishes the heartbeat of the replica every interval.
+// The follower catches up with the leader first. Changes of the staleness are checked after every event.
+// The function is designed to run until the replicator is stopped.
+func (r *Replicator) ReplicationWorker() {
+	ticker := time.NewTicker(r.opts.Heartbeat)
+	defer ticker.Stop()
+
+	r.heartbeat()
+	if r.following.Load() {
+		r.sync()
+	}
+	r.checkStale()
+
+	for {
+		select {
+		case rec := <-r.received:
+			r.receive(rec)
+		case status := <-r.statuses:
+			r.observe(status)
+		case reply := <-r.promotions:
+			r.promote(reply)
+		case <-ticker.C:
+			r.heartbeat()
+		case <-r.stop:
+			return
+		}
+		r.checkStale()
+	}
+}
+
+// checkStale calls OnStale when the replica becomes stale and OnCaughtUp when it's not stale anymore.
+func (r *Replicator) checkStale() {
+	stale := r.Stale()
+	if stale == r.stale {
+		return
+	}
+	r.stale = stale
+
+	switch {
+	case stale:
+		log.Println("replication: stale, not serving reads")
+		if r.opts.OnStale != nil {
+			r.opts.OnStale()
+		}
+	case r.opts.OnCaughtUp != nil:
+		if err := r.opts.OnCaughtUp(); err != nil {
+			log.Println(err)
+		}
+	}
+}
+
+// Stale reports whether the follower is behind the leader longer than the staleness bound,
+// it must not serve reads then. The leader is never stale.
+func (r *Replicator) Stale() bool {
+	if !r.following.Load() {
+		return false
+	}
+	return time.Since(time.Unix(0, r.caughtUp.Load())) > r.opts.MaxStaleness
+}
+
+// Status returns the status of the replica, which is published in it's heartbeat.
+func (r *Replicator) Status() models.ReplicationStatus {
+	r.lock.Lock()
+	status := models.ReplicationStatus{Node: r.opts.Node, Role: r.role, Term: r.term, Seq: r.lastSeq}
+	r.lock.Unlock()
+	status.Stale = r.Stale()
+	return status
+}
+
+// position returns the term and the sequence of the last record applied by the replica.
+func (r *Replicator) position() (term, seq uint64) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return r.lastTerm, r.lastSeq
+}
+
+// reset sets the position of the replica after it's state was restored from the snapshot.
+// The leader starts the next term, since it could apply records after the snapshot which it doesn't have anymore.
+func (r *Replicator) reset(term, seq uint64) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	r.lastTerm, r.lastSeq = term, seq
+	r.prevTerm, r.prevSeq = term, seq
+	r.log = nil
+	r.diverged = false
+	if r.role == LeaderRole && r.term <= term {
+		r.term = term + 1
+	} else if r.term < term {
+		r.term = term
+	}
+}
+
+// append numbers the mutation applied by the leader and publishes the record to the followers,
+// the follower remembers the position of the record it applied. Both of them keep the record in the log,
+// so the follower can serve it after it's promoted.
+// It should be called right after the mutation is applied, while the store is locked,
+// so records are numbered in the order of mutations.
+func (r *Replicator) append(item *models.Msg) {
+	if r.number(item) {
+		r.flush()
+	}
+}
+
+// number numbers the record and keeps it in the log, it reports whether the leader has it in the outbox.
+func (r *Replicator) number(item *models.Msg) bool {
+	rec := *item
+	rec.Reply, rec.Ack, rec.Replayed = "", nil, false
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	leading := r.role == LeaderRole
+	if leading {
+		r.lastTerm, r.lastSeq = r.term, r.lastSeq+1
+		rec.ReplicationTerm, rec.ReplicationSeq = r.lastTerm, r.lastSeq
+		r.outbox = append(r.outbox, rec)
+	} else {
+		if rec.ReplicationSeq == 0 {
+			r.diverged = true
+			return false
+		}
+		r.lastTerm, r.lastSeq = rec.ReplicationTerm, rec.ReplicationSeq
+	}
+
+	r.log = append(r.log, rec)
+	if len(r.log) > r.opts.LogSize {
+		r.prevTerm, r.prevSeq = r.log[0].ReplicationTerm, r.log[0].ReplicationSeq
+		r.log = r.log[1:]
+	}
+	return leading
+}
+
+// flush publishes the records of the outbox in the order they were numbered.
+// The record numbered by the other mutation meanwhile is published by whichever of them flushes first.
+func (r *Replicator) flush() {
+	r.publishLock.Lock()
+	defer r.publishLock.Unlock()
+
+	r.lock.Lock()
+	records := r.outbox
+	r.outbox = nil
+	r.lock.Unlock()
+
+	for _, rec := range records {
+		r.publish(rec)
+	}
+}
+
+// publish publishes the record on the replication log subject.
+func (r *Replicator) publish(rec models.Msg) {
+	data, err := json.Marshal(rec)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	if err := r.workersConfig.MsgClient.Publish(client.ReplicationLogSubject, data); err != nil {
+		log.Println(err)
+	}
+}
+
+// index returns the index of the log after which the records follow the one with the term and the sequence.
+// It's false if the log doesn't have the record, e.g. it was trimmed already or it was never numbered by this leader.
+// It should be called under the lock.
+func (r *Replicator) index(term, seq uint64) (int, bool) {
+	if term == r.prevTerm && seq == r.prevSeq {
+		return 0, true
+	}
+	if seq <= r.prevSeq || seq > r.prevSeq+uint64(len(r.log)) {
+		return 0, false
+	}
+	i := int(seq - r.prevSeq)
+	return i, r.log[i-1].ReplicationTerm == term
+}
+
+// after returns the page of records after the one with the term and the sequence, more is set if there are more of them.
+// It's false if the log doesn't have the record, so the follower needs the snapshot.
+func (r *Replicator) after(term, seq uint64) (records []models.Msg, more bool, ok bool) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	start, ok := r.index(term, seq)
+	if !ok {
+		return nil, false, false
+	}
+
+	size := 0
+	for _, rec := range r.log[start:] {
+		data, err := json.Marshal(rec)
+		if err != nil {
+			continue
+		}
+		if size > 0 && size+len(data) > syncPageSize {
+			return records, true, true
+		}
+		records = append(records, rec)
+		size += len(data)
+	}
+	return records, false, true
+}
+
+// serveSync replies to the follower's sync request with the records after it's last one,
+// or with the chunk of the snapshot. Only the leader replies.
+func (r *Replicator) serveSync(msg *nats.Msg) {
+	var req models.ReplicationSync
+	if err := json.Unmarshal(msg.Data, &req); err != nil {
+		log.Println(err)
+		return
+	}
+
+	r.lock.Lock()
+	leading, term := r.role == LeaderRole, r.term
+	r.lock.Unlock()
+	if !leading {
+		return
+	}
+
+	reply := models.ReplicationSyncReply{Term: term}
+	ok := false
+	if req.Snapshot == "" && !req.Full {
+		reply.Records, reply.More, ok = r.after(req.Term, req.Seq)
+	}
+	if !ok {
+		chunk, err := r.snapshotChunk(req.Snapshot, req.Offset)
+		if err != nil {
+			reply.Error = err.Error()
+		}
+		reply.Snapshot = chunk
+	}
+
+	data, err := json.Marshal(reply)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	if err := r.workersConfig.MsgClient.Publish(client.Subject(client.ReplySubject(msg)), data); err != nil {
+		log.Println(err)
+	}
+}
+
+// snapshotChunk returns the chunk of the snapshot with the ID at the offset, the first chunk of the recent snapshot
+// for the new transfer (empty ID). The snapshot is taken again only when the log doesn't have records after it,
+// so all followers catch up from the same one.
+func (r *Replicator) snapshotChunk(id string, offset int64) (*models.ReplicationSnapshot, error) {
+	r.snapshotLock.Lock()
+	defer r.snapshotLock.Unlock()
+
+	if id == "" {
+		if r.snapshot == nil || !r.inLog(r.snapshot.Term, r.snapshot.Seq) {
+			if err := r.takeSnapshot(); err != nil {
+				return nil, err
+			}
+		}
+		offset = 0
+	}
+	if r.snapshot == nil || r.snapshot.ID != id && id != "" || offset < 0 || offset > r.snapshot.Size {
+		return nil, ErrSnapshotExpired
+	}
+
+	end := offset + syncPageSize
+	if end > r.snapshot.Size {
+		end = r.snapshot.Size
+	}
+	chunk := *r.snapshot
+	chunk.Offset, chunk.Data = offset, r.snapshot.Data[offset:end]
+	return &chunk, nil
+}
+
+// inLog reports whether the log has the records after the one with the term and the sequence.
+func (r *Replicator) inLog(term, seq uint64) bool {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	_, ok := r.index(term, seq)
+	return ok
+}
+
+// takeSnapshot takes the snapshot of the leader's state, it's called under the snapshotLock.
+func (r *Replicator) takeSnapshot() error {
+	var buf bytes.Buffer
+	header, err := r.snapshotter.snapshot(&buf)
+	if err != nil {
+		return err
+	}
+	r.snapshot = &models.ReplicationSnapshot{
+		ID:   r.opts.Node + "-" + strconv.FormatInt(time.Now().UnixNano(), 36),
+		Term: header.ReplicationTerm,
+		Seq:  header.ReplicationSeq,
+		Size: int64(buf.Len()),
+		Data: buf.Bytes(),
+	}
+	return nil
+}
+
+// receive applies the record of the leader, if it's the next one. The follower catches up with the leader
+// if it missed some records or the record is of the new term. Records of the previous terms are ignored.
+func (r *Replicator) receive(rec models.Msg) {
+	r.lock.Lock()
+	following := r.role == FollowerRole
+	term, lastSeq := r.term, r.lastSeq
+	if following && rec.ReplicationTerm > term {
+		r.term, r.leader = rec.ReplicationTerm, ""
+		r.synced = false
+	}
+	r.lock.Unlock()
+
+	switch {
+	case !following || rec.ReplicationTerm < term:
+		return
+	case rec.ReplicationTerm > term:
+		r.sync()
+	case !r.synced:
+		// It catches up on the next heartbeat of the leader.
+	case rec.ReplicationSeq <= lastSeq:
+	case rec.ReplicationSeq == lastSeq+1:
+		if err := r.apply(rec); err != nil {
+			log.Println(err)
+			r.synced = false
+			return
+		}
+		r.catchUp()
+	default:
+		r.sync()
+	}
+}
+
+// apply applies the record with the mutator of the records. Watchers were notified by the leader.
+func (r *Replicator) apply(rec models.Msg) error {
+	rec.Replayed = true
+	_, err := r.mutator.process(&rec)
+	return err
+}
+
+// observe follows the heartbeats of other leaders. The leader steps down when it sees the leader of the newer term,
+// or the one with the greater node name in the same term (so two promoted replicas don't stay leaders).
+// The follower catches up with the new leader, or when it misses records of the leader's previous heartbeat.
+func (r *Replicator) observe(status models.ReplicationStatus) {
+	if status.Node == r.opts.Node || status.Role != LeaderRole {
+		return
+	}
+
+	r.lock.Lock()
+	leading, term := r.role == LeaderRole, r.term
+	r.lock.Unlock()
+	if status.Term < term || status.Term == term && leading && status.Node < r.opts.Node {
+		return
+	}
+
+	if leading {
+		log.Printf("replication: stepping down, %s is the leader of term %d", status.Node, status.Term)
+		r.setRole(FollowerRole, status.Term)
+		if r.opts.OnFollow != nil {
+			r.opts.OnFollow()
+		}
+	}
+
+	r.lock.Lock()
+	if r.term != status.Term || r.leader != status.Node {
+		r.term, r.leader = status.Term, status.Node
+		r.synced = false
+		r.heartbeats = nil
+	}
+	lastSeq := r.lastSeq
+	r.lock.Unlock()
+
+	// Records published before the previous heartbeat should have been received already.
+	missed := len(r.heartbeats) > 0 && r.heartbeats[len(r.heartbeats)-1].seq > lastSeq
+	r.heartbeats = append(r.heartbeats, heartbeat{seq: status.Seq, at: time.Now().UnixNano()})
+	if len(r.heartbeats) > pendingHeartbeats {
+		r.heartbeats = r.heartbeats[1:]
+	}
+	if !r.synced || missed {
+		r.sync()
+	}
+	r.catchUp()
+}
+
+// catchUp marks the follower caught up as of the latest heartbeat of the leader whose records it has.
+func (r *Replicator) catchUp() {
+	_, lastSeq := r.position()
+	for len(r.heartbeats) > 0 && r.heartbeats[0].seq <= lastSeq {
+		r.caughtUp.Store(r.heartbeats[0].at)
+		r.heartbeats = r.heartbeats[1:]
+	}
+}
+
+// sync catches up with the leader: it applies the records after the last applied one page by page,
+// or restores the state from the leader's snapshot, when the leader doesn't have them.
+func (r *Replicator) sync() {
+	var transfer *models.ReplicationSnapshot
+	var data bytes.Buffer
+
+	for {
+		r.lock.Lock()
+		req := models.ReplicationSync{Node: r.opts.Node, Term: r.lastTerm, Seq: r.lastSeq, Full: r.diverged}
+		r.lock.Unlock()
+		if transfer != nil {
+			req.Snapshot, req.Offset = transfer.ID, int64(data.Len())
+		}
+
+		reply, err := r.request(req)
+		if err != nil {
+			log.Println("replication:", err)
+			return
+		}
+
+		switch {
+		case reply.Error == ErrSnapshotExpired.Error():
+			transfer = nil
+			data.Reset()
+			continue
+		case reply.Error != "":
+			log.Println("replication:", reply.Error)
+			return
+		case reply.Snapshot != nil:
+			if reply.Snapshot.Offset != int64(data.Len()) {
+				transfer = nil
+				data.Reset()
+				continue
+			}
+			transfer = reply.Snapshot
+			data.Write(reply.Snapshot.Data)
+			if int64(data.Len()) < transfer.Size {
+				continue
+			}
+			if err := r.snapshotter.replace(&data); err != nil {
+				log.Println("replication:", err)
+				return
+			}
+			log.Printf("replication: restored the snapshot of the leader at %d:%d", transfer.Term, transfer.Seq)
+			transfer = nil
+			data.Reset()
+			continue
+		}
+
+		for _, rec := range reply.Records {
+			if err := r.apply(rec); err != nil {
+				log.Println("replication:", err)
+				return
+			}
+		}
+		if !reply.More {
+			r.lock.Lock()
+			if r.term < reply.Term {
+				r.term = reply.Term
+			}
+			r.lock.Unlock()
+			r.synced = true
+			r.caughtUp.Store(time.Now().UnixNano())
+			return
+		}
+	}
+}
+
+// request sends the sync request to the leader and returns it's reply.
+func (r *Replicator) request(req models.ReplicationSync) (reply models.ReplicationSyncReply, err error) {
+	data, err := json.Marshal(req)
+	if err != nil {
+		return
+	}
+	msg, err := r.workersConfig.MsgClient.Request(client.ReplicationSyncSubject, data, syncTimeout)
+	if err != nil {
+		return
+	}
+	err = json.Unmarshal(msg.Data, &reply)
+	return
+}
+
+// promote makes the follower the leader of the next term and replies with it's status.
+func (r *Replicator) promote(reply string) {
+	var err error
+	if r.following.Load() {
+		r.lock.Lock()
+		term := r.term
+		if term < r.lastTerm {
+			term = r.lastTerm
+		}
+		r.lock.Unlock()
+
+		r.setRole(LeaderRole, term+1)
+		log.Printf("replication: promoted to the leader of term %d", term+1)
+
+		if r.opts.OnLead != nil {
+			if err = r.opts.OnLead(); err != nil {
+				log.Println(err)
+			}
+		}
+	}
+
+	status := r.Status()
+	if err != nil {
+		status.Error = err.Error()
+	}
+	r.heartbeat()
+
+	if reply == "" {
+		return
+	}
+	data, err := json.Marshal(status)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	if err := r.workersConfig.MsgClient.Publish(client.Subject(reply), data); err != nil {
+		log.Println(err)
+	}
+}
+
+// setRole changes the role and the term of the replica, after mutations being processed are done.
+func (r *Replicator) setRole(role string, term uint64) {
+	r.roleLock.Lock()
+	defer r.roleLock.Unlock()
+
+	r.lock.Lock()
+	r.role, r.term = role, term
+	if role == LeaderRole {
+		r.leader = r.opts.Node
+	}
+	r.lock.Unlock()
+
+	r.following.Store(role == FollowerRole)
+	r.synced = false
+	r.heartbeats = nil
+}
+
+// heartbeat publishes the status of the replica.
+func (r *Replicator) heartbeat() {
+	data, err := json.Marshal(r.Status())
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	if err := r.workersConfig.MsgClient.Publish(client.ReplicationHeartbeatSubject, data); err != nil {
+		log.Println(err)
+	}
+}
+
+// holdRole keeps the role of the replica from changing until release is called and reports whether it's the follower.
+// Without the replication the server is always the leader.
+func (cfg *WorkersConfig) holdRole() (following bool, release func()) {
+	r := cfg.Replicator
+	if r == nil {
+		return false, func() {}
+	}
+	r.roleLock.RLock()
+	return r.following.Load(), r.roleLock.RUnlock
+}
+
+// replicateRemovals appends the records of items removed by the leader itself (expired or evicted),
+// so followers remove them as well. It should be called while the store is locked, like append.
+func (cfg *WorkersConfig) replicateRemovals(events []models.Event) {
+	if cfg.Replicator == nil {
+		return
+	}
+	for _, event := range events {
+		cfg.Replicator.append(&models.Msg{
+			Item:    models.Item{Key: event.Key},
+			Subject: REMOVE_ITEM,
+			Op:      event.Op,
+			Bucket:  event.Bucket,
+			Time:    event.Time,
+		})
+	}
+}
@@ -0,0 +1,363 @@
+package workers
+
+import (
+	"encoding/json"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/LukaGiorgadze/bloXroute/internal/client"
+	"github.com/LukaGiorgadze/bloXroute/internal/models"
+	"github.com/LukaGiorgadze/bloXroute/internal/store"
+	"github.com/nats-io/nats.go"
+)
+
+// testBus is the in-memory message bus shared by the test clients, messages are delivered synchronously.
+type testBus struct {
+	lock     sync.Mutex
+	handlers map[client.Subject][]func(*nats.Msg)
+	inboxes  int
+}
+
+func newTestBus() *testBus {
+	return &testBus{handlers: map[client.Subject][]func(*nats.Msg){}}
+}
+
+func (b *testBus) deliver(msg *nats.Msg) {
+	b.lock.Lock()
+	handlers := append([]func(*nats.Msg){}, b.handlers[client.Subject(msg.Subject)]...)
+	b.lock.Unlock()
+	for _, handler := range handlers {
+		handler(msg)
+	}
+}
+
+// testClient is the client of the testBus, it implements client.IMessageClient.
+type testClient struct {
+	bus *testBus
+}
+
+func (c *testClient) Connect() error      { return nil }
+func (c *testClient) Disconnect() error   { return nil }
+func (c *testClient) OnDisconnect(func()) {}
+
+func (c *testClient) Publish(subject client.Subject, data []byte) error {
+	c.bus.deliver(&nats.Msg{Subject: string(subject), Data: data})
+	return nil
+}
+
+func (c *testClient) Request(subject client.Subject, data []byte, timeout time.Duration) (*nats.Msg, error) {
+	c.bus.lock.Lock()
+	c.bus.inboxes++
+	inbox := client.Subject("_INBOX." + strconv.Itoa(c.bus.inboxes))
+	c.bus.lock.Unlock()
+
+	replies := make(chan *nats.Msg, 1)
+	c.Subscribe(inbox, func(msg *nats.Msg) {
+		select {
+		case replies <- msg:
+		default:
+		}
+	})
+	defer c.Unsubscribe(inbox)
+
+	c.bus.deliver(&nats.Msg{Subject: string(subject), Data: data, Header: nats.Header{client.ReplyToHeader: []string{string(inbox)}}})
+	select {
+	case msg := <-replies:
+		return msg, nil
+	default:
+		return nil, nats.ErrTimeout
+	}
+}
+
+func (c *testClient) Subscribe(subject client.Subject, handler func(msg *nats.Msg)) error {
+	c.bus.lock.Lock()
+	defer c.bus.lock.Unlock()
+	c.bus.handlers[subject] = append(c.bus.handlers[subject], handler)
+	return nil
+}
+
+func (c *testClient) QueueSubscribe(subject client.Subject, queue string, handler func(msg *nats.Msg)) error {
+	return c.Subscribe(subject, handler)
+}
+
+// Unsubscribe removes all handlers of the subject, the test clients don't share subjects they unsubscribe from.
+func (c *testClient) Unsubscribe(subject client.Subject) {
+	c.bus.lock.Lock()
+	defer c.bus.lock.Unlock()
+	delete(c.bus.handlers, subject)
+}
+
+// newTestReplica returns the replicator of the replica with the default bucket on the bus.
+// It's subscribed to the replication subjects, but it's worker isn't running, tests drive it themselves.
+func newTestReplica(t *testing.T, bus *testBus, role, node string, logSize int) *Replicator {
+	t.Helper()
+	cfg := newTestConfig(t, store.OrderedMapType)
+	cfg.MsgClient = &testClient{bus: bus}
+	r, err := NewReplicator(ReplicationOptions{
+		Role:         role,
+		Node:         node,
+		LogSize:      logSize,
+		Heartbeat:    time.Hour,
+		MaxStaleness: time.Minute,
+	}, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.snapshotter = NewSnapshotter("", 0, cfg)
+
+	msgClient := cfg.MsgClient
+	msgClient.Subscribe(client.ReplicationLogSubject, r.enqueue)
+	msgClient.Subscribe(client.ReplicationSyncSubject, r.serveSync)
+	return r
+}
+
+// addItems adds the items with the keys and the value to the leader, like the mutator does.
+func addItems(t *testing.T, r *Replicator, keys []string, value string) {
+	t.Helper()
+	o := NewOnceMutator(r.workersConfig)
+	for _, key := range keys {
+		item := models.Msg{Subject: ADD_ITEM, Item: models.Item{Key: key, Value: models.StringValue(value)}, Time: time.Now().UnixNano()}
+		resp, err := o.process(&item)
+		if err != nil || resp.Error != "" {
+			t.Fatalf("add %s: %v %v", key, err, resp)
+		}
+	}
+}
+
+func testKeys(n int) []string {
+	keys := make([]string, n)
+	for i := range keys {
+		keys[i] = "key" + strconv.Itoa(i)
+	}
+	return keys
+}
+
+// assertReplicated checks the follower has the items with the keys and the position of the leader.
+func assertReplicated(t *testing.T, leader, follower *Replicator, keys []string) {
+	t.Helper()
+	for _, key := range keys {
+		want, _ := leader.workersConfig.Store.GetItem(key)
+		got, ok := follower.workersConfig.Store.GetItem(key)
+		if !ok || got.Value != want.Value {
+			t.Fatalf("follower item %s = %q %v, want %q", key, got.Value, ok, want.Value)
+		}
+	}
+	leaderTerm, leaderSeq := leader.position()
+	term, seq := follower.position()
+	if term != leaderTerm || seq != leaderSeq {
+		t.Fatalf("follower position = %d:%d, want %d:%d", term, seq, leaderTerm, leaderSeq)
+	}
+}
+
+func TestReplicationPromote(t *testing.T) {
+	tests := []struct {
+		name     string
+		role     string
+		term     uint64
+		lastTerm uint64
+		want     uint64
+	}{
+		{name: "follower", role: FollowerRole, term: 2, lastTerm: 1, want: 3},
+		{name: "follower with records of the later term", role: FollowerRole, term: 1, lastTerm: 4, want: 5},
+		{name: "leader", role: LeaderRole, term: 2, lastTerm: 2, want: 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bus := newTestBus()
+			r := newTestReplica(t, bus, tt.role, "b", 16)
+			r.term, r.lastTerm = tt.term, tt.lastTerm
+			led := false
+			r.opts.OnLead = func() error {
+				led = true
+				return nil
+			}
+
+			var status models.ReplicationStatus
+			(&testClient{bus: bus}).Subscribe("reply", func(msg *nats.Msg) {
+				json.Unmarshal(msg.Data, &status)
+			})
+			r.promote("reply")
+
+			if status.Role != LeaderRole || status.Term != tt.want || status.Node != "b" {
+				t.Fatalf("status = %+v, want the leader of term %d", status, tt.want)
+			}
+			if led != (tt.role == FollowerRole) {
+				t.Fatalf("OnLead called = %v", led)
+			}
+			if r.following.Load() || r.Stale() {
+				t.Fatal("promoted replica is following or stale")
+			}
+		})
+	}
+}
+
+func TestReplicationStale(t *testing.T) {
+	bus := newTestBus()
+	r := newTestReplica(t, bus, FollowerRole, "b", 16)
+	if !r.Stale() {
+		t.Fatal("follower which never caught up isn't stale")
+	}
+
+	old := time.Now().Add(-time.Hour).UnixNano()
+	r.heartbeats = []heartbeat{{seq: 2, at: old}, {seq: 5, at: time.Now().UnixNano()}}
+	r.lastSeq = 3
+	r.catchUp()
+	if !r.Stale() {
+		t.Fatal("follower behind the recent heartbeat isn't stale")
+	}
+	if len(r.heartbeats) != 1 {
+		t.Fatalf("pending heartbeats = %d, want 1", len(r.heartbeats))
+	}
+
+	r.lastSeq = 5
+	r.catchUp()
+	if r.Stale() {
+		t.Fatal("follower with the records of the recent heartbeat is stale")
+	}
+
+	leader := newTestReplica(t, bus, LeaderRole, "a", 16)
+	if leader.Stale() {
+		t.Fatal("leader is stale")
+	}
+}
+
+func TestReplicationCatchUp(t *testing.T) {
+	bus := newTestBus()
+	leader := newTestReplica(t, bus, LeaderRole, "a", 2048)
+	follower := newTestReplica(t, bus, FollowerRole, "b", 2048)
+	follower.sync()
+
+	// The worker isn't running, so records published over the capacity of the channel are dropped.
+	keys := testKeys(cap(follower.received) + 100)
+	addItems(t, leader, keys, "v")
+	if len(follower.received) != cap(follower.received) {
+		t.Fatalf("received = %d, want %d", len(follower.received), cap(follower.received))
+	}
+	for len(follower.received) > 0 {
+		follower.receive(<-follower.received)
+	}
+	if _, seq := follower.position(); seq != uint64(cap(follower.received)) {
+		t.Fatalf("follower seq = %d, want %d", seq, cap(follower.received))
+	}
+
+	// Heartbeats of the leader show the follower missed records, it requests them.
+	follower.observe(leader.Status())
+	follower.observe(leader.Status())
+	assertReplicated(t, leader, follower, keys)
+	if follower.Stale() {
+		t.Fatal("follower which caught up is stale")
+	}
+}
+
+func TestReplicationResync(t *testing.T) {
+	tests := []struct {
+		name  string
+		keys  int
+		value string
+	}{
+		{name: "one chunk", keys: 10, value: "v"},
+		// The snapshot is larger than the sync page, so it's sent in chunks.
+		{name: "chunks", keys: 10, value: strings.Repeat("v", syncPageSize/4)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bus := newTestBus()
+			leader := newTestReplica(t, bus, LeaderRole, "a", 2)
+			follower := newTestReplica(t, bus, FollowerRole, "b", 2)
+
+			// The leader doesn't keep the records of the follower anymore, so it sends the snapshot.
+			keys := testKeys(tt.keys)
+			addItems(t, leader, keys, tt.value)
+			if leader.inLog(0, 0) {
+				t.Fatal("leader keeps all records")
+			}
+			follower.sync()
+			assertReplicated(t, leader, follower, keys)
+			if !follower.synced {
+				t.Fatal("follower isn't synced")
+			}
+
+			// Records after the snapshot are sent as they are.
+			more := []string{"more0", "more1"}
+			addItems(t, leader, more, tt.value)
+			for len(follower.received) > 0 {
+				follower.receive(<-follower.received)
+			}
+			assertReplicated(t, leader, follower, append(keys, more...))
+		})
+	}
+}
+
+func TestReplicationPublishOrder(t *testing.T) {
+	bus := newTestBus()
+	leader := newTestReplica(t, bus, LeaderRole, "a", 16)
+
+	var lock sync.Mutex
+	var seqs []uint64
+	(&testClient{bus: bus}).Subscribe(client.ReplicationLogSubject, func(msg *nats.Msg) {
+		var rec models.Msg
+		json.Unmarshal(msg.Data, &rec)
+		lock.Lock()
+		seqs = append(seqs, rec.ReplicationSeq)
+		lock.Unlock()
+	})
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				leader.append(&models.Msg{Subject: ADD_ITEM, Item: models.Item{Key: strconv.Itoa(i*50 + j)}})
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	if len(seqs) != 400 {
+		t.Fatalf("published %d records, want 400", len(seqs))
+	}
+	for i, seq := range seqs {
+		if seq != uint64(i+1) {
+			t.Fatalf("record %d has seq %d", i, seq)
+		}
+	}
+}
+
+func TestReplicationStaleHooks(t *testing.T) {
+	bus := newTestBus()
+	r := newTestReplica(t, bus, FollowerRole, "b", 16)
+	var calls []string
+	r.opts.OnStale = func() { calls = append(calls, "stale") }
+	r.opts.OnCaughtUp = func() error {
+		calls = append(calls, "caught up")
+		return nil
+	}
+
+	// The follower which never caught up doesn't receive reads from the start.
+	r.checkStale()
+	r.caughtUp.Store(time.Now().UnixNano())
+	r.checkStale()
+	r.checkStale()
+	r.caughtUp.Store(time.Now().Add(-time.Hour).UnixNano())
+	r.checkStale()
+	if strings.Join(calls, ",") != "caught up,stale" {
+		t.Fatalf("calls = %v", calls)
+	}
+
+	leader := newTestReplica(t, bus, LeaderRole, "a", 16)
+	led := false
+	leader.opts.OnCaughtUp = func() error {
+		led = true
+		return nil
+	}
+	leader.checkStale()
+	if !led {
+		t.Fatal("leader doesn't receive reads")
+	}
+}
@@ -0,0 +1,318 @@
+package workers
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/LukaGiorgadze/bloXroute/internal/models"
+)
+
+// snapshotHeader is written as the first line of the snapshot file, before the store data.
+type snapshotHeader struct {
+	// StreamSeq is the mutation log sequence of the last message applied to the store at the time of snapshot.
+	// After restoring the snapshot, only messages after it need to be replayed.
+	StreamSeq uint64 `json:"streamSeq"`
+	// StreamApplied are the sequences of messages after StreamSeq which were applied by the lanes of the mutator
+	// before the earlier ones, so they are skipped when they are replayed.
+	StreamApplied []uint64 `json:"streamApplied,omitempty"`
+	// WalIndex is the write-ahead log index of the last mutation applied to the store at the time of snapshot.
+	// Records up to it are removed from the write-ahead log after the snapshot is saved.
+	WalIndex uint64 `json:"walIndex"`
+	// EventSeq is the sequence of the last change event, so the sequence continues after the snapshot is restored.
+	EventSeq uint64 `json:"eventSeq,omitempty"`
+	// MutationSeq is the sequence of the last applied mutation and Producers are the sequences of the last mutations
+	// of the producers, so mutations are numbered and deduplicated the same way after the snapshot is restored.
+	MutationSeq uint64            `json:"mutationSeq,omitempty"`
+	Producers   map[string]uint64 `json:"producers,omitempty"`
+	// History is set when the history of items of the default bucket is written in the line after the header.
+	History bool `json:"history,omitempty"`
+	// Buckets is the number of the named buckets written after that, before the items of the default bucket.
+	Buckets int `json:"buckets,omitempty"`
+	// Revision is the last revision given by the store of the default bucket, so revisions of removed items
+	// are not given again after the snapshot is restored.
+	Revision uint64 `json:"revision,omitempty"`
+	// ReplicationTerm and ReplicationSeq number the last mutation applied by the replica,
+	// so it catches up with the leader after it.
+	ReplicationTerm uint64 `json:"replicationTerm,omitempty"`
+	ReplicationSeq  uint64 `json:"replicationSeq,omitempty"`
+}
+
+// bucketHeader is written as the first line of the named bucket in the snapshot file.
+// It's followed by the history line (if History is set) and Size bytes of the store data.
+type bucketHeader struct {
+	Spec     models.Bucket `json:"spec"`
+	History  bool          `json:"history,omitempty"`
+	Size     int64         `json:"size"`
+	Revision uint64        `json:"revision,omitempty"`
+}
+
+// Snapshotter periodically saves the store to the file and loads it on startup,
+// so the server doesn't need to replay the whole mutation log to rebuild the store.
+type Snapshotter struct {
+	path          string
+	interval      time.Duration
+	workersConfig *WorkersConfig
+	// lock keeps the snapshot from being saved while the state is replaced by the leader's snapshot.
+	lock sync.Mutex
+}
+
+// NewSnapshotter creates the snapshotter of the file with the path, empty path is used by replicas which don't save
+// the snapshot, but send it to followers and receive it from the leader.
+func NewSnapshotter(path string, interval time.Duration, cfg *WorkersConfig) *Snapshotter {
+	return &Snapshotter{
+		path:          path,
+		interval:      interval,
+		workersConfig: cfg,
+	}
+}
+
+// SnapshotWorker saves the snapshot every interval.
+// The function is designed to run indefinitely, zero (or negative) interval disables periodic snapshots.
+func (s *Snapshotter) SnapshotWorker() {
+	if s.interval <= 0 {
+		return
+	}
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		if err := s.Save(); err != nil {
+			log.Println(err)
+		}
+	}
+}
+
+// Save writes the stores of all buckets with the applied mutation log sequence (and the history of items)
+// into a temporary file and then renames it to the snapshot path, so the previous snapshot is replaced only by a complete one.
+// The stores are read locked while they are written, mutations wait until it's done.
+// After that, the write-ahead log is truncated up to the saved mutations.
+func (s *Snapshotter) Save() (err error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), filepath.Base(s.path)+".*.tmp")
+	if err != nil {
+		return
+	}
+	defer func() {
+		if err != nil {
+			tmp.Close()
+			os.Remove(tmp.Name())
+		}
+	}()
+
+	w := bufio.NewWriter(tmp)
+	header, err := s.snapshot(w)
+	if err != nil {
+		return
+	}
+
+	if err = w.Flush(); err != nil {
+		return
+	}
+	if err = tmp.Sync(); err != nil {
+		return
+	}
+	if err = tmp.Close(); err != nil {
+		return
+	}
+
+	if err = os.Rename(tmp.Name(), s.path); err != nil {
+		return
+	}
+
+	// Mutations in the snapshot are not needed in the write-ahead log anymore.
+	if s.workersConfig.WAL != nil {
+		err = s.workersConfig.WAL.Truncate(header.WalIndex)
+	}
+	return
+}
+
+// snapshot writes the stores of all buckets with the applied sequences (and the history of items) to the writer.
+// The stores are read locked while they are written, mutations wait until it's done.
+func (s *Snapshotter) snapshot(w io.Writer) (header snapshotHeader, err error) {
+	// The default store is locked first, since buckets are created and deleted while it's locked,
+	// then the stores of the named buckets. So all of them are consistent with the applied sequences.
+	unlock := lockConsistent(s.workersConfig.Store)
+	buckets := s.workersConfig.allBuckets()[1:]
+	unlocks := make([]func(), len(buckets))
+	for i, b := range buckets {
+		unlocks[i] = lockConsistent(b.Store)
+	}
+	header = snapshotHeader{
+		EventSeq:    s.workersConfig.EventSeq.Load(),
+		MutationSeq: s.workersConfig.MutationSeq.Load(),
+		Producers:   s.workersConfig.producerSeqs(),
+		History:     s.workersConfig.History != nil,
+		Buckets:     len(buckets),
+		Revision:    s.workersConfig.Store.Revision(),
+	}
+	header.StreamSeq, header.StreamApplied, header.WalIndex = s.workersConfig.appliedPosition()
+	if s.workersConfig.Replicator != nil {
+		header.ReplicationTerm, header.ReplicationSeq = s.workersConfig.Replicator.position()
+	}
+	err = s.write(w, header, buckets)
+	for i := len(unlocks) - 1; i >= 0; i-- {
+		unlocks[i]()
+	}
+	unlock()
+	return
+}
+
+// write writes the header, the named buckets and the default bucket.
+// The store of the named bucket is written to the buffer first, so it's size is known before it.
+func (s *Snapshotter) write(w io.Writer, header snapshotHeader, buckets []*Bucket) error {
+	enc := json.NewEncoder(w)
+	if err := enc.Encode(header); err != nil {
+		return err
+	}
+	if header.History {
+		if err := s.workersConfig.History.Snapshot(w); err != nil {
+			return err
+		}
+	}
+
+	for _, b := range buckets {
+		var data bytes.Buffer
+		if err := b.Store.Snapshot(&data); err != nil {
+			return err
+		}
+		bh := bucketHeader{Spec: b.Spec, History: b.History != nil, Size: int64(data.Len()), Revision: b.Store.Revision()}
+		if err := enc.Encode(bh); err != nil {
+			return err
+		}
+		if bh.History {
+			if err := b.History.Snapshot(w); err != nil {
+				return err
+			}
+		}
+		if _, err := w.Write(data.Bytes()); err != nil {
+			return err
+		}
+	}
+
+	return s.workersConfig.Store.Snapshot(w)
+}
+
+// Load restores the buckets (and the history of items) from the snapshot file and sets the applied mutation log sequence.
+// It's not an error if the snapshot doesn't exist yet, the store stays empty in that case.
+func (s *Snapshotter) Load() (err error) {
+	f, err := os.Open(s.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return
+	}
+	defer f.Close()
+
+	return s.restore(bufio.NewReader(f), true)
+}
+
+// replace replaces the state of the follower by the leader's snapshot, it's named buckets are removed first.
+// The replaced state is saved to the snapshot file right away, so the write-ahead log records
+// of the previous state are not replayed on it after the restart.
+func (s *Snapshotter) replace(r io.Reader) error {
+	s.lock.Lock()
+	s.workersConfig.resetBuckets()
+	err := s.restore(bufio.NewReader(r), false)
+	s.lock.Unlock()
+	if err != nil || s.path == "" {
+		return err
+	}
+	return s.Save()
+}
+
+// restore restores the buckets (and the history of items) from the snapshot and sets the applied sequences.
+// The write-ahead log index is taken only from the local snapshot, the leader's one has the index of it's own log.
+func (s *Snapshotter) restore(r *bufio.Reader, local bool) (err error) {
+	line, err := r.ReadBytes('\n')
+	if err != nil {
+		return
+	}
+	var header snapshotHeader
+	if err = json.Unmarshal(line, &header); err != nil {
+		return
+	}
+
+	if err = s.restoreHistory(r, header.History, &s.workersConfig.Bucket); err != nil {
+		return
+	}
+
+	for i := 0; i < header.Buckets; i++ {
+		if line, err = r.ReadBytes('\n'); err != nil {
+			return
+		}
+		var bh bucketHeader
+		if err = json.Unmarshal(line, &bh); err != nil {
+			return
+		}
+
+		var b *Bucket
+		if b, err = s.workersConfig.createBucket(bh.Spec); err != nil {
+			return
+		}
+		if err = s.restoreHistory(r, bh.History, b); err != nil {
+			return
+		}
+		if err = s.restoreStore(io.LimitReader(r, bh.Size), bh.Revision, b); err != nil {
+			return
+		}
+	}
+
+	if err = s.restoreStore(r, header.Revision, &s.workersConfig.Bucket); err != nil {
+		return
+	}
+	s.workersConfig.resetApplied(header.StreamSeq, header.StreamApplied)
+	if local {
+		s.workersConfig.AppliedIndex = header.WalIndex
+	}
+	s.workersConfig.EventSeq.Store(header.EventSeq)
+	s.workersConfig.MutationSeq.Store(header.MutationSeq)
+	s.workersConfig.resetProducers(header.Producers)
+	if s.workersConfig.Replicator != nil {
+		s.workersConfig.Replicator.reset(header.ReplicationTerm, header.ReplicationSeq)
+	}
+
+	return
+}
+
+// restoreHistory reads the history line of the bucket, if it was written, and restores the history from it.
+// The history is skipped if it's disabled now.
+func (s *Snapshotter) restoreHistory(r *bufio.Reader, written bool, b *Bucket) error {
+	if !written {
+		return nil
+	}
+	line, err := r.ReadBytes('\n')
+	if err != nil {
+		return err
+	}
+	if b.History == nil {
+		return nil
+	}
+	return b.History.Restore(bytes.NewReader(line))
+}
+
+// restoreStore restores the store of the bucket with it's last revision and tracks it's items by the evictor.
+func (s *Snapshotter) restoreStore(r io.Reader, revision uint64, b *Bucket) error {
+	b.Store.Lock().Lock()
+	defer b.Store.Lock().Unlock()
+
+	if err := b.Store.Restore(r); err != nil {
+		return err
+	}
+	b.Store.SetRevision(revision)
+	commit(b.Store)
+	if b.Evictor != nil {
+		return b.Evictor.Reset(b.Store)
+	}
+	return nil
+}